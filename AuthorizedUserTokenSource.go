@@ -0,0 +1,73 @@
+package google
+
+import (
+	"net/http"
+	"net/url"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	credentials "github.com/leapforce-libraries/go_google/credentials"
+	go_token "github.com/leapforce-libraries/go_oauth2/token"
+)
+
+// AuthorizedUserTokenSource retrieves access tokens with the refresh token of authorized_user credentials,
+// as written by 'gcloud auth application-default login'
+type AuthorizedUserTokenSource struct {
+	tokenCache
+	clientId     string
+	clientSecret string
+	refreshToken string
+	tokenUrl     string
+	httpClient   *http.Client
+}
+
+type AuthorizedUserTokenSourceConfig struct {
+	CredentialsJson *credentials.CredentialsJson
+	HttpClient      *http.Client
+}
+
+func NewAuthorizedUserTokenSource(cfg *AuthorizedUserTokenSourceConfig) (*AuthorizedUserTokenSource, *errortools.Error) {
+	if cfg == nil {
+		return nil, errortools.ErrorMessage("AuthorizedUserTokenSourceConfig must not be a nil pointer")
+	}
+
+	if cfg.CredentialsJson == nil {
+		return nil, errortools.ErrorMessage("CredentialsJson not provided")
+	}
+
+	if cfg.CredentialsJson.ClientId == "" {
+		return nil, errortools.ErrorMessage("ClientId not provided")
+	}
+
+	if cfg.CredentialsJson.RefreshToken == "" {
+		return nil, errortools.ErrorMessage("RefreshToken not provided")
+	}
+
+	_tokenUrl := tokenUrl
+	if cfg.CredentialsJson.TokenUri != "" {
+		_tokenUrl = cfg.CredentialsJson.TokenUri
+	}
+
+	return &AuthorizedUserTokenSource{
+		clientId:     cfg.CredentialsJson.ClientId,
+		clientSecret: cfg.CredentialsJson.ClientSecret,
+		refreshToken: cfg.CredentialsJson.RefreshToken,
+		tokenUrl:     _tokenUrl,
+		httpClient:   cfg.HttpClient,
+	}, nil
+}
+
+// NewToken exchanges the refresh token for a new access token
+func (t *AuthorizedUserTokenSource) NewToken() (*go_token.Token, *errortools.Error) {
+	data := url.Values{}
+	data.Set("client_id", t.clientId)
+	data.Set("client_secret", t.clientSecret)
+	data.Set("refresh_token", t.refreshToken)
+	data.Set("grant_type", "refresh_token")
+
+	b, e := postTokenRequest(t.httpClient, t.tokenUrl, data, nil)
+	if e != nil {
+		return nil, e
+	}
+
+	return t.UnmarshalToken(b)
+}
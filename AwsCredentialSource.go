@@ -0,0 +1,341 @@
+package google
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	credentials "github.com/leapforce-libraries/go_google/credentials"
+)
+
+const (
+	awsEnvironmentVersion                 string = "1"
+	defaultAwsRegionalCredVerificationUrl string = "https://sts.{region}.amazonaws.com?Action=GetCallerIdentity&Version=2011-06-15"
+	awsAlgorithm                          string = "AWS4-HMAC-SHA256"
+	awsRequestType                        string = "aws4_request"
+	awsTimeFormatLong                     string = "20060102T150405Z"
+	awsTimeFormatShort                    string = "20060102"
+	awsDateHeader                         string = "X-Amz-Date"
+	awsSecurityTokenHeader                string = "X-Amz-Security-Token"
+	awsTargetResourceHeader               string = "X-Goog-Cloud-Target-Resource"
+	awsImdsv2SessionTokenHeader           string = "X-Aws-Ec2-Metadata-Token"
+	awsImdsv2SessionTtlHeader             string = "X-Aws-Ec2-Metadata-Token-Ttl-Seconds"
+	awsImdsv2SessionTtl                   string = "300"
+	awsRegionEnvVar                       string = "AWS_REGION"
+	awsDefaultRegionEnvVar                string = "AWS_DEFAULT_REGION"
+	awsAccessKeyIdEnvVar                  string = "AWS_ACCESS_KEY_ID"
+	awsSecretAccessKeyEnvVar              string = "AWS_SECRET_ACCESS_KEY"
+	awsSessionTokenEnvVar                 string = "AWS_SESSION_TOKEN"
+	awsEmptyPayloadHash                   string = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// awsSecurityCredentials are the AWS credentials the GetCallerIdentity request is signed with,
+// the json layout is the one of the EC2 metadata server
+type awsSecurityCredentials struct {
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+}
+
+type awsRequestHeader struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// awsSubjectToken is the serialized signed request that STS forwards to AWS to verify the caller
+type awsSubjectToken struct {
+	Url     string             `json:"url"`
+	Method  string             `json:"method"`
+	Headers []awsRequestHeader `json:"headers"`
+}
+
+func validateAwsEnvironmentId(environmentId string) *errortools.Error {
+	if strings.TrimPrefix(environmentId, credentialSourceEnvironmentAws) != awsEnvironmentVersion {
+		return errortools.ErrorMessagef("Unsupported AWS environment_id '%s'", environmentId)
+	}
+
+	return nil
+}
+
+// subjectTokenFromAws signs a GetCallerIdentity request with the AWS credentials of the environment
+// or of the EC2 metadata server, STS verifies the caller by sending it to AWS
+func (t *ExternalAccountTokenSource) subjectTokenFromAws(credentialSource *credentials.CredentialSource) (string, *errortools.Error) {
+	header := http.Header{}
+
+	// the metadata server is only needed if the environment lacks the region or the credentials
+	if awsRegionFromEnv() == "" || awsSecurityCredentialsFromEnv() == nil {
+		if credentialSource.Imdsv2SessionTokenUrl != "" {
+			sessionToken, e := t.awsSessionToken(credentialSource.Imdsv2SessionTokenUrl)
+			if e != nil {
+				return "", e
+			}
+			header.Set(awsImdsv2SessionTokenHeader, sessionToken)
+		}
+	}
+
+	region, e := t.awsRegion(credentialSource.RegionUrl, header)
+	if e != nil {
+		return "", e
+	}
+
+	securityCredentials, e := t.awsSecurityCredentials(credentialSource.Url, header)
+	if e != nil {
+		return "", e
+	}
+
+	verificationUrl := defaultAwsRegionalCredVerificationUrl
+	if credentialSource.RegionalCredVerificationUrl != "" {
+		verificationUrl = credentialSource.RegionalCredVerificationUrl
+	}
+
+	request, err := http.NewRequest(http.MethodPost, strings.Replace(verificationUrl, "{region}", region, 1), nil)
+	if err != nil {
+		return "", errortools.ErrorMessage(err)
+	}
+	request.Header.Set(awsTargetResourceHeader, t.credentialsJson.Audience)
+
+	signAwsRequest(request, securityCredentials, region, awsServiceName(request), time.Now().UTC())
+
+	return serializeAwsRequest(request)
+}
+
+// awsSessionToken requests an IMDSv2 session token, it is sent along with the other metadata requests
+func (t *ExternalAccountTokenSource) awsSessionToken(sessionTokenUrl string) (string, *errortools.Error) {
+	request, err := http.NewRequest(http.MethodPut, sessionTokenUrl, nil)
+	if err != nil {
+		return "", errortools.ErrorMessage(err)
+	}
+	request.Header.Set(awsImdsv2SessionTtlHeader, awsImdsv2SessionTtl)
+
+	b, e := doTokenRequest(t.httpClient, request)
+	if e != nil {
+		return "", e
+	}
+
+	return string(b), nil
+}
+
+// awsRegion returns the region from AWS_REGION, AWS_DEFAULT_REGION or the availability zone of the metadata server
+func (t *ExternalAccountTokenSource) awsRegion(regionUrl string, header http.Header) (string, *errortools.Error) {
+	if region := awsRegionFromEnv(); region != "" {
+		return region, nil
+	}
+
+	if regionUrl == "" {
+		return "", errortools.ErrorMessage("AWS region not found, set AWS_REGION or provide a region_url")
+	}
+
+	b, e := t.awsMetadata(regionUrl, header)
+	if e != nil {
+		return "", e
+	}
+
+	// the availability zone, e.g. us-east-2b, ends with a letter that is not part of the region
+	zone := strings.TrimSpace(string(b))
+	if len(zone) < 2 {
+		return "", errortools.ErrorMessagef("Invalid AWS availability zone '%s'", zone)
+	}
+
+	return zone[:len(zone)-1], nil
+}
+
+// awsSecurityCredentials returns the credentials from the environment or of the role of the metadata server
+func (t *ExternalAccountTokenSource) awsSecurityCredentials(credentialsUrl string, header http.Header) (*awsSecurityCredentials, *errortools.Error) {
+	if securityCredentials := awsSecurityCredentialsFromEnv(); securityCredentials != nil {
+		return securityCredentials, nil
+	}
+
+	if credentialsUrl == "" {
+		return nil, errortools.ErrorMessage("AWS credentials not found, set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY or provide a url")
+	}
+
+	roleName, e := t.awsMetadata(credentialsUrl, header)
+	if e != nil {
+		return nil, e
+	}
+
+	b, e := t.awsMetadata(strings.TrimSuffix(credentialsUrl, "/")+"/"+strings.TrimSpace(string(roleName)), header)
+	if e != nil {
+		return nil, e
+	}
+
+	securityCredentials := awsSecurityCredentials{}
+	err := json.Unmarshal(b, &securityCredentials)
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+
+	if securityCredentials.AccessKeyId == "" || securityCredentials.SecretAccessKey == "" {
+		return nil, errortools.ErrorMessage("AWS metadata server returned no AccessKeyId or SecretAccessKey")
+	}
+
+	return &securityCredentials, nil
+}
+
+func (t *ExternalAccountTokenSource) awsMetadata(_url string, header http.Header) ([]byte, *errortools.Error) {
+	request, err := http.NewRequest(http.MethodGet, _url, nil)
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+
+	return doTokenRequest(t.httpClient, request)
+}
+
+func awsRegionFromEnv() string {
+	if region := os.Getenv(awsRegionEnvVar); region != "" {
+		return region
+	}
+
+	return os.Getenv(awsDefaultRegionEnvVar)
+}
+
+func awsSecurityCredentialsFromEnv() *awsSecurityCredentials {
+	accessKeyId := os.Getenv(awsAccessKeyIdEnvVar)
+	secretAccessKey := os.Getenv(awsSecretAccessKeyEnvVar)
+	if accessKeyId == "" || secretAccessKey == "" {
+		return nil
+	}
+
+	return &awsSecurityCredentials{
+		AccessKeyId:     accessKeyId,
+		SecretAccessKey: secretAccessKey,
+		Token:           os.Getenv(awsSessionTokenEnvVar),
+	}
+}
+
+// awsServiceName is the first label of the host, e.g. sts for sts.us-east-1.amazonaws.com
+func awsServiceName(request *http.Request) string {
+	return strings.Split(request.URL.Host, ".")[0]
+}
+
+// signAwsRequest adds an AWS Signature Version 4 to request, whose body must be empty
+func signAwsRequest(request *http.Request, securityCredentials *awsSecurityCredentials, region string, service string, timestamp time.Time) {
+	request.Header.Set("Host", request.URL.Host)
+	request.Header.Set(awsDateHeader, timestamp.Format(awsTimeFormatLong))
+	if securityCredentials.Token != "" {
+		request.Header.Set(awsSecurityTokenHeader, securityCredentials.Token)
+	}
+
+	signedHeaders, canonicalHeaders := awsCanonicalHeaders(request.Header)
+
+	canonicalPath := "/"
+	if escapedPath := request.URL.EscapedPath(); escapedPath != "" {
+		canonicalPath = path.Clean(escapedPath)
+	}
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		canonicalPath,
+		awsCanonicalQuery(request.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		awsEmptyPayloadHash,
+	}, "\n")
+
+	date := timestamp.Format(awsTimeFormatShort)
+	credentialScope := fmt.Sprintf("%s/%s/%s/%s", date, region, service, awsRequestType)
+
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		awsAlgorithm,
+		timestamp.Format(awsTimeFormatLong),
+		credentialScope,
+		hex.EncodeToString(canonicalRequestHash[:]),
+	}, "\n")
+
+	signature := []byte("AWS4" + securityCredentials.SecretAccessKey)
+	for _, s := range []string{date, region, service, awsRequestType, stringToSign} {
+		signature = hmacSha256(signature, s)
+	}
+
+	request.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", awsAlgorithm, securityCredentials.AccessKeyId, credentialScope, signedHeaders, hex.EncodeToString(signature)))
+}
+
+// awsCanonicalQuery returns the query sorted by name and value, encoded as SigV4 requires: spaces are %20, not +
+func awsCanonicalQuery(query url.Values) string {
+	encoded := make(map[string][]string)
+	var keys []string
+	for key, values := range query {
+		_key := awsUriEncode(key)
+		keys = append(keys, _key)
+		for _, value := range values {
+			encoded[_key] = append(encoded[_key], awsUriEncode(value))
+		}
+		sort.Strings(encoded[_key])
+	}
+	sort.Strings(keys)
+
+	var parameters []string
+	for _, key := range keys {
+		for _, value := range encoded[key] {
+			parameters = append(parameters, key+"="+value)
+		}
+	}
+
+	return strings.Join(parameters, "&")
+}
+
+// awsUriEncode escapes every byte except the unreserved characters A-Z, a-z, 0-9, '-', '.', '_' and '~'
+func awsUriEncode(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// awsCanonicalHeaders returns the sorted lowercase header names and the canonical headers block
+func awsCanonicalHeaders(header http.Header) (string, string) {
+	names := []string{}
+	for key := range header {
+		names = append(names, strings.ToLower(key))
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.Join(header.Values(name), ",") + "\n")
+	}
+
+	return strings.Join(names, ";"), canonicalHeaders.String()
+}
+
+func hmacSha256(key []byte, s string) []byte {
+	hash := hmac.New(sha256.New, key)
+	hash.Write([]byte(s))
+
+	return hash.Sum(nil)
+}
+
+// serializeAwsRequest returns the url encoded json of the signed request, the subject token format STS expects
+func serializeAwsRequest(request *http.Request) (string, *errortools.Error) {
+	subjectToken := awsSubjectToken{
+		Url:    request.URL.String(),
+		Method: request.Method,
+	}
+
+	for key, values := range request.Header {
+		for _, value := range values {
+			subjectToken.Headers = append(subjectToken.Headers, awsRequestHeader{Key: key, Value: value})
+		}
+	}
+	sort.Slice(subjectToken.Headers, func(i, j int) bool {
+		return subjectToken.Headers[i].Key < subjectToken.Headers[j].Key
+	})
+
+	b, err := json.Marshal(subjectToken)
+	if err != nil {
+		return "", errortools.ErrorMessage(err)
+	}
+
+	return url.QueryEscape(string(b)), nil
+}
@@ -0,0 +1,214 @@
+package google
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	credentials "github.com/leapforce-libraries/go_google/credentials"
+	testserver "github.com/leapforce-libraries/go_google/internal/testserver"
+)
+
+const testAwsAudience string = "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/pool/providers/aws"
+
+// TestSignAwsRequest uses the get-vanilla case of the AWS Signature Version 4 test suite
+func TestSignAwsRequest(t *testing.T) {
+	request, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signAwsRequest(request, &awsSecurityCredentials{
+		AccessKeyId:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if request.Header.Get("Authorization") != expected {
+		t.Errorf("Authorization is\n%s\nexpected\n%s", request.Header.Get("Authorization"), expected)
+	}
+	if request.Header.Get(awsDateHeader) != "20150830T123600Z" {
+		t.Errorf("%s is %q", awsDateHeader, request.Header.Get(awsDateHeader))
+	}
+}
+
+func TestAwsCanonicalQuery(t *testing.T) {
+	tests := []struct {
+		query     string
+		canonical string
+	}{
+		{"", ""},
+		{"Action=GetCallerIdentity&Version=2011-06-15", "Action=GetCallerIdentity&Version=2011-06-15"},
+		{"Param2=value2&Param1=value1", "Param1=value1&Param2=value2"},
+		{"b=2&b=1&a-b=3&a=4", "a=4&a-b=3&b=1&b=2"},
+		{"q=a+b&r=a%20b&s=%2A~", "q=a%20b&r=a%20b&s=%2A~"},
+		{"%C3%A9=%E2%82%AC", "%C3%A9=%E2%82%AC"},
+	}
+
+	for _, test := range tests {
+		query, err := url.ParseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if canonical := awsCanonicalQuery(query); canonical != test.canonical {
+			t.Errorf("canonical query of %q is %q, expected %q", test.query, canonical, test.canonical)
+		}
+	}
+}
+
+func clearAwsEnv(t *testing.T) {
+	for _, envVar := range []string{awsRegionEnvVar, awsDefaultRegionEnvVar, awsAccessKeyIdEnvVar, awsSecretAccessKeyEnvVar, awsSessionTokenEnvVar} {
+		t.Setenv(envVar, "")
+	}
+}
+
+// newAwsServer serves an IMDSv2 metadata server and the STS token endpoint, which returns the subject token as access token
+func newAwsServer(t *testing.T) *testserver.Server {
+	metadata := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(awsImdsv2SessionTokenHeader) != "session-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(body))
+		}
+	}
+
+	return testserver.New(t, map[string]http.HandlerFunc{
+		"PUT /latest/api/token": func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(awsImdsv2SessionTtlHeader) != awsImdsv2SessionTtl {
+				t.Errorf("session token ttl is %q", r.Header.Get(awsImdsv2SessionTtlHeader))
+			}
+			w.Write([]byte("session-token"))
+		},
+		"/latest/meta-data/placement/availability-zone":        metadata("us-east-2b"),
+		"/latest/meta-data/iam/security-credentials":           metadata("test-role"),
+		"/latest/meta-data/iam/security-credentials/test-role": metadata(`{"Code":"Success","AccessKeyId":"ASIAEXAMPLE","SecretAccessKey":"secret","Token":"aws-session-token"}`),
+		"/v1/token": func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			if r.PostForm.Get("subject_token_type") != "urn:ietf:params:aws:token-type:aws4_request" {
+				t.Errorf("subject_token_type is %q", r.PostForm.Get("subject_token_type"))
+			}
+			testserver.WriteJson(w, http.StatusOK, map[string]interface{}{
+				"access_token": r.PostForm.Get("subject_token"),
+				"expires_in":   3600,
+				"token_type":   "Bearer",
+			})
+		},
+	})
+}
+
+func newAwsTokenSource(t *testing.T, server *testserver.Server) *ExternalAccountTokenSource {
+	tokenSource, e := NewExternalAccountTokenSource(&ExternalAccountTokenSourceConfig{
+		CredentialsJson: &credentials.CredentialsJson{
+			Type:             credentials.TypeExternalAccount,
+			Audience:         testAwsAudience,
+			SubjectTokenType: "urn:ietf:params:aws:token-type:aws4_request",
+			TokenUrl:         server.URL + "/v1/token",
+			CredentialSource: &credentials.CredentialSource{
+				EnvironmentId:               "aws1",
+				RegionUrl:                   server.URL + "/latest/meta-data/placement/availability-zone",
+				Url:                         server.URL + "/latest/meta-data/iam/security-credentials",
+				RegionalCredVerificationUrl: "https://sts.{region}.amazonaws.com?Action=GetCallerIdentity&Version=2011-06-15",
+				Imdsv2SessionTokenUrl:       server.URL + "/latest/api/token",
+			},
+		},
+	})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	return tokenSource
+}
+
+// awsSubjectTokenFromExchange exchanges the subject token and decodes it from the access token of the stand-in
+func awsSubjectTokenFromExchange(t *testing.T, tokenSource *ExternalAccountTokenSource) (*awsSubjectToken, map[string]string) {
+	token, e := tokenSource.NewToken()
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	s, err := url.QueryUnescape(*token.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subjectToken := awsSubjectToken{}
+	err = json.Unmarshal([]byte(s), &subjectToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers := make(map[string]string)
+	for _, header := range subjectToken.Headers {
+		headers[header.Key] = header.Value
+	}
+
+	return &subjectToken, headers
+}
+
+func TestAwsSubjectTokenFromMetadataServer(t *testing.T) {
+	clearAwsEnv(t)
+
+	server := newAwsServer(t)
+
+	subjectToken, headers := awsSubjectTokenFromExchange(t, newAwsTokenSource(t, server))
+
+	if subjectToken.Url != "https://sts.us-east-2.amazonaws.com?Action=GetCallerIdentity&Version=2011-06-15" || subjectToken.Method != http.MethodPost {
+		t.Errorf("signed request is %s %s", subjectToken.Method, subjectToken.Url)
+	}
+
+	if headers["Host"] != "sts.us-east-2.amazonaws.com" || headers[awsTargetResourceHeader] != testAwsAudience || headers[awsSecurityTokenHeader] != "aws-session-token" {
+		t.Errorf("signed request headers are %v", headers)
+	}
+
+	prefix := "AWS4-HMAC-SHA256 Credential=ASIAEXAMPLE/" + time.Now().UTC().Format(awsTimeFormatShort) + "/us-east-2/sts/aws4_request, SignedHeaders=host;x-amz-date;x-amz-security-token;x-goog-cloud-target-resource, Signature="
+	if !strings.HasPrefix(headers["Authorization"], prefix) {
+		t.Errorf("Authorization is %q", headers["Authorization"])
+	}
+}
+
+func TestAwsSubjectTokenFromEnvironment(t *testing.T) {
+	clearAwsEnv(t)
+	t.Setenv(awsDefaultRegionEnvVar, "eu-west-1")
+	t.Setenv(awsAccessKeyIdEnvVar, "AKIDENV")
+	t.Setenv(awsSecretAccessKeyEnvVar, "secret")
+
+	server := newAwsServer(t)
+
+	subjectToken, headers := awsSubjectTokenFromExchange(t, newAwsTokenSource(t, server))
+
+	// region and credentials come from the environment, the metadata server is not used
+	for _, request := range server.Requests("") {
+		if strings.HasPrefix(request.Path, "/latest/") {
+			t.Errorf("unexpected metadata request %s", request.Path)
+		}
+	}
+
+	if !strings.HasPrefix(subjectToken.Url, "https://sts.eu-west-1.amazonaws.com") {
+		t.Errorf("signed request url is %s", subjectToken.Url)
+	}
+	if _, ok := headers[awsSecurityTokenHeader]; ok {
+		t.Errorf("signed request has a security token without AWS_SESSION_TOKEN")
+	}
+	if !strings.Contains(headers["Authorization"], "Credential=AKIDENV/") || !strings.Contains(headers["Authorization"], "/eu-west-1/sts/aws4_request, SignedHeaders=host;x-amz-date;x-goog-cloud-target-resource,") {
+		t.Errorf("Authorization is %q", headers["Authorization"])
+	}
+}
+
+func TestAwsEnvironmentIdVersion(t *testing.T) {
+	_, e := NewExternalAccountTokenSource(&ExternalAccountTokenSourceConfig{
+		CredentialsJson: &credentials.CredentialsJson{
+			Type:             credentials.TypeExternalAccount,
+			Audience:         testAwsAudience,
+			SubjectTokenType: "urn:ietf:params:aws:token-type:aws4_request",
+			CredentialSource: &credentials.CredentialSource{EnvironmentId: "aws2"},
+		},
+	})
+	if e == nil {
+		t.Fatal("expected an error for environment_id aws2")
+	}
+}
@@ -0,0 +1,276 @@
+package google
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	go_http "github.com/leapforce-libraries/go_http"
+)
+
+const (
+	maxBatchSize          int    = 100
+	batchContentIdPrefix  string = "item-"
+	batchResponseIdPrefix string = "response-item-"
+)
+
+type BatchConfig struct {
+	BatchUrl       string // batch endpoint of the api, e.g. https://www.googleapis.com/batch/gmail/v1
+	RequestConfigs []*go_http.RequestConfig
+}
+
+// BatchResult is the outcome of one request in a batch.
+// If the request succeeded, its response is decoded into the ResponseModel of its RequestConfig.
+type BatchResult struct {
+	Response      *http.Response
+	ErrorResponse *ErrorResponse
+	GoogleError   *GoogleError
+	Error         *errortools.Error
+}
+
+// HttpBatchRequest sends up to 100 requests in a single multipart/mixed request,
+// the results are returned in the order of cfg.RequestConfigs.
+// With an api key the key is added to every request in the batch as well.
+func (service *Service) HttpBatchRequest(cfg *BatchConfig) ([]BatchResult, *errortools.Error) {
+	return service.HttpBatchRequestContext(context.Background(), cfg)
+}
+
+// HttpBatchRequestContext is HttpBatchRequest honoring the cancellation, deadline and values of ctx
+func (service *Service) HttpBatchRequestContext(ctx context.Context, cfg *BatchConfig) ([]BatchResult, *errortools.Error) {
+	if cfg == nil {
+		return nil, errortools.ErrorMessage("BatchConfig must not be a nil pointer")
+	}
+
+	if cfg.BatchUrl == "" {
+		return nil, errortools.ErrorMessage("BatchUrl not provided")
+	}
+
+	if len(cfg.RequestConfigs) == 0 {
+		return nil, errortools.ErrorMessage("RequestConfigs not provided")
+	}
+
+	if len(cfg.RequestConfigs) > maxBatchSize {
+		return nil, errortools.ErrorMessagef("A batch can contain at most %v requests, %v provided", maxBatchSize, len(cfg.RequestConfigs))
+	}
+
+	body := bytes.Buffer{}
+	writer := multipart.NewWriter(&body)
+
+	// the api key of the outer request does not apply to the requests in it
+	apiKey := ""
+	if service.authorizationMode == authorizationModeApiKey {
+		apiKey = *service.apiKey
+	}
+
+	for i, requestConfig := range cfg.RequestConfigs {
+		e := writeBatchPart(writer, i, requestConfig, apiKey)
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	err := writer.Close()
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+
+	b := body.Bytes()
+	header := http.Header{}
+	header.Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+
+	// the Service authorizes the batch itself, the access token applies to the requests in it
+	_, response, _, _, e := service.httpRequest(ctx, &go_http.RequestConfig{
+		Method:            http.MethodPost,
+		Url:               cfg.BatchUrl,
+		BodyRaw:           &b,
+		NonDefaultHeaders: &header,
+	})
+	if e != nil {
+		return nil, e
+	}
+	defer response.Body.Close()
+
+	return parseBatchResponse(response, cfg.RequestConfigs)
+}
+
+// writeBatchPart writes requestConfig as part index of the batch, apiKey is added as key parameter if not empty
+func writeBatchPart(writer *multipart.Writer, index int, requestConfig *go_http.RequestConfig, apiKey string) *errortools.Error {
+	if requestConfig == nil {
+		return errortools.ErrorMessagef("RequestConfig %v is a nil pointer", index)
+	}
+
+	_url, err := url.Parse(requestConfig.FullUrl())
+	if err != nil {
+		return errortools.ErrorMessage(err)
+	}
+
+	if apiKey != "" {
+		query := _url.Query()
+		if query.Get("key") == "" {
+			query.Set("key", apiKey)
+			_url.RawQuery = query.Encode()
+		}
+	}
+
+	method := requestConfig.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var body []byte
+	if requestConfig.BodyRaw != nil {
+		body = *requestConfig.BodyRaw
+	} else if requestConfig.BodyModel != nil {
+		body, err = json.Marshal(requestConfig.BodyModel)
+		if err != nil {
+			return errortools.ErrorMessage(err)
+		}
+	}
+
+	partHeader := textproto.MIMEHeader{}
+	partHeader.Set("Content-Type", "application/http")
+	partHeader.Set("Content-ID", fmt.Sprintf("<%s%v>", batchContentIdPrefix, index+1))
+
+	part, err := writer.CreatePart(partHeader)
+	if err != nil {
+		return errortools.ErrorMessage(err)
+	}
+
+	request := fmt.Sprintf("%s %s HTTP/1.1\r\n", method, _url.RequestURI())
+
+	header := http.Header{}
+	if body != nil {
+		header.Set("Content-Type", "application/json")
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	if requestConfig.NonDefaultHeaders != nil {
+		for key, values := range *requestConfig.NonDefaultHeaders {
+			header[key] = values
+		}
+	}
+
+	buffer := bytes.Buffer{}
+	buffer.WriteString(request)
+	err = header.Write(&buffer)
+	if err != nil {
+		return errortools.ErrorMessage(err)
+	}
+	buffer.WriteString("\r\n")
+	buffer.Write(body)
+
+	_, err = part.Write(buffer.Bytes())
+	if err != nil {
+		return errortools.ErrorMessage(err)
+	}
+
+	return nil
+}
+
+func parseBatchResponse(response *http.Response, requestConfigs []*go_http.RequestConfig) ([]BatchResult, *errortools.Error) {
+	mediaType, params, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, errortools.ErrorMessagef("Batch response has content type %s instead of multipart/mixed", mediaType)
+	}
+
+	results := make([]BatchResult, len(requestConfigs))
+	received := make([]bool, len(requestConfigs))
+
+	reader := multipart.NewReader(response.Body, params["boundary"])
+
+	for position := 0; ; position++ {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errortools.ErrorMessage(err)
+		}
+
+		index := batchIndex(part.Header.Get("Content-ID"), position)
+		if index < 0 || index >= len(requestConfigs) {
+			return nil, errortools.ErrorMessagef("Batch response contains unknown Content-ID %s", part.Header.Get("Content-ID"))
+		}
+
+		subResponse, err := http.ReadResponse(bufio.NewReader(part), nil)
+		if err != nil {
+			return nil, errortools.ErrorMessage(err)
+		}
+
+		results[index] = parseBatchSubResponse(subResponse, requestConfigs[index])
+		received[index] = true
+	}
+
+	for i := range results {
+		if !received[i] {
+			results[i].Error = errortools.ErrorMessagef("Batch response contains no response for request %v", i)
+		}
+	}
+
+	return results, nil
+}
+
+// batchIndex returns the request index of a Content-ID like <response-item-3>,
+// the position in the batch response if it cannot be parsed
+func batchIndex(contentId string, position int) int {
+	contentId = strings.Trim(contentId, "<>")
+
+	i := strings.LastIndex(contentId, batchResponseIdPrefix)
+	if i < 0 {
+		return position
+	}
+
+	index, err := strconv.Atoi(contentId[i+len(batchResponseIdPrefix):])
+	if err != nil {
+		return position
+	}
+
+	return index - 1
+}
+
+func parseBatchSubResponse(response *http.Response, requestConfig *go_http.RequestConfig) BatchResult {
+	result := BatchResult{Response: response}
+
+	b, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		result.Error = errortools.ErrorMessage(err)
+		return result
+	}
+	response.Body = io.NopCloser(bytes.NewReader(b))
+
+	if response.StatusCode >= 200 && response.StatusCode <= 299 {
+		if requestConfig.ResponseModel != nil && len(b) > 0 {
+			err = json.Unmarshal(b, requestConfig.ResponseModel)
+			if err != nil {
+				result.Error = errortools.ErrorMessage(err)
+			}
+		}
+		return result
+	}
+
+	result.ErrorResponse = &ErrorResponse{}
+	_ = json.Unmarshal(b, result.ErrorResponse)
+	result.GoogleError = NewGoogleError(result.ErrorResponse, response)
+
+	e := errortools.ErrorMessagef("Server returned statuscode %v", response.StatusCode)
+	e.SetResponse(response)
+	setGoogleError(e, result.ErrorResponse, result.GoogleError)
+	result.Error = e
+
+	return result
+}
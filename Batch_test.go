@@ -0,0 +1,293 @@
+package google
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+
+	testserver "github.com/leapforce-libraries/go_google/internal/testserver"
+	go_http "github.com/leapforce-libraries/go_http"
+)
+
+// batchPart is a request in a batch as received by the fake batch endpoint
+type batchPart struct {
+	ContentType string
+	ContentId   string
+	Request     *http.Request
+	Body        string
+}
+
+// batchResponsePart is a response of the fake batch endpoint, written in the order given
+type batchResponsePart struct {
+	ContentId  string
+	StatusCode int
+	Body       string
+}
+
+// newBatchServer serves /batch/test/v1, it records the parts of the batch and responds with responseParts
+func newBatchServer(t *testing.T, responseParts []batchResponsePart) (*testserver.Server, func() []batchPart) {
+	var mutex sync.Mutex
+	var parts []batchPart
+
+	server := testserver.New(t, map[string]http.HandlerFunc{
+		"POST /batch/test/v1": func(w http.ResponseWriter, r *http.Request) {
+			mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "multipart/mixed" {
+				t.Errorf("batch request with content type %q", r.Header.Get("Content-Type"))
+				return
+			}
+
+			reader := multipart.NewReader(r.Body, params["boundary"])
+			for {
+				part, err := reader.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				request, err := http.ReadRequest(bufio.NewReader(part))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				body, _ := io.ReadAll(request.Body)
+
+				mutex.Lock()
+				parts = append(parts, batchPart{part.Header.Get("Content-Type"), part.Header.Get("Content-ID"), request, string(body)})
+				mutex.Unlock()
+			}
+
+			body := bytes.Buffer{}
+			writer := multipart.NewWriter(&body)
+			for _, responsePart := range responseParts {
+				header := map[string][]string{
+					"Content-Type": {"application/http"},
+					"Content-Id":   {responsePart.ContentId},
+				}
+				part, _ := writer.CreatePart(header)
+				fmt.Fprintf(part, "HTTP/1.1 %v %s\r\nContent-Type: application/json\r\nContent-Length: %v\r\n\r\n%s", responsePart.StatusCode, http.StatusText(responsePart.StatusCode), len(responsePart.Body), responsePart.Body)
+			}
+			writer.Close()
+
+			w.Header().Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+			w.Write(body.Bytes())
+		},
+	})
+
+	return server, func() []batchPart {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return append([]batchPart{}, parts...)
+	}
+}
+
+type testBatchItem struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestHttpBatchRequest(t *testing.T) {
+	server, parts := newBatchServer(t, []batchResponsePart{
+		{"<response-item-3>", http.StatusNotFound, `{"error":{"code":404,"message":"Item c not found","status":"NOT_FOUND"}}`},
+		{"<response-item-1>", http.StatusOK, `{"id":"a","name":"first"}`},
+		{"<response-item-2>", http.StatusTooManyRequests, `{"error":{"code":429,"message":"Rate Limit Exceeded","errors":[{"domain":"usageLimits","reason":"rateLimitExceeded","message":"Rate Limit Exceeded"}]}}`},
+		{"<response-item-4>", http.StatusOK, `{"id":"d","name":"patched"}`},
+	})
+
+	service, e := NewServiceWithAccessToken(&ServiceWithAccessTokenConfig{AccessToken: "access-token"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	items := make([]testBatchItem, 4)
+	header := http.Header{"If-Match": {"etag-d"}}
+	results, e := service.HttpBatchRequest(&BatchConfig{
+		BatchUrl: server.URL + "/batch/test/v1",
+		RequestConfigs: []*go_http.RequestConfig{
+			{Url: "https://example.googleapis.com/v1/items/a", ResponseModel: &items[0]},
+			{Url: "https://example.googleapis.com/v1/items/b", Parameters: &url.Values{"fields": {"id,name"}}, ResponseModel: &items[1]},
+			{Method: http.MethodDelete, Url: "https://example.googleapis.com/v1/items/c", ResponseModel: &items[2]},
+			{Method: http.MethodPatch, Url: "https://example.googleapis.com/v1/items/d", BodyModel: testBatchItem{Name: "patched"}, NonDefaultHeaders: &header, ResponseModel: &items[3]},
+		},
+	})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	// the batch is authorized, the parts are encoded as application/http requests in order
+	if authorization := server.Requests("/batch/test/v1")[0].Header.Get("Authorization"); authorization != "Bearer access-token" {
+		t.Errorf("batch request has Authorization %q", authorization)
+	}
+
+	expectedParts := []struct {
+		method     string
+		requestUri string
+		body       string
+	}{
+		{http.MethodGet, "/v1/items/a", ""},
+		{http.MethodGet, "/v1/items/b?fields=id%2Cname", ""},
+		{http.MethodDelete, "/v1/items/c", ""},
+		{http.MethodPatch, "/v1/items/d", `{"id":"","name":"patched"}`},
+	}
+	if len(parts()) != len(expectedParts) {
+		t.Fatalf("%v parts received, expected %v", len(parts()), len(expectedParts))
+	}
+	for i, part := range parts() {
+		expected := expectedParts[i]
+		if part.ContentType != "application/http" || part.ContentId != fmt.Sprintf("<item-%v>", i+1) {
+			t.Errorf("part %v has Content-Type %q and Content-ID %q", i, part.ContentType, part.ContentId)
+		}
+		if part.Request.Method != expected.method || part.Request.RequestURI != expected.requestUri || part.Body != expected.body {
+			t.Errorf("part %v is %s %s %q, expected %s %s %q", i, part.Request.Method, part.Request.RequestURI, part.Body, expected.method, expected.requestUri, expected.body)
+		}
+	}
+	if part := parts()[3]; part.Request.Header.Get("Content-Type") != "application/json" || part.Request.Header.Get("If-Match") != "etag-d" {
+		t.Errorf("part with body has headers %v", part.Request.Header)
+	}
+
+	// the results are in the order of the requests, not of the response parts
+	if len(results) != 4 {
+		t.Fatalf("%v results", len(results))
+	}
+	for _, i := range []int{0, 3} {
+		if results[i].Error != nil || results[i].Response.StatusCode != http.StatusOK {
+			t.Errorf("result %v is %+v", i, results[i])
+		}
+	}
+	if items[0] != (testBatchItem{"a", "first"}) || items[3] != (testBatchItem{"d", "patched"}) {
+		t.Errorf("items are %+v", items)
+	}
+
+	if results[1].Error == nil || results[1].GoogleError == nil || !results[1].GoogleError.IsRateLimited() || results[1].GoogleError.Reason != "rateLimitExceeded" {
+		t.Errorf("result 1 has GoogleError %v", results[1].GoogleError)
+	}
+	if results[2].Error == nil || results[2].GoogleError == nil || !results[2].GoogleError.IsNotFound() || results[2].GoogleError.Message != "Item c not found" {
+		t.Errorf("result 2 has GoogleError %v", results[2].GoogleError)
+	}
+	if items[1] != (testBatchItem{}) || items[2] != (testBatchItem{}) {
+		t.Errorf("failed requests decoded into %+v", items)
+	}
+}
+
+func TestHttpBatchRequestMissingResponse(t *testing.T) {
+	server, _ := newBatchServer(t, []batchResponsePart{
+		{"<response-item-2>", http.StatusOK, `{"id":"b"}`},
+	})
+
+	service, e := NewServiceWithAccessToken(&ServiceWithAccessTokenConfig{AccessToken: "access-token"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	results, e := service.HttpBatchRequest(&BatchConfig{
+		BatchUrl: server.URL + "/batch/test/v1",
+		RequestConfigs: []*go_http.RequestConfig{
+			{Url: "https://example.googleapis.com/v1/items/a"},
+			{Url: "https://example.googleapis.com/v1/items/b"},
+		},
+	})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	if results[0].Error == nil || results[0].Response != nil {
+		t.Errorf("request without response has result %+v", results[0])
+	}
+	if results[1].Error != nil {
+		t.Errorf("result 1 has error %v", results[1].Error)
+	}
+}
+
+func TestHttpBatchRequestUnknownContentId(t *testing.T) {
+	server, _ := newBatchServer(t, []batchResponsePart{
+		{"<response-item-5>", http.StatusOK, `{}`},
+	})
+
+	service, e := NewServiceWithAccessToken(&ServiceWithAccessTokenConfig{AccessToken: "access-token"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	_, e = service.HttpBatchRequest(&BatchConfig{
+		BatchUrl:       server.URL + "/batch/test/v1",
+		RequestConfigs: []*go_http.RequestConfig{{Url: "https://example.googleapis.com/v1/items/a"}},
+	})
+	if e == nil {
+		t.Error("expected an error for a response to an unknown request")
+	}
+}
+
+func TestHttpBatchRequestApiKey(t *testing.T) {
+	server, parts := newBatchServer(t, []batchResponsePart{
+		{"<response-item-1>", http.StatusOK, `{}`},
+		{"<response-item-2>", http.StatusOK, `{}`},
+	})
+
+	service, e := NewServiceWithApiKey(&ServiceWithApiKeyConfig{ApiKey: "api-key"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	_, e = service.HttpBatchRequest(&BatchConfig{
+		BatchUrl: server.URL + "/batch/test/v1",
+		RequestConfigs: []*go_http.RequestConfig{
+			{Url: "https://example.googleapis.com/v1/items/a", Parameters: &url.Values{"fields": {"id"}}},
+			{Url: "https://example.googleapis.com/v1/items/b?key=other-key"},
+		},
+	})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	if key := server.Requests("/batch/test/v1")[0].Query.Get("key"); key != "api-key" {
+		t.Errorf("batch request has key %q", key)
+	}
+
+	// every part carries the key, a key of the request itself is kept
+	expected := []url.Values{
+		{"fields": {"id"}, "key": {"api-key"}},
+		{"key": {"other-key"}},
+	}
+	for i, part := range parts() {
+		query := part.Request.URL.Query()
+		if fmt.Sprint(query) != fmt.Sprint(expected[i]) {
+			t.Errorf("part %v has query %v, expected %v", i, query, expected[i])
+		}
+	}
+}
+
+func TestHttpBatchRequestConfig(t *testing.T) {
+	service, e := NewServiceWithAccessToken(&ServiceWithAccessTokenConfig{AccessToken: "access-token"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	tooMany := make([]*go_http.RequestConfig, maxBatchSize+1)
+	for i := range tooMany {
+		tooMany[i] = &go_http.RequestConfig{Url: "https://example.googleapis.com/v1/items"}
+	}
+
+	for i, cfg := range []*BatchConfig{
+		nil,
+		{RequestConfigs: tooMany[:1]},
+		{BatchUrl: "https://example.googleapis.com/batch"},
+		{BatchUrl: "https://example.googleapis.com/batch", RequestConfigs: tooMany},
+		{BatchUrl: "https://example.googleapis.com/batch", RequestConfigs: []*go_http.RequestConfig{nil}},
+	} {
+		_, e := service.HttpBatchRequest(cfg)
+		if e == nil {
+			t.Errorf("expected an error for BatchConfig %v", i)
+		}
+	}
+}
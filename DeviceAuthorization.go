@@ -0,0 +1,215 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+)
+
+const (
+	deviceCodeUrl             string        = "https://oauth2.googleapis.com/device/code"
+	deviceCodeGrantType       string        = "urn:ietf:params:oauth:grant-type:device_code"
+	defaultDevicePollInterval time.Duration = 5 * time.Second
+	deviceSlowDownIncrement   time.Duration = 5 * time.Second
+)
+
+// DeviceCode is the response of the device authorization endpoint,
+// the user enters UserCode at VerificationUrl to authorize the device
+type DeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationUrl string `json:"verification_url"`
+	VerificationUri string `json:"verification_uri"` // RFC 8628 name of VerificationUrl, copied into it if the endpoint uses it
+	ExpiresIn       int64  `json:"expires_in"`
+	Interval        int64  `json:"interval"`
+}
+
+type DeviceAuthorizationConfig struct {
+	Scopes        []string
+	OnDeviceCode  func(deviceCode *DeviceCode) *errortools.Error // shows the VerificationUrl and UserCode to the user
+	DeviceCodeUrl *string                                        // defaults to https://oauth2.googleapis.com/device/code
+	TokenUrl      *string                                        // defaults to https://oauth2.googleapis.com/token
+	PollInterval  *time.Duration                                 // overrides the interval of the device code, e.g. against a fake endpoint
+	HttpClient    *http.Client
+}
+
+// AuthorizeDevice runs the OAuth2 device authorization flow for clients of type "TVs and Limited Input devices":
+// it requests a device code, passes it to OnDeviceCode and polls until the user authorized the device.
+// The token is stored in the TokenSource of the Service.
+func (service *Service) AuthorizeDevice(cfg *DeviceAuthorizationConfig) *errortools.Error {
+	return service.AuthorizeDeviceContext(context.Background(), cfg)
+}
+
+// AuthorizeDeviceContext is AuthorizeDevice honoring the cancellation, deadline and values of ctx
+func (service *Service) AuthorizeDeviceContext(ctx context.Context, cfg *DeviceAuthorizationConfig) *errortools.Error {
+	if cfg == nil {
+		return errortools.ErrorMessage("DeviceAuthorizationConfig must not be a nil pointer")
+	}
+
+	if service.authorizationMode != authorizationModeOAuth2 {
+		return errortools.ErrorMessage("AuthorizeDevice requires an oauth2 Service")
+	}
+
+	if service.tokenSource == nil {
+		return errortools.ErrorMessage("TokenSource not provided")
+	}
+
+	if cfg.OnDeviceCode == nil {
+		return errortools.ErrorMessage("OnDeviceCode not provided")
+	}
+
+	_deviceCodeUrl := deviceCodeUrl
+	if cfg.DeviceCodeUrl != nil {
+		_deviceCodeUrl = *cfg.DeviceCodeUrl
+	}
+
+	_tokenUrl := tokenUrl
+	if cfg.TokenUrl != nil {
+		_tokenUrl = *cfg.TokenUrl
+	}
+
+	httpClient := cfg.HttpClient
+	if httpClient == nil {
+		httpClient = service.httpClient
+	}
+
+	data := url.Values{}
+	data.Set("client_id", service.clientId)
+	data.Set("scope", strings.Join(cfg.Scopes, " "))
+
+	b, _, e := postDeviceRequest(ctx, httpClient, _deviceCodeUrl, data)
+	if e != nil {
+		return e
+	}
+
+	deviceCode := DeviceCode{}
+	err := json.Unmarshal(b, &deviceCode)
+	if err != nil {
+		return errortools.ErrorMessage(err)
+	}
+	if deviceCode.VerificationUrl == "" {
+		deviceCode.VerificationUrl = deviceCode.VerificationUri
+	}
+
+	e = cfg.OnDeviceCode(&deviceCode)
+	if e != nil {
+		return e
+	}
+
+	interval := defaultDevicePollInterval
+	if cfg.PollInterval != nil {
+		interval = *cfg.PollInterval
+	} else if deviceCode.Interval > 0 {
+		interval = time.Duration(deviceCode.Interval) * time.Second
+	}
+
+	return service.pollDeviceToken(ctx, httpClient, _tokenUrl, &deviceCode, interval)
+}
+
+// pollDeviceToken polls the token endpoint until the user authorized or denied the device, or the device code expired
+func (service *Service) pollDeviceToken(ctx context.Context, httpClient *http.Client, tokenUrl string, deviceCode *DeviceCode, interval time.Duration) *errortools.Error {
+	expires := time.Now().Add(time.Duration(deviceCode.ExpiresIn) * time.Second)
+
+	data := url.Values{}
+	data.Set("client_id", service.clientId)
+	data.Set("client_secret", service.clientSecret)
+	data.Set("device_code", deviceCode.DeviceCode)
+	data.Set("grant_type", deviceCodeGrantType)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return errortools.ErrorMessage(ctx.Err())
+		case <-time.After(interval):
+		}
+
+		if deviceCode.ExpiresIn > 0 && time.Now().After(expires) {
+			return errortools.ErrorMessage("Device code expired before the device was authorized")
+		}
+
+		b, tokenError, e := postDeviceRequest(ctx, httpClient, tokenUrl, data)
+		if e == nil {
+			return service.setDeviceToken(b)
+		}
+
+		switch tokenError.Error {
+		case "authorization_pending":
+			continue
+		case "slow_down":
+			interval += deviceSlowDownIncrement
+			continue
+		}
+
+		return e
+	}
+}
+
+// setDeviceToken stores the token response b in the TokenSource of the Service
+func (service *Service) setDeviceToken(b []byte) *errortools.Error {
+	token, e := service.tokenSource.UnmarshalToken(b)
+	if e != nil {
+		return e
+	}
+
+	if token.ExpiresIn != nil {
+		var expiresIn int64
+		err := json.Unmarshal(*token.ExpiresIn, &expiresIn)
+		if err != nil {
+			return errortools.ErrorMessagef("Cannot convert ExpiresIn %s to int64", string(*token.ExpiresIn))
+		}
+
+		expiry := time.Now().Add(time.Duration(expiresIn) * time.Second).UTC()
+		token.Expiry = &expiry
+	}
+
+	return service.tokenSource.SetToken(token, true)
+}
+
+// postDeviceRequest posts url encoded data to an endpoint of the device flow,
+// it returns the response body or the parsed oauth2 error
+func postDeviceRequest(ctx context.Context, httpClient *http.Client, _url string, data url.Values) ([]byte, *tokenErrorResponse, *errortools.Error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, _url, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, &tokenErrorResponse{}, errortools.ErrorMessage(err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	e := new(errortools.Error)
+	e.SetRequest(request)
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		e.SetMessage(err)
+		return nil, &tokenErrorResponse{}, e
+	}
+	defer response.Body.Close()
+	e.SetResponse(response)
+
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		e.SetMessage(err)
+		return nil, &tokenErrorResponse{}, e
+	}
+
+	if response.StatusCode >= 200 && response.StatusCode <= 299 {
+		return b, nil, nil
+	}
+
+	tokenError := tokenErrorResponse{}
+	_ = json.Unmarshal(b, &tokenError)
+
+	if tokenError.Error != "" {
+		e.SetMessagef("Device authorization returned statuscode %v: %s %s", response.StatusCode, tokenError.Error, tokenError.ErrorDescription)
+	} else {
+		e.SetMessagef("Device authorization returned statuscode %v", response.StatusCode)
+	}
+
+	return nil, &tokenError, e
+}
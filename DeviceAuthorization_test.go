@@ -0,0 +1,281 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	testserver "github.com/leapforce-libraries/go_google/internal/testserver"
+	token "github.com/leapforce-libraries/go_oauth2/token"
+)
+
+const testDeviceCode string = "device-code"
+
+// recordingTokenSource keeps the token set by the device flow in memory
+type recordingTokenSource struct {
+	token    *token.Token
+	save     bool
+	setCount int
+}
+
+func (ts *recordingTokenSource) Token() *token.Token {
+	return ts.token
+}
+
+func (ts *recordingTokenSource) NewToken() (*token.Token, *errortools.Error) {
+	return nil, errortools.ErrorMessage("NewToken not supported")
+}
+
+func (ts *recordingTokenSource) SetToken(token *token.Token, save bool) *errortools.Error {
+	ts.token = token
+	ts.save = save
+	ts.setCount++
+	return nil
+}
+
+func (ts *recordingTokenSource) RetrieveToken() *errortools.Error {
+	return nil
+}
+
+func (ts *recordingTokenSource) SaveToken() *errortools.Error {
+	return nil
+}
+
+func (ts *recordingTokenSource) UnmarshalToken(b []byte) (*token.Token, *errortools.Error) {
+	t := token.Token{}
+	err := json.Unmarshal(b, &t)
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+	return &t, nil
+}
+
+// newDeviceServer is a fake device code and token endpoint, the token endpoint answers the oauth2 errors in
+// tokenErrors one per poll and issues the token after them, or keeps answering the last one if issueToken is false
+func newDeviceServer(t *testing.T, expiresIn int64, issueToken bool, tokenErrors ...string) *testserver.Server {
+	var server *testserver.Server
+
+	checkClient := func(r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			t.Errorf("ParseForm: %v", err)
+		}
+		if r.PostForm.Get("client_id") != "client-id" {
+			t.Errorf("client_id is %q", r.PostForm.Get("client_id"))
+		}
+	}
+
+	server = testserver.New(t, map[string]http.HandlerFunc{
+		"/device/code": func(w http.ResponseWriter, r *http.Request) {
+			checkClient(r)
+			if r.PostForm.Get("scope") != "scope-a scope-b" {
+				t.Errorf("scope is %q", r.PostForm.Get("scope"))
+			}
+			testserver.WriteJson(w, http.StatusOK, map[string]interface{}{
+				"device_code":      testDeviceCode,
+				"user_code":        "ABCD-EFGH",
+				"verification_uri": "https://www.google.com/device",
+				"expires_in":       expiresIn,
+				"interval":         5, // the tests override it with PollInterval
+			})
+		},
+		"/token": func(w http.ResponseWriter, r *http.Request) {
+			checkClient(r)
+			if r.PostForm.Get("device_code") != testDeviceCode || r.PostForm.Get("grant_type") != deviceCodeGrantType || r.PostForm.Get("client_secret") != "client-secret" {
+				t.Errorf("token request %v", r.PostForm)
+			}
+
+			poll := len(server.Requests("/token"))
+			if poll > len(tokenErrors) && issueToken {
+				testserver.WriteJson(w, http.StatusOK, `{"access_token":"device-access-token","refresh_token":"device-refresh-token","expires_in":3599,"token_type":"Bearer"}`)
+				return
+			}
+
+			tokenError := tokenErrors[len(tokenErrors)-1]
+			if poll <= len(tokenErrors) {
+				tokenError = tokenErrors[poll-1]
+			}
+
+			statusCode := http.StatusBadRequest
+			if tokenError == "slow_down" || tokenError == "access_denied" {
+				statusCode = http.StatusForbidden
+			}
+			testserver.WriteJson(w, statusCode, map[string]string{"error": tokenError, "error_description": "description of " + tokenError})
+		},
+	})
+
+	return server
+}
+
+// pollTimes returns the times the token endpoint was polled
+func pollTimes(server *testserver.Server) []time.Time {
+	var times []time.Time
+	for _, request := range server.Requests("/token") {
+		times = append(times, request.Time)
+	}
+
+	return times
+}
+
+func newDeviceTestService(t *testing.T) (*Service, *recordingTokenSource) {
+	tokenSource := recordingTokenSource{}
+
+	service, e := NewServiceWithOAuth2(&ServiceWithOAuth2Config{
+		ClientId:     "client-id",
+		ClientSecret: "client-secret",
+		TokenSource:  &tokenSource,
+	})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	return service, &tokenSource
+}
+
+func authorizeTestDevice(service *Service, server *testserver.Server, pollInterval time.Duration, deviceCode *DeviceCode) *errortools.Error {
+	deviceCodeUrl := server.URL + "/device/code"
+	tokenUrl := server.URL + "/token"
+
+	return service.AuthorizeDeviceContext(context.Background(), &DeviceAuthorizationConfig{
+		Scopes: []string{"scope-a", "scope-b"},
+		OnDeviceCode: func(_deviceCode *DeviceCode) *errortools.Error {
+			*deviceCode = *_deviceCode
+			return nil
+		},
+		DeviceCodeUrl: &deviceCodeUrl,
+		TokenUrl:      &tokenUrl,
+		PollInterval:  &pollInterval,
+	})
+}
+
+func TestAuthorizeDevice(t *testing.T) {
+	server := newDeviceServer(t, 1800, true, "authorization_pending", "authorization_pending")
+
+	service, tokenSource := newDeviceTestService(t)
+
+	start := time.Now()
+	deviceCode := DeviceCode{}
+	e := authorizeTestDevice(service, server, 20*time.Millisecond, &deviceCode)
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	if deviceCode.UserCode != "ABCD-EFGH" || deviceCode.VerificationUrl != "https://www.google.com/device" {
+		t.Errorf("OnDeviceCode received %+v", deviceCode)
+	}
+
+	// the PollInterval overrides the interval of 5s of the device code
+	if len(pollTimes(server)) != 3 {
+		t.Errorf("token endpoint polled %v times, expected 3", len(pollTimes(server)))
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("authorization took %v, PollInterval was not used", time.Since(start))
+	}
+
+	if tokenSource.setCount != 1 || !tokenSource.save {
+		t.Fatalf("SetToken called %v times, save %v", tokenSource.setCount, tokenSource.save)
+	}
+	if tokenSource.token.AccessToken == nil || *tokenSource.token.AccessToken != "device-access-token" {
+		t.Errorf("stored access token is %v", tokenSource.token.AccessToken)
+	}
+	if tokenSource.token.RefreshToken == nil || *tokenSource.token.RefreshToken != "device-refresh-token" {
+		t.Errorf("stored refresh token is %v", tokenSource.token.RefreshToken)
+	}
+	if tokenSource.token.Expiry == nil || time.Until(*tokenSource.token.Expiry) < 59*time.Minute {
+		t.Errorf("stored expiry is %v", tokenSource.token.Expiry)
+	}
+}
+
+func TestAuthorizeDeviceSlowDown(t *testing.T) {
+	if testing.Short() {
+		t.Skip("slow_down waits 5s")
+	}
+
+	server := newDeviceServer(t, 1800, true, "slow_down")
+
+	service, tokenSource := newDeviceTestService(t)
+
+	e := authorizeTestDevice(service, server, 20*time.Millisecond, &DeviceCode{})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	polls := pollTimes(server)
+	if len(polls) != 2 {
+		t.Fatalf("token endpoint polled %v times, expected 2", len(polls))
+	}
+	if gap := polls[1].Sub(polls[0]); gap < 20*time.Millisecond+deviceSlowDownIncrement {
+		t.Errorf("poll after slow_down came after %v, expected the interval to grow by %v", gap, deviceSlowDownIncrement)
+	}
+	if tokenSource.setCount != 1 {
+		t.Errorf("SetToken called %v times", tokenSource.setCount)
+	}
+}
+
+func TestAuthorizeDeviceError(t *testing.T) {
+	for _, tokenError := range []string{"access_denied", "expired_token"} {
+		t.Run(tokenError, func(t *testing.T) {
+			server := newDeviceServer(t, 1800, false, "authorization_pending", tokenError)
+
+			service, tokenSource := newDeviceTestService(t)
+
+			e := authorizeTestDevice(service, server, 20*time.Millisecond, &DeviceCode{})
+			if e == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(e.Message(), tokenError) {
+				t.Errorf("error %q does not mention %s", e.Message(), tokenError)
+			}
+
+			// polling stops at the error
+			if len(pollTimes(server)) != 2 {
+				t.Errorf("token endpoint polled %v times, expected 2", len(pollTimes(server)))
+			}
+			if tokenSource.setCount != 0 {
+				t.Errorf("SetToken called %v times", tokenSource.setCount)
+			}
+		})
+	}
+}
+
+func TestAuthorizeDeviceExpiresIn(t *testing.T) {
+	server := newDeviceServer(t, 1, false, "authorization_pending")
+
+	service, tokenSource := newDeviceTestService(t)
+
+	start := time.Now()
+	e := authorizeTestDevice(service, server, 300*time.Millisecond, &DeviceCode{})
+	if e == nil || !strings.Contains(e.Message(), "expired") {
+		t.Fatalf("expected the device code to expire, got %v", e)
+	}
+
+	// the device code expires after 1s, the poll after it is not sent
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 2*time.Second {
+		t.Errorf("polling stopped after %v", elapsed)
+	}
+	if polls := len(pollTimes(server)); polls < 2 || polls > 3 {
+		t.Errorf("token endpoint polled %v times, expected 3 polls within 1s", polls)
+	}
+	if tokenSource.setCount != 0 {
+		t.Errorf("SetToken called %v times", tokenSource.setCount)
+	}
+}
+
+func TestAuthorizeDeviceCancel(t *testing.T) {
+	server := newDeviceServer(t, 1800, false, "authorization_pending")
+
+	service, _ := newDeviceTestService(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	pollInterval := 30 * time.Millisecond
+	e := service.pollDeviceToken(ctx, &http.Client{}, server.URL+"/token", &DeviceCode{DeviceCode: testDeviceCode, ExpiresIn: 1800}, pollInterval)
+	if e == nil || !strings.Contains(e.Message(), context.DeadlineExceeded.Error()) {
+		t.Fatalf("expected the deadline to stop polling, got %v", e)
+	}
+}
@@ -1,17 +1,169 @@
 package google
 
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// ErrorResponse holds both the AIP-193 error layout (status and details) and the legacy v1 layout (errors)
 type ErrorResponse struct {
 	Error struct {
-		Code    int    `json:"code"`
-		Message string `json:"message"`
-		Status  string `json:"status"`
-		Details []struct {
-			Type   string `json:"@type"`
-			Errors []struct {
-				ErrorCode map[string]string `json:"errorCode"`
-				Message   string            `json:"message"`
-			} `json:"errors"`
-			RequestId string `json:"requestId"`
-		} `json:"details"`
+		Code    int           `json:"code"`
+		Message string        `json:"message"`
+		Status  string        `json:"status"`
+		Details []ErrorDetail `json:"details"`
+		Errors  []ErrorItem   `json:"errors"`
 	} `json:"error"`
 }
+
+// ErrorItem is an element of the legacy v1 error.errors array
+type ErrorItem struct {
+	Domain       string `json:"domain"`
+	Reason       string `json:"reason"`
+	Message      string `json:"message"`
+	Location     string `json:"location"`
+	LocationType string `json:"locationType"`
+	ExtendedHelp string `json:"extendedHelp"`
+}
+
+// ErrorDetail is one element of the google.rpc.Status details array.
+// Value holds the decoded detail (*ErrorInfo, *QuotaFailure, ...) or, for unknown types, its raw json.
+// Errors and RequestId hold the Google Ads failure layout.
+type ErrorDetail struct {
+	Type   string `json:"@type"`
+	Errors []struct {
+		ErrorCode map[string]string `json:"errorCode"`
+		Message   string            `json:"message"`
+	} `json:"errors"`
+	RequestId string          `json:"requestId"`
+	Value     interface{}     `json:"-"`
+	Raw       json.RawMessage `json:"-"`
+}
+
+const errorDetailTypePrefix string = "type.googleapis.com/"
+
+func (detail *ErrorDetail) UnmarshalJSON(b []byte) error {
+	type errorDetail ErrorDetail
+
+	_detail := errorDetail{}
+	err := json.Unmarshal(b, &_detail)
+	if err != nil {
+		return err
+	}
+	*detail = ErrorDetail(_detail)
+	detail.Raw = append(json.RawMessage{}, b...)
+
+	var value interface{}
+
+	switch strings.TrimPrefix(detail.Type, errorDetailTypePrefix) {
+	case "google.rpc.ErrorInfo":
+		value = &ErrorInfo{}
+	case "google.rpc.RetryInfo":
+		value = &RetryInfo{}
+	case "google.rpc.DebugInfo":
+		value = &DebugInfo{}
+	case "google.rpc.QuotaFailure":
+		value = &QuotaFailure{}
+	case "google.rpc.PreconditionFailure":
+		value = &PreconditionFailure{}
+	case "google.rpc.BadRequest":
+		value = &BadRequest{}
+	case "google.rpc.RequestInfo":
+		value = &RequestInfo{}
+	case "google.rpc.ResourceInfo":
+		value = &ResourceInfo{}
+	case "google.rpc.Help":
+		value = &Help{}
+	case "google.rpc.LocalizedMessage":
+		value = &LocalizedMessage{}
+	default:
+		detail.Value = detail.Raw
+		return nil
+	}
+
+	err = json.Unmarshal(b, value)
+	if err != nil {
+		return err
+	}
+	detail.Value = value
+
+	return nil
+}
+
+type ErrorInfo struct {
+	Reason   string            `json:"reason"`
+	Domain   string            `json:"domain"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+type RetryInfo struct {
+	RetryDelay string `json:"retryDelay"` // duration in seconds, e.g. "1.5s"
+}
+
+// Delay returns RetryDelay as a time.Duration, zero if it cannot be parsed
+func (retryInfo *RetryInfo) Delay() time.Duration {
+	delay, err := time.ParseDuration(retryInfo.RetryDelay)
+	if err != nil {
+		return 0
+	}
+
+	return delay
+}
+
+type DebugInfo struct {
+	StackEntries []string `json:"stackEntries"`
+	Detail       string   `json:"detail"`
+}
+
+type QuotaFailure struct {
+	Violations []struct {
+		Subject         string            `json:"subject"`
+		Description     string            `json:"description"`
+		ApiService      string            `json:"apiService"`
+		QuotaMetric     string            `json:"quotaMetric"`
+		QuotaId         string            `json:"quotaId"`
+		QuotaDimensions map[string]string `json:"quotaDimensions"`
+	} `json:"violations"`
+}
+
+type PreconditionFailure struct {
+	Violations []struct {
+		Type        string `json:"type"`
+		Subject     string `json:"subject"`
+		Description string `json:"description"`
+	} `json:"violations"`
+}
+
+type BadRequest struct {
+	FieldViolations []struct {
+		Field            string            `json:"field"`
+		Description      string            `json:"description"`
+		Reason           string            `json:"reason"`
+		LocalizedMessage *LocalizedMessage `json:"localizedMessage"`
+	} `json:"fieldViolations"`
+}
+
+type RequestInfo struct {
+	RequestId   string `json:"requestId"`
+	ServingData string `json:"servingData"`
+}
+
+type ResourceInfo struct {
+	ResourceType string `json:"resourceType"`
+	ResourceName string `json:"resourceName"`
+	Owner        string `json:"owner"`
+	Description  string `json:"description"`
+}
+
+type Help struct {
+	Links []struct {
+		Description string `json:"description"`
+		Url         string `json:"url"`
+	} `json:"links"`
+}
+
+type LocalizedMessage struct {
+	Locale  string `json:"locale"`
+	Message string `json:"message"`
+}
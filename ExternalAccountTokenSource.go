@@ -0,0 +1,317 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	credentials "github.com/leapforce-libraries/go_google/credentials"
+	go_token "github.com/leapforce-libraries/go_oauth2/token"
+)
+
+const (
+	tokenExchangeGrantType         string        = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenTokenType           string        = "urn:ietf:params:oauth:token-type:access_token"
+	saml2TokenType                 string        = "urn:ietf:params:oauth:token-type:saml2"
+	cloudPlatformScope             string        = "https://www.googleapis.com/auth/cloud-platform"
+	allowExecutablesEnvVar         string        = "GOOGLE_EXTERNAL_ACCOUNT_ALLOW_EXECUTABLES"
+	defaultExecutableTimeout       time.Duration = 30 * time.Second
+	executableResponseVersion      int           = 1
+	defaultStsTokenUrl             string        = "https://sts.googleapis.com/v1/token"
+	credentialSourceFormatJson     string        = "json"
+	credentialSourceEnvironmentAws string        = "aws"
+)
+
+// ExternalAccountTokenSource retrieves access tokens through workload identity federation:
+// a subject token from a file, url, executable or AWS is exchanged at the STS endpoint and,
+// optionally, for an access token of an impersonated service account
+type ExternalAccountTokenSource struct {
+	tokenCache
+	credentialsJson *credentials.CredentialsJson
+	tokenUrl        string
+	scopes          []string
+	httpClient      *http.Client
+}
+
+type ExternalAccountTokenSourceConfig struct {
+	CredentialsJson *credentials.CredentialsJson
+	Scopes          []string
+	HttpClient      *http.Client
+}
+
+func NewExternalAccountTokenSource(cfg *ExternalAccountTokenSourceConfig) (*ExternalAccountTokenSource, *errortools.Error) {
+	if cfg == nil {
+		return nil, errortools.ErrorMessage("ExternalAccountTokenSourceConfig must not be a nil pointer")
+	}
+
+	if cfg.CredentialsJson == nil {
+		return nil, errortools.ErrorMessage("CredentialsJson not provided")
+	}
+
+	if cfg.CredentialsJson.Type != credentials.TypeExternalAccount {
+		return nil, errortools.ErrorMessagef("CredentialsJson has type '%s' instead of '%s'", cfg.CredentialsJson.Type, credentials.TypeExternalAccount)
+	}
+
+	if cfg.CredentialsJson.Audience == "" {
+		return nil, errortools.ErrorMessage("Audience not provided")
+	}
+
+	if cfg.CredentialsJson.SubjectTokenType == "" {
+		return nil, errortools.ErrorMessage("SubjectTokenType not provided")
+	}
+
+	credentialSource := cfg.CredentialsJson.CredentialSource
+	if credentialSource == nil {
+		return nil, errortools.ErrorMessage("CredentialSource not provided")
+	}
+
+	if strings.HasPrefix(credentialSource.EnvironmentId, credentialSourceEnvironmentAws) {
+		e := validateAwsEnvironmentId(credentialSource.EnvironmentId)
+		if e != nil {
+			return nil, e
+		}
+	} else if credentialSource.File == "" && credentialSource.Url == "" && credentialSource.Executable == nil {
+		return nil, errortools.ErrorMessage("CredentialSource needs a file, url, executable or AWS environment")
+	}
+
+	_tokenUrl := defaultStsTokenUrl
+	if cfg.CredentialsJson.TokenUrl != "" {
+		_tokenUrl = cfg.CredentialsJson.TokenUrl
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{cloudPlatformScope}
+	}
+
+	return &ExternalAccountTokenSource{
+		credentialsJson: cfg.CredentialsJson,
+		tokenUrl:        _tokenUrl,
+		scopes:          scopes,
+		httpClient:      cfg.HttpClient,
+	}, nil
+}
+
+// NewToken exchanges the subject token at the STS endpoint and impersonates the service account if configured
+func (t *ExternalAccountTokenSource) NewToken() (*go_token.Token, *errortools.Error) {
+	subjectToken, e := t.subjectToken()
+	if e != nil {
+		return nil, e
+	}
+
+	impersonate := t.credentialsJson.ServiceAccountImpersonationUrl != ""
+
+	scope := strings.Join(t.scopes, " ")
+	if impersonate {
+		// the federated token only needs to be able to call the IAM Credentials API
+		scope = cloudPlatformScope
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", tokenExchangeGrantType)
+	data.Set("audience", t.credentialsJson.Audience)
+	data.Set("scope", scope)
+	data.Set("requested_token_type", accessTokenTokenType)
+	data.Set("subject_token", subjectToken)
+	data.Set("subject_token_type", t.credentialsJson.SubjectTokenType)
+
+	b, e := postTokenRequest(t.httpClient, t.tokenUrl, data, nil)
+	if e != nil {
+		return nil, e
+	}
+
+	token, e := t.UnmarshalToken(b)
+	if e != nil {
+		return nil, e
+	}
+
+	if !impersonate {
+		return token, nil
+	}
+
+	if !token.HasAccessToken() {
+		return nil, errortools.ErrorMessage("STS token exchange returned no access_token")
+	}
+
+	return generateAccessToken(t.httpClient, *token.AccessToken, t.credentialsJson.ServiceAccountImpersonationUrl, nil, t.scopes, defaultImpersonationLifetime)
+}
+
+func (t *ExternalAccountTokenSource) subjectToken() (string, *errortools.Error) {
+	credentialSource := t.credentialsJson.CredentialSource
+
+	if strings.HasPrefix(credentialSource.EnvironmentId, credentialSourceEnvironmentAws) {
+		return t.subjectTokenFromAws(credentialSource)
+	}
+
+	if credentialSource.Executable != nil {
+		return t.subjectTokenFromExecutable(credentialSource.Executable)
+	}
+
+	var b []byte
+
+	if credentialSource.File != "" {
+		_b, err := os.ReadFile(credentialSource.File)
+		if err != nil {
+			return "", errortools.ErrorMessage(err)
+		}
+		b = _b
+	} else {
+		request, err := http.NewRequest(http.MethodGet, credentialSource.Url, nil)
+		if err != nil {
+			return "", errortools.ErrorMessage(err)
+		}
+		for key, value := range credentialSource.Headers {
+			request.Header.Set(key, value)
+		}
+
+		_b, e := doTokenRequest(t.httpClient, request)
+		if e != nil {
+			return "", e
+		}
+		b = _b
+	}
+
+	return parseSubjectToken(b, credentialSource.Format)
+}
+
+func parseSubjectToken(b []byte, format *credentials.CredentialSourceFormat) (string, *errortools.Error) {
+	var subjectToken string
+
+	if format != nil && format.Type == credentialSourceFormatJson {
+		values := make(map[string]interface{})
+		err := json.Unmarshal(b, &values)
+		if err != nil {
+			return "", errortools.ErrorMessage(err)
+		}
+
+		value, ok := values[format.SubjectTokenFieldName].(string)
+		if !ok {
+			return "", errortools.ErrorMessagef("Subject token field '%s' not found", format.SubjectTokenFieldName)
+		}
+		subjectToken = value
+	} else {
+		subjectToken = strings.TrimSpace(string(b))
+	}
+
+	if subjectToken == "" {
+		return "", errortools.ErrorMessage("Subject token is empty")
+	}
+
+	return subjectToken, nil
+}
+
+type executableResponse struct {
+	Version        int    `json:"version"`
+	Success        *bool  `json:"success"`
+	TokenType      string `json:"token_type"`
+	IdToken        string `json:"id_token"`
+	SamlResponse   string `json:"saml_response"`
+	ExpirationTime int64  `json:"expiration_time"`
+	Code           string `json:"code"`
+	Message        string `json:"message"`
+}
+
+func (t *ExternalAccountTokenSource) subjectTokenFromExecutable(executable *credentials.CredentialSourceExecutable) (string, *errortools.Error) {
+	if os.Getenv(allowExecutablesEnvVar) != "1" {
+		return "", errortools.ErrorMessagef("Executable credential sources require environment variable %s=1", allowExecutablesEnvVar)
+	}
+
+	args := strings.Fields(executable.Command)
+	if len(args) == 0 {
+		return "", errortools.ErrorMessage("Executable command not provided")
+	}
+
+	// reuse a cached response that has not expired yet
+	if executable.OutputFile != "" {
+		b, err := os.ReadFile(executable.OutputFile)
+		if err == nil && len(b) > 0 {
+			subjectToken, e := t.parseExecutableResponse(b, true)
+			if e == nil {
+				return subjectToken, nil
+			}
+		}
+	}
+
+	timeout := defaultExecutableTimeout
+	if executable.TimeoutMillis > 0 {
+		timeout = time.Duration(executable.TimeoutMillis) * time.Millisecond
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"GOOGLE_EXTERNAL_ACCOUNT_AUDIENCE="+t.credentialsJson.Audience,
+		"GOOGLE_EXTERNAL_ACCOUNT_TOKEN_TYPE="+t.credentialsJson.SubjectTokenType,
+		"GOOGLE_EXTERNAL_ACCOUNT_INTERACTIVE=0",
+	)
+	if t.credentialsJson.ServiceAccountImpersonationUrl != "" {
+		cmd.Env = append(cmd.Env, "GOOGLE_EXTERNAL_ACCOUNT_IMPERSONATED_EMAIL="+impersonatedEmail(t.credentialsJson.ServiceAccountImpersonationUrl))
+	}
+	if executable.OutputFile != "" {
+		cmd.Env = append(cmd.Env, "GOOGLE_EXTERNAL_ACCOUNT_OUTPUT_FILE="+executable.OutputFile)
+	}
+
+	b, err := cmd.Output()
+	if err != nil {
+		return "", errortools.ErrorMessagef("Executable %s failed: %s", args[0], err.Error())
+	}
+
+	return t.parseExecutableResponse(b, executable.OutputFile != "")
+}
+
+// parseExecutableResponse returns the subject token of an executable response,
+// an expiration_time is required if the response is cached in an output file
+func (t *ExternalAccountTokenSource) parseExecutableResponse(b []byte, requireExpirationTime bool) (string, *errortools.Error) {
+	response := executableResponse{}
+
+	err := json.Unmarshal(b, &response)
+	if err != nil {
+		return "", errortools.ErrorMessage(err)
+	}
+
+	if response.Version != executableResponseVersion {
+		return "", errortools.ErrorMessagef("Unsupported executable response version %v", response.Version)
+	}
+
+	if response.Success == nil {
+		return "", errortools.ErrorMessage("Executable response has no success field")
+	}
+
+	if !*response.Success {
+		return "", errortools.ErrorMessagef("Executable returned error %s: %s", response.Code, response.Message)
+	}
+
+	if response.ExpirationTime == 0 && requireExpirationTime {
+		return "", errortools.ErrorMessage("Executable response has no expiration_time, it is required with an output_file")
+	}
+
+	if response.ExpirationTime != 0 && time.Unix(response.ExpirationTime, 0).Before(time.Now()) {
+		return "", errortools.ErrorMessage("Executable response has expired")
+	}
+
+	subjectToken := response.IdToken
+	if response.TokenType == saml2TokenType {
+		subjectToken = response.SamlResponse
+	}
+
+	if subjectToken == "" {
+		return "", errortools.ErrorMessagef("Executable response of token_type '%s' has no token", response.TokenType)
+	}
+
+	return subjectToken, nil
+}
+
+// impersonatedEmail extracts the service account email from a service_account_impersonation_url
+func impersonatedEmail(impersonationUrl string) string {
+	s := impersonationUrl[strings.LastIndex(impersonationUrl, "/")+1:]
+
+	return strings.TrimSuffix(s, ":generateAccessToken")
+}
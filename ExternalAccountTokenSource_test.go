@@ -0,0 +1,78 @@
+package google
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	credentials "github.com/leapforce-libraries/go_google/credentials"
+)
+
+func TestParseExecutableResponse(t *testing.T) {
+	expirationTime := time.Now().Add(time.Hour).Unix()
+	expired := time.Now().Add(-time.Minute).Unix()
+
+	tests := []struct {
+		name                  string
+		response              string
+		requireExpirationTime bool
+		subjectToken          string
+		error                 string
+	}{
+		{"id token", fmt.Sprintf(`{"version":1,"success":true,"token_type":"urn:ietf:params:oauth:token-type:id_token","id_token":"id-token","expiration_time":%v}`, expirationTime), true, "id-token", ""},
+		{"saml response", fmt.Sprintf(`{"version":1,"success":true,"token_type":"%s","saml_response":"saml-response","expiration_time":%v}`, saml2TokenType, expirationTime), true, "saml-response", ""},
+		{"no expiration time without output file", `{"version":1,"success":true,"token_type":"urn:ietf:params:oauth:token-type:jwt","id_token":"jwt"}`, false, "jwt", ""},
+		{"no expiration time with output file", `{"version":1,"success":true,"token_type":"urn:ietf:params:oauth:token-type:jwt","id_token":"jwt"}`, true, "", "expiration_time"},
+		{"expired", fmt.Sprintf(`{"version":1,"success":true,"token_type":"urn:ietf:params:oauth:token-type:jwt","id_token":"jwt","expiration_time":%v}`, expired), false, "", "expired"},
+		{"empty token", `{"version":1,"success":true,"token_type":"urn:ietf:params:oauth:token-type:jwt"}`, false, "", "no token"},
+		{"empty saml response", fmt.Sprintf(`{"version":1,"success":true,"token_type":"%s","id_token":"id-token"}`, saml2TokenType), false, "", "no token"},
+		{"error", `{"version":1,"success":false,"code":"401","message":"Caller not authorized"}`, false, "", "Caller not authorized"},
+		{"no success", `{"version":1,"id_token":"jwt"}`, false, "", "success"},
+		{"version", `{"version":2,"success":true,"id_token":"jwt"}`, false, "", "version"},
+	}
+
+	tokenSource := ExternalAccountTokenSource{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subjectToken, e := tokenSource.parseExecutableResponse([]byte(test.response), test.requireExpirationTime)
+
+			if test.error != "" {
+				if e == nil || !strings.Contains(e.Message(), test.error) {
+					t.Fatalf("expected an error about %s, got %q, %v", test.error, subjectToken, e)
+				}
+				return
+			}
+
+			if e != nil {
+				t.Fatal(e.Message())
+			}
+			if subjectToken != test.subjectToken {
+				t.Errorf("subject token is %q, expected %q", subjectToken, test.subjectToken)
+			}
+		})
+	}
+}
+
+func TestExecutableWhitespaceCommand(t *testing.T) {
+	t.Setenv(allowExecutablesEnvVar, "1")
+
+	tokenSource, e := NewExternalAccountTokenSource(&ExternalAccountTokenSourceConfig{
+		CredentialsJson: &credentials.CredentialsJson{
+			Type:             credentials.TypeExternalAccount,
+			Audience:         "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/pool/providers/oidc",
+			SubjectTokenType: "urn:ietf:params:oauth:token-type:jwt",
+			CredentialSource: &credentials.CredentialSource{
+				Executable: &credentials.CredentialSourceExecutable{Command: " \t "},
+			},
+		},
+	})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	_, e = tokenSource.subjectToken()
+	if e == nil {
+		t.Fatal("expected an error for a whitespace-only command")
+	}
+}
@@ -0,0 +1,236 @@
+package google
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	go_http "github.com/leapforce-libraries/go_http"
+)
+
+var (
+	fieldMasks          sync.Map // reflect.Type to string
+	jsonMarshalerType   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// FieldMask returns the partial response mask (the fields parameter) for model, derived from its json tags,
+// e.g. "id,name,items(id,labels)" for nested and repeated structs
+func FieldMask(model interface{}) (string, *errortools.Error) {
+	if model == nil {
+		return "", errortools.ErrorMessage("model is nil")
+	}
+
+	_type := indirectType(reflect.TypeOf(model))
+	for _type.Kind() == reflect.Slice || _type.Kind() == reflect.Array {
+		_type = indirectType(_type.Elem())
+	}
+
+	if _type.Kind() != reflect.Struct {
+		return "", errortools.ErrorMessagef("model must be a struct, not %s", _type.Kind())
+	}
+
+	return fieldMask(_type), nil
+}
+
+// SetFieldMask adds the fields parameter derived from the ResponseModel of requestConfig,
+// unless it already has a fields parameter
+func SetFieldMask(requestConfig *go_http.RequestConfig) *errortools.Error {
+	if requestConfig.Parameters != nil && requestConfig.Parameters.Has("fields") {
+		return nil
+	}
+
+	mask, e := FieldMask(requestConfig.ResponseModel)
+	if e != nil {
+		return e
+	}
+
+	if mask != "" {
+		requestConfig.SetParameter("fields", mask)
+	}
+
+	return nil
+}
+
+func fieldMask(_type reflect.Type) string {
+	if mask, ok := fieldMasks.Load(_type); ok {
+		return mask.(string)
+	}
+
+	mask := strings.Join(fieldMaskPaths(_type, map[reflect.Type]bool{}), ",")
+	fieldMasks.Store(_type, mask)
+
+	return mask
+}
+
+func fieldMaskPaths(_type reflect.Type, visiting map[reflect.Type]bool) []string {
+	visiting[_type] = true
+	defer delete(visiting, _type)
+
+	var paths []string
+
+	for i := 0; i < _type.NumField(); i++ {
+		field := _type.Field(i)
+		name, ok := jsonFieldName(field)
+		if !ok {
+			continue
+		}
+
+		fieldType := indirectType(field.Type)
+		for fieldType.Kind() == reflect.Slice || fieldType.Kind() == reflect.Array {
+			fieldType = indirectType(fieldType.Elem())
+		}
+
+		if !isMaskStruct(fieldType) || visiting[fieldType] {
+			if name != "" {
+				paths = append(paths, name)
+			}
+			continue
+		}
+
+		subPaths := fieldMaskPaths(fieldType, visiting)
+
+		if name == "" {
+			// embedded struct, its fields are promoted
+			paths = append(paths, subPaths...)
+			continue
+		}
+
+		if len(subPaths) == 0 {
+			paths = append(paths, name)
+			continue
+		}
+
+		paths = append(paths, name+"("+strings.Join(subPaths, ",")+")")
+	}
+
+	return paths
+}
+
+// UpdateMask returns the updateMask of the fields that differ between original and updated,
+// as comma separated dot paths of their json names, e.g. "displayName,address.city"
+func UpdateMask(original interface{}, updated interface{}) (string, *errortools.Error) {
+	originalValue := indirectValue(reflect.ValueOf(original))
+	updatedValue := indirectValue(reflect.ValueOf(updated))
+
+	if !originalValue.IsValid() || !updatedValue.IsValid() {
+		return "", errortools.ErrorMessage("original and updated must not be nil")
+	}
+
+	if originalValue.Type() != updatedValue.Type() {
+		return "", errortools.ErrorMessagef("original has type %s, updated has type %s", originalValue.Type(), updatedValue.Type())
+	}
+
+	if originalValue.Kind() != reflect.Struct {
+		return "", errortools.ErrorMessagef("original and updated must be structs, not %s", originalValue.Kind())
+	}
+
+	paths := updateMaskPaths(originalValue, updatedValue, "")
+	sort.Strings(paths)
+
+	return strings.Join(paths, ","), nil
+}
+
+func updateMaskPaths(original reflect.Value, updated reflect.Value, prefix string) []string {
+	var paths []string
+
+	for i := 0; i < original.NumField(); i++ {
+		field := original.Type().Field(i)
+		name, ok := jsonFieldName(field)
+		if !ok {
+			continue
+		}
+
+		originalField := indirectValue(original.Field(i))
+		updatedField := indirectValue(updated.Field(i))
+
+		if name == "" {
+			// embedded struct, its fields are promoted, a nil embedded pointer has the fields of the zero struct
+			if !originalField.IsValid() && !updatedField.IsValid() {
+				continue
+			}
+			if !originalField.IsValid() {
+				originalField = reflect.Zero(updatedField.Type())
+			}
+			if !updatedField.IsValid() {
+				updatedField = reflect.Zero(originalField.Type())
+			}
+			paths = append(paths, updateMaskPaths(originalField, updatedField, prefix)...)
+			continue
+		}
+
+		path := prefix + name
+
+		if reflect.DeepEqual(original.Field(i).Interface(), updated.Field(i).Interface()) {
+			continue
+		}
+
+		// compare nested structs field by field, unless one of them is nil
+		if originalField.IsValid() && updatedField.IsValid() && isMaskStruct(originalField.Type()) {
+			paths = append(paths, updateMaskPaths(originalField, updatedField, path+".")...)
+			continue
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths
+}
+
+// jsonFieldName returns the json name of field, empty for an embedded struct without name,
+// false if the field is not marshaled
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+
+	name, _, _ := strings.Cut(tag, ",")
+
+	if field.Anonymous && name == "" && indirectType(field.Type).Kind() == reflect.Struct {
+		return "", true
+	}
+
+	if !field.IsExported() {
+		return "", false
+	}
+
+	if name == "" {
+		name = field.Name
+	}
+
+	return name, true
+}
+
+// isMaskStruct reports whether the fields of _type are part of masks, structs with their own json encoding are leaves
+func isMaskStruct(_type reflect.Type) bool {
+	if _type.Kind() != reflect.Struct {
+		return false
+	}
+
+	pointerType := reflect.PointerTo(_type)
+
+	return !_type.Implements(jsonMarshalerType) && !pointerType.Implements(jsonMarshalerType) && !pointerType.Implements(jsonUnmarshalerType)
+}
+
+func indirectType(_type reflect.Type) reflect.Type {
+	for _type.Kind() == reflect.Pointer {
+		_type = _type.Elem()
+	}
+
+	return _type
+}
+
+func indirectValue(value reflect.Value) reflect.Value {
+	for value.IsValid() && (value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface) {
+		if value.IsNil() {
+			return reflect.Value{}
+		}
+		value = value.Elem()
+	}
+
+	return value
+}
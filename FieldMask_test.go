@@ -0,0 +1,267 @@
+package google
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	testserver "github.com/leapforce-libraries/go_google/internal/testserver"
+	go_http "github.com/leapforce-libraries/go_http"
+)
+
+type MaskBase struct {
+	Id   string `json:"id"`
+	Etag string `json:"etag,omitempty"`
+}
+
+type MaskMeta struct {
+	Created string `json:"created"`
+}
+
+type testMaskAddress struct {
+	Street string `json:"street"`
+	City   string `json:"city"`
+}
+
+type testMaskLabel struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+type testMaskResource struct {
+	MaskBase
+	*MaskMeta
+	Name     string            `json:"displayName,omitempty"`
+	Address  *testMaskAddress  `json:"address"`
+	Labels   []testMaskLabel   `json:"labels"`
+	Tags     []string          `json:"tags"`
+	Updated  time.Time         `json:"updateTime"`
+	Parent   *testMaskResource `json:"parent"`
+	Count    int
+	Internal string `json:"-"`
+	private  string
+}
+
+const testMaskResourceFieldMask string = "id,etag,created,displayName,address(street,city),labels(key,value),tags,updateTime,parent,Count"
+
+func TestFieldMask(t *testing.T) {
+	tests := []struct {
+		name  string
+		model interface{}
+		mask  string
+	}{
+		{"struct", testMaskResource{}, testMaskResourceFieldMask},
+		{"pointer", &testMaskResource{}, testMaskResourceFieldMask},
+		{"slice", []*testMaskResource{}, testMaskResourceFieldMask},
+		{"pointer to slice", &[]testMaskResource{}, testMaskResourceFieldMask},
+		{"nested", testMaskAddress{}, "street,city"},
+		{"embedded only", struct{ MaskBase }{}, "id,etag"},
+		{"json marshaler is a leaf", struct {
+			Updated time.Time `json:"updated"`
+		}{}, "updated"},
+		{"empty nested struct", struct {
+			Empty struct{} `json:"empty"`
+		}{}, "empty"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mask, e := FieldMask(test.model)
+			if e != nil {
+				t.Fatal(e.Message())
+			}
+			if mask != test.mask {
+				t.Errorf("mask is %q, expected %q", mask, test.mask)
+			}
+		})
+	}
+
+	for _, model := range []interface{}{nil, 1, []string{}, map[string]string{}} {
+		_, e := FieldMask(model)
+		if e == nil {
+			t.Errorf("expected an error for %T", model)
+		}
+	}
+}
+
+func TestUpdateMask(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(resource *testMaskResource)
+		mask   string
+		setup  func(resource *testMaskResource)
+	}{
+		{"unchanged", func(resource *testMaskResource) {}, "", nil},
+		{"field", func(resource *testMaskResource) { resource.Name = "new" }, "displayName", nil},
+		{"omitempty field cleared", func(resource *testMaskResource) { resource.Name = "" }, "displayName", nil},
+		{"field without json tag", func(resource *testMaskResource) { resource.Count = 2 }, "Count", nil},
+		{"nested field", func(resource *testMaskResource) { resource.Address.City = "Utrecht" }, "address.city", nil},
+		{"nested fields", func(resource *testMaskResource) { resource.Address = &testMaskAddress{"Main Street 2", "Utrecht"} }, "address.city,address.street", nil},
+		{"nested pointer set", func(resource *testMaskResource) { resource.Address = &testMaskAddress{City: "Utrecht"} }, "address", func(resource *testMaskResource) { resource.Address = nil }},
+		{"nested pointer cleared", func(resource *testMaskResource) { resource.Address = nil }, "address", nil},
+		{"nested pointers nil", func(resource *testMaskResource) {}, "", func(resource *testMaskResource) { resource.Address = nil }},
+		{"repeated struct", func(resource *testMaskResource) { resource.Labels[0].Value = "b" }, "labels", nil},
+		{"repeated element added", func(resource *testMaskResource) { resource.Tags = append(resource.Tags, "new") }, "tags", nil},
+		{"repeated cleared", func(resource *testMaskResource) { resource.Tags = nil }, "tags", nil},
+		{"json marshaler", func(resource *testMaskResource) { resource.Updated = resource.Updated.Add(time.Hour) }, "updateTime", nil},
+		{"embedded field", func(resource *testMaskResource) { resource.Id = "other" }, "id", nil},
+		{"embedded omitempty field", func(resource *testMaskResource) { resource.Etag = "" }, "etag", nil},
+		{"embedded pointer field", func(resource *testMaskResource) { resource.Created = "2024-02-01" }, "created", nil},
+		{"embedded pointer set", func(resource *testMaskResource) { resource.MaskMeta = &MaskMeta{Created: "2024-02-01"} }, "created", func(resource *testMaskResource) { resource.MaskMeta = nil }},
+		{"embedded pointer cleared", func(resource *testMaskResource) { resource.MaskMeta = nil }, "created", nil},
+		{"embedded pointer set to zero", func(resource *testMaskResource) { resource.MaskMeta = &MaskMeta{} }, "", func(resource *testMaskResource) { resource.MaskMeta = nil }},
+		{"recursive", func(resource *testMaskResource) { resource.Parent = &testMaskResource{Name: "parent"} }, "parent", nil},
+		{"ignored fields", func(resource *testMaskResource) { resource.Internal = "x"; resource.private = "x" }, "", nil},
+		{"sorted", func(resource *testMaskResource) {
+			resource.Tags = nil
+			resource.Name = "new"
+			resource.Address.City = "Utrecht"
+			resource.Created = "2024-02-01"
+		}, "address.city,created,displayName,tags", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := newTestMaskResource()
+			if test.setup != nil {
+				test.setup(original)
+			}
+
+			updated := newTestMaskResource()
+			if test.setup != nil {
+				test.setup(updated)
+			}
+			test.change(updated)
+
+			mask, e := UpdateMask(original, updated)
+			if e != nil {
+				t.Fatal(e.Message())
+			}
+			if mask != test.mask {
+				t.Errorf("mask is %q, expected %q", mask, test.mask)
+			}
+
+			// values and pointers give the same mask
+			mask, e = UpdateMask(*original, *updated)
+			if e != nil || mask != test.mask {
+				t.Errorf("mask of values is %q, %v", mask, e)
+			}
+		})
+	}
+}
+
+func newTestMaskResource() *testMaskResource {
+	return &testMaskResource{
+		MaskBase: MaskBase{Id: "id", Etag: "etag"},
+		MaskMeta: &MaskMeta{Created: "2024-01-01"},
+		Name:     "name",
+		Address:  &testMaskAddress{"Main Street 1", "Amsterdam"},
+		Labels:   []testMaskLabel{{"a", "a"}},
+		Tags:     []string{"one"},
+		Updated:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Count:    1,
+	}
+}
+
+func TestUpdateMaskErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		original interface{}
+		updated  interface{}
+	}{
+		{"nil", nil, &testMaskResource{}},
+		{"nil pointer", (*testMaskResource)(nil), &testMaskResource{}},
+		{"different types", &testMaskResource{}, &testMaskAddress{}},
+		{"not a struct", 1, 2},
+	}
+
+	for _, test := range tests {
+		_, e := UpdateMask(test.original, test.updated)
+		if e == nil {
+			t.Errorf("expected an error for %s", test.name)
+		}
+	}
+}
+
+func TestAutoFieldMask(t *testing.T) {
+	server := testserver.New(t, map[string]http.HandlerFunc{
+		"/v1/things/{id}": func(w http.ResponseWriter, r *http.Request) {
+			testserver.WriteJson(w, http.StatusOK, `{"id":"thing"}`)
+		},
+	})
+
+	service, e := NewServiceWithAccessToken(&ServiceWithAccessTokenConfig{AccessToken: "access-token"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	fields := func(path string) string {
+		requests := server.Requests(path)
+		return requests[len(requests)-1].Query.Get("fields")
+	}
+
+	// without auto field mask the complete resource is requested
+	_, _, e = service.HttpRequest(&go_http.RequestConfig{Url: server.URL + "/v1/things/1", ResponseModel: &testMaskResource{}})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	if fields("/v1/things/1") != "" {
+		t.Errorf("fields is %q without auto field mask", fields("/v1/things/1"))
+	}
+
+	service.SetAutoFieldMask(true)
+
+	_, _, e = service.HttpRequest(&go_http.RequestConfig{Url: server.URL + "/v1/things/2", ResponseModel: &testMaskResource{}})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	if fields("/v1/things/2") != testMaskResourceFieldMask {
+		t.Errorf("fields is %q, expected %q", fields("/v1/things/2"), testMaskResourceFieldMask)
+	}
+
+	// a fields parameter of the request is kept, the parameters of the caller are not changed
+	parameters := url.Values{"fields": {"id"}}
+	_, _, e = service.HttpRequest(&go_http.RequestConfig{Url: server.URL + "/v1/things/3", Parameters: &parameters, ResponseModel: &testMaskResource{}})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	if fields("/v1/things/3") != "id" {
+		t.Errorf("fields is %q, expected id", fields("/v1/things/3"))
+	}
+
+	parameters = url.Values{"view": {"full"}}
+	_, _, e = service.HttpRequest(&go_http.RequestConfig{Url: server.URL + "/v1/things/4", Parameters: &parameters, ResponseModel: &testMaskResource{}})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	if fields("/v1/things/4") != testMaskResourceFieldMask || parameters.Has("fields") {
+		t.Errorf("fields is %q, parameters of the caller are %v", fields("/v1/things/4"), parameters)
+	}
+
+	// a request without ResponseModel has no mask
+	_, _, e = service.HttpRequest(&go_http.RequestConfig{Url: server.URL + "/v1/things/5"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	if fields("/v1/things/5") != "" {
+		t.Errorf("fields is %q without ResponseModel", fields("/v1/things/5"))
+	}
+}
+
+func TestSetFieldMask(t *testing.T) {
+	requestConfig := go_http.RequestConfig{ResponseModel: &[]testMaskAddress{}}
+
+	e := SetFieldMask(&requestConfig)
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	if requestConfig.Parameters == nil || requestConfig.Parameters.Get("fields") != "street,city" {
+		t.Errorf("parameters are %v", requestConfig.Parameters)
+	}
+
+	e = SetFieldMask(&go_http.RequestConfig{ResponseModel: &map[string]string{}})
+	if e == nil {
+		t.Error("expected an error for a map")
+	}
+}
@@ -0,0 +1,335 @@
+package google
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+)
+
+// canonical error statuses, see https://cloud.google.com/apis/design/errors#handling_errors
+const (
+	StatusCancelled          string = "CANCELLED"
+	StatusUnknown            string = "UNKNOWN"
+	StatusInvalidArgument    string = "INVALID_ARGUMENT"
+	StatusDeadlineExceeded   string = "DEADLINE_EXCEEDED"
+	StatusNotFound           string = "NOT_FOUND"
+	StatusAlreadyExists      string = "ALREADY_EXISTS"
+	StatusPermissionDenied   string = "PERMISSION_DENIED"
+	StatusResourceExhausted  string = "RESOURCE_EXHAUSTED"
+	StatusFailedPrecondition string = "FAILED_PRECONDITION"
+	StatusAborted            string = "ABORTED"
+	StatusOutOfRange         string = "OUT_OF_RANGE"
+	StatusUnimplemented      string = "UNIMPLEMENTED"
+	StatusInternal           string = "INTERNAL"
+	StatusUnavailable        string = "UNAVAILABLE"
+	StatusDataLoss           string = "DATA_LOSS"
+	StatusUnauthenticated    string = "UNAUTHENTICATED"
+)
+
+// GoogleError is the typed representation of an error returned by a Google API,
+// normalized from both the AIP-193 and the legacy v1 error layout
+type GoogleError struct {
+	HttpCode  int
+	Status    string
+	Reason    string
+	Domain    string
+	Message   string
+	RequestId string
+	Details   []ErrorDetail
+	Errors    []ErrorItem
+}
+
+// NewGoogleError combines the parsed error body and the http response into a GoogleError,
+// it returns nil if neither contains an error
+func NewGoogleError(errorResponse *ErrorResponse, response *http.Response) *GoogleError {
+	googleError := GoogleError{}
+
+	if response != nil {
+		if response.StatusCode >= 200 && response.StatusCode <= 299 {
+			return nil
+		}
+		googleError.HttpCode = response.StatusCode
+	}
+
+	if errorResponse != nil {
+		if errorResponse.Error.Code != 0 {
+			googleError.HttpCode = errorResponse.Error.Code
+		}
+		googleError.Status = errorResponse.Error.Status
+		googleError.Message = errorResponse.Error.Message
+		googleError.Details = errorResponse.Error.Details
+		googleError.Errors = errorResponse.Error.Errors
+
+		for _, detail := range errorResponse.Error.Details {
+			if detail.RequestId != "" {
+				googleError.RequestId = detail.RequestId
+				break
+			}
+		}
+
+		if errorInfo := googleError.ErrorInfo(); errorInfo != nil {
+			googleError.Reason = errorInfo.Reason
+			googleError.Domain = errorInfo.Domain
+		} else if len(googleError.Errors) > 0 {
+			googleError.Reason = googleError.Errors[0].Reason
+			googleError.Domain = googleError.Errors[0].Domain
+		}
+
+		if googleError.Message == "" && len(googleError.Errors) > 0 {
+			googleError.Message = googleError.Errors[0].Message
+		}
+	}
+
+	if googleError.HttpCode == 0 && googleError.Status == "" && googleError.Message == "" {
+		return nil
+	}
+
+	if googleError.RequestId == "" && response != nil {
+		googleError.RequestId = requestIdFromHeader(response.Header)
+	}
+
+	if googleError.Status == "" {
+		// legacy errors have no status, their reason is more specific than the http code
+		googleError.Status = statusFromReason(googleError.Reason)
+	}
+
+	if googleError.Status == "" {
+		googleError.Status = statusFromHttpCode(googleError.HttpCode)
+	}
+
+	if googleError.Message == "" {
+		googleError.Message = http.StatusText(googleError.HttpCode)
+	}
+
+	return &googleError
+}
+
+func (err *GoogleError) Error() string {
+	if err.RequestId != "" {
+		return fmt.Sprintf("google: %v %s: %s (request id %s)", err.HttpCode, err.Status, err.Message, err.RequestId)
+	}
+
+	return fmt.Sprintf("google: %v %s: %s", err.HttpCode, err.Status, err.Message)
+}
+
+func (err *GoogleError) IsNotFound() bool {
+	return err.Status == StatusNotFound
+}
+
+func (err *GoogleError) IsRateLimited() bool {
+	return err.Status == StatusResourceExhausted || err.HttpCode == http.StatusTooManyRequests
+}
+
+func (err *GoogleError) IsRetryable() bool {
+	if err.IsRateLimited() {
+		return true
+	}
+
+	switch err.Status {
+	case StatusUnavailable, StatusDeadlineExceeded, StatusInternal, StatusAborted:
+		return true
+	}
+
+	switch err.HttpCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+func (err *GoogleError) IsAuthError() bool {
+	return err.Status == StatusUnauthenticated || err.Status == StatusPermissionDenied
+}
+
+// ErrorInfo returns the first google.rpc.ErrorInfo detail, nil if there is none
+func (err *GoogleError) ErrorInfo() *ErrorInfo {
+	return firstErrorDetail[ErrorInfo](err.Details)
+}
+
+// RetryInfo returns the first google.rpc.RetryInfo detail, nil if there is none
+func (err *GoogleError) RetryInfo() *RetryInfo {
+	return firstErrorDetail[RetryInfo](err.Details)
+}
+
+// QuotaFailure returns the first google.rpc.QuotaFailure detail, nil if there is none
+func (err *GoogleError) QuotaFailure() *QuotaFailure {
+	return firstErrorDetail[QuotaFailure](err.Details)
+}
+
+// BadRequest returns the first google.rpc.BadRequest detail, nil if there is none
+func (err *GoogleError) BadRequest() *BadRequest {
+	return firstErrorDetail[BadRequest](err.Details)
+}
+
+// PreconditionFailure returns the first google.rpc.PreconditionFailure detail, nil if there is none
+func (err *GoogleError) PreconditionFailure() *PreconditionFailure {
+	return firstErrorDetail[PreconditionFailure](err.Details)
+}
+
+// ResourceInfo returns the first google.rpc.ResourceInfo detail, nil if there is none
+func (err *GoogleError) ResourceInfo() *ResourceInfo {
+	return firstErrorDetail[ResourceInfo](err.Details)
+}
+
+// Help returns the first google.rpc.Help detail, nil if there is none
+func (err *GoogleError) Help() *Help {
+	return firstErrorDetail[Help](err.Details)
+}
+
+// LocalizedMessage returns the first google.rpc.LocalizedMessage detail, nil if there is none
+func (err *GoogleError) LocalizedMessage() *LocalizedMessage {
+	return firstErrorDetail[LocalizedMessage](err.Details)
+}
+
+// DebugInfo returns the first google.rpc.DebugInfo detail, nil if there is none
+func (err *GoogleError) DebugInfo() *DebugInfo {
+	return firstErrorDetail[DebugInfo](err.Details)
+}
+
+func firstErrorDetail[T any](details []ErrorDetail) *T {
+	for _, detail := range details {
+		if value, ok := detail.Value.(*T); ok {
+			return value
+		}
+	}
+	return nil
+}
+
+// RequestError adapts the *errortools.Error returned by the Service, which is no error, to the error interface.
+// It unwraps to its GoogleError, so IsNotFound, IsRateLimited, IsRetryable, IsAuthError and errors.As apply to it.
+type RequestError struct {
+	Err         *errortools.Error
+	GoogleError *GoogleError // nil if the request did not fail with a Google API error
+}
+
+// NewRequestError returns e and googleError as returned by HttpRequestWithGoogleError as an error, nil if e is nil
+func NewRequestError(e *errortools.Error, googleError *GoogleError) error {
+	if e == nil {
+		return nil
+	}
+
+	return &RequestError{
+		Err:         e,
+		GoogleError: googleError,
+	}
+}
+
+func (err *RequestError) Error() string {
+	return err.Err.Message()
+}
+
+func (err *RequestError) Unwrap() error {
+	if err.GoogleError == nil {
+		return nil
+	}
+
+	return err.GoogleError
+}
+
+// IsNotFound reports whether err is or wraps a GoogleError with status NOT_FOUND, see RequestError
+func IsNotFound(err error) bool {
+	var googleError *GoogleError
+	return errors.As(err, &googleError) && googleError.IsNotFound()
+}
+
+// IsRateLimited reports whether err is or wraps a GoogleError caused by an exhausted quota or rate limit
+func IsRateLimited(err error) bool {
+	var googleError *GoogleError
+	return errors.As(err, &googleError) && googleError.IsRateLimited()
+}
+
+// IsRetryable reports whether err is or wraps a GoogleError for which retrying the request may succeed
+func IsRetryable(err error) bool {
+	var googleError *GoogleError
+	return errors.As(err, &googleError) && googleError.IsRetryable()
+}
+
+// IsAuthError reports whether err is or wraps a GoogleError caused by missing or insufficient credentials
+func IsAuthError(err error) bool {
+	var googleError *GoogleError
+	return errors.As(err, &googleError) && googleError.IsAuthError()
+}
+
+// requestIdHeaders are the response headers that carry the request id when the error details have none
+var requestIdHeaders = []string{"X-Goog-Request-Id", "Request-Id", "X-Request-Id"}
+
+func requestIdFromHeader(header http.Header) string {
+	for _, name := range requestIdHeaders {
+		if requestId := header.Get(name); requestId != "" {
+			return requestId
+		}
+	}
+
+	return ""
+}
+
+// statusFromReason maps legacy v1 error reasons onto canonical statuses
+func statusFromReason(reason string) string {
+	switch reason {
+	case "rateLimitExceeded", "userRateLimitExceeded", "dailyLimitExceeded", "dailyLimitExceededUnreg",
+		"quotaExceeded", "limitExceeded", "sharingRateLimitExceeded", "servingLimitExceeded",
+		"variableTermLimitExceeded", "concurrentLimitExceeded", "storageQuotaExceeded":
+		return StatusResourceExhausted
+	case "notFound":
+		return StatusNotFound
+	case "forbidden", "insufficientPermissions", "accessNotConfigured", "accessDenied",
+		"domainPolicy", "appNotAuthorizedToFile", "cannotModifyViewersCanCopyContent":
+		return StatusPermissionDenied
+	case "authError", "unauthorized", "expired":
+		return StatusUnauthenticated
+	case "invalid", "invalidParameter", "badRequest", "required", "parseError", "invalidQuery":
+		return StatusInvalidArgument
+	case "conditionNotMet", "failedPrecondition":
+		return StatusFailedPrecondition
+	case "duplicate", "alreadyExists":
+		return StatusAlreadyExists
+	case "conflict":
+		return StatusAborted
+	case "backendError":
+		return StatusUnavailable
+	case "internalError":
+		return StatusInternal
+	case "deadlineExceeded":
+		return StatusDeadlineExceeded
+	}
+
+	return ""
+}
+
+func statusFromHttpCode(httpCode int) string {
+	switch httpCode {
+	case http.StatusBadRequest:
+		return StatusInvalidArgument
+	case http.StatusUnauthorized:
+		return StatusUnauthenticated
+	case http.StatusForbidden:
+		return StatusPermissionDenied
+	case http.StatusNotFound:
+		return StatusNotFound
+	case http.StatusConflict:
+		return StatusAborted
+	case http.StatusPreconditionFailed:
+		return StatusFailedPrecondition
+	case http.StatusRequestedRangeNotSatisfiable:
+		return StatusOutOfRange
+	case http.StatusTooManyRequests:
+		return StatusResourceExhausted
+	case 499:
+		return StatusCancelled
+	case http.StatusNotImplemented:
+		return StatusUnimplemented
+	case http.StatusServiceUnavailable:
+		return StatusUnavailable
+	case http.StatusGatewayTimeout:
+		return StatusDeadlineExceeded
+	}
+
+	if httpCode >= 500 {
+		return StatusInternal
+	}
+
+	return StatusUnknown
+}
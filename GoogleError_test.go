@@ -0,0 +1,275 @@
+package google
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	testserver "github.com/leapforce-libraries/go_google/internal/testserver"
+	go_http "github.com/leapforce-libraries/go_http"
+)
+
+func TestRequestError(t *testing.T) {
+	withRequestId := func(statusCode int, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Goog-Request-Id", "header-request-id")
+			testserver.WriteJson(w, statusCode, body)
+		}
+	}
+
+	server := testserver.New(t, map[string]http.HandlerFunc{
+		"/v1/things":  withRequestId(http.StatusOK, `{}`),
+		"/v1/missing": withRequestId(http.StatusNotFound, `{"error":{"code":404,"message":"Thing not found","status":"NOT_FOUND"}}`),
+		"/v1/quota":   withRequestId(http.StatusTooManyRequests, `{"error":{"code":429,"message":"Quota exceeded","status":"RESOURCE_EXHAUSTED","details":[{"@type":"type.googleapis.com/google.rpc.RequestInfo","requestId":"detail-request-id"}]}}`),
+	})
+
+	service, e := NewServiceWithAccessToken(&ServiceWithAccessTokenConfig{AccessToken: "access-token"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	service.SetRetryPolicy(&RetryPolicy{MaxAttempts: 1})
+
+	request := func(path string) error {
+		_, _, googleError, e := service.HttpRequestWithGoogleError(&go_http.RequestConfig{Url: server.URL + path})
+		return NewRequestError(e, googleError)
+	}
+
+	if err := request("/v1/things"); err != nil {
+		t.Fatalf("expected a nil error for a successful request, got %v", err)
+	}
+
+	err := request("/v1/missing")
+	if !IsNotFound(err) || IsRateLimited(err) || IsRetryable(err) || IsAuthError(err) {
+		t.Errorf("404 error %v is not only not found", err)
+	}
+	if err.Error() != "Thing not found" {
+		t.Errorf("Error is %q", err.Error())
+	}
+
+	var googleError *GoogleError
+	if !errors.As(err, &googleError) {
+		t.Fatal("errors.As does not find the GoogleError")
+	}
+	if googleError.RequestId != "header-request-id" {
+		t.Errorf("RequestId without details is %q, expected the response header", googleError.RequestId)
+	}
+
+	err = request("/v1/quota")
+	if !IsRateLimited(err) || !IsRetryable(err) || IsNotFound(err) {
+		t.Errorf("429 error %v is not rate limited", err)
+	}
+	if errors.As(err, &googleError) && googleError.RequestId != "detail-request-id" {
+		t.Errorf("RequestId is %q, expected the RequestInfo detail to win over the header", googleError.RequestId)
+	}
+}
+
+func TestRequestErrorWithoutGoogleError(t *testing.T) {
+	err := NewRequestError(errortools.ErrorMessage("connection refused"), nil)
+
+	if err == nil || err.Error() != "connection refused" {
+		t.Fatalf("error is %v", err)
+	}
+	if IsNotFound(err) || IsRateLimited(err) || IsRetryable(err) || IsAuthError(err) {
+		t.Error("an error without GoogleError is classified")
+	}
+	if IsNotFound(nil) {
+		t.Error("nil is classified as not found")
+	}
+}
+
+const testErrorDetailsResponse string = `{
+  "error": {
+    "code": 429,
+    "message": "Quota exceeded for quota metric 'Read requests'",
+    "status": "RESOURCE_EXHAUSTED",
+    "details": [
+      {
+        "@type": "type.googleapis.com/google.rpc.ErrorInfo",
+        "reason": "RATE_LIMIT_EXCEEDED",
+        "domain": "googleapis.com",
+        "metadata": {"quota_metric": "sheets.googleapis.com/read_requests", "service": "sheets.googleapis.com"}
+      },
+      {
+        "@type": "type.googleapis.com/google.rpc.RetryInfo",
+        "retryDelay": "1.500s"
+      },
+      {
+        "@type": "type.googleapis.com/google.rpc.QuotaFailure",
+        "violations": [{"subject": "project:123", "description": "Read requests per minute", "quotaMetric": "sheets.googleapis.com/read_requests", "quotaDimensions": {"user": "123"}}]
+      },
+      {
+        "@type": "type.googleapis.com/google.rpc.BadRequest",
+        "fieldViolations": [{"field": "range", "description": "Unable to parse range", "reason": "INVALID_RANGE", "localizedMessage": {"locale": "nl-NL", "message": "Ongeldig bereik"}}]
+      },
+      {
+        "@type": "type.googleapis.com/google.rpc.Help",
+        "links": [{"description": "Request a higher quota limit", "url": "https://cloud.google.com/docs/quotas/help/request_increase"}]
+      },
+      {
+        "@type": "type.googleapis.com/google.rpc.LocalizedMessage",
+        "locale": "en-US",
+        "message": "Too many read requests"
+      },
+      {
+        "@type": "type.googleapis.com/google.rpc.RequestInfo",
+        "requestId": "detail-request-id"
+      },
+      {
+        "@type": "type.googleapis.com/google.example.Custom",
+        "custom": {"answer": 42}
+      }
+    ]
+  }
+}`
+
+func TestErrorDetails(t *testing.T) {
+	errorResponse := ErrorResponse{}
+	err := json.Unmarshal([]byte(testErrorDetailsResponse), &errorResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	googleError := NewGoogleError(&errorResponse, &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}})
+	if googleError == nil {
+		t.Fatal("no GoogleError")
+	}
+
+	if googleError.HttpCode != 429 || googleError.Status != StatusResourceExhausted || googleError.Message != "Quota exceeded for quota metric 'Read requests'" {
+		t.Errorf("GoogleError is %v", googleError)
+	}
+	if googleError.Reason != "RATE_LIMIT_EXCEEDED" || googleError.Domain != "googleapis.com" || googleError.RequestId != "detail-request-id" {
+		t.Errorf("reason %q, domain %q, request id %q", googleError.Reason, googleError.Domain, googleError.RequestId)
+	}
+
+	if errorInfo := googleError.ErrorInfo(); errorInfo == nil || errorInfo.Metadata["service"] != "sheets.googleapis.com" {
+		t.Errorf("ErrorInfo is %+v", errorInfo)
+	}
+	if retryInfo := googleError.RetryInfo(); retryInfo == nil || retryInfo.Delay() != 1500*time.Millisecond {
+		t.Errorf("RetryInfo is %+v", retryInfo)
+	}
+	if quotaFailure := googleError.QuotaFailure(); quotaFailure == nil || len(quotaFailure.Violations) != 1 ||
+		quotaFailure.Violations[0].Subject != "project:123" || quotaFailure.Violations[0].QuotaDimensions["user"] != "123" {
+		t.Errorf("QuotaFailure is %+v", quotaFailure)
+	}
+	if badRequest := googleError.BadRequest(); badRequest == nil || len(badRequest.FieldViolations) != 1 ||
+		badRequest.FieldViolations[0].Field != "range" || badRequest.FieldViolations[0].LocalizedMessage == nil ||
+		badRequest.FieldViolations[0].LocalizedMessage.Locale != "nl-NL" {
+		t.Errorf("BadRequest is %+v", badRequest)
+	}
+	if help := googleError.Help(); help == nil || len(help.Links) != 1 || help.Links[0].Url != "https://cloud.google.com/docs/quotas/help/request_increase" {
+		t.Errorf("Help is %+v", help)
+	}
+	if localizedMessage := googleError.LocalizedMessage(); localizedMessage == nil || localizedMessage.Message != "Too many read requests" {
+		t.Errorf("LocalizedMessage is %+v", localizedMessage)
+	}
+	if googleError.PreconditionFailure() != nil || googleError.ResourceInfo() != nil || googleError.DebugInfo() != nil {
+		t.Error("details that are not in the response are not nil")
+	}
+
+	// an unknown detail is kept as raw json
+	if len(googleError.Details) != 8 {
+		t.Fatalf("%v details, expected 8", len(googleError.Details))
+	}
+	custom := googleError.Details[7]
+	raw, ok := custom.Value.(json.RawMessage)
+	if custom.Type != "type.googleapis.com/google.example.Custom" || !ok || string(raw) != string(custom.Raw) {
+		t.Fatalf("unknown detail is %+v", custom)
+	}
+	value := struct {
+		Custom struct {
+			Answer int `json:"answer"`
+		} `json:"custom"`
+	}{}
+	err = json.Unmarshal(raw, &value)
+	if err != nil || value.Custom.Answer != 42 {
+		t.Errorf("raw json of the unknown detail is %s", raw)
+	}
+}
+
+func TestErrorDetailsWithoutPrefix(t *testing.T) {
+	errorResponse := ErrorResponse{}
+	err := json.Unmarshal([]byte(`{"error":{"code":400,"message":"Precondition check failed.","status":"FAILED_PRECONDITION","details":[
+		{"@type":"google.rpc.PreconditionFailure","violations":[{"type":"TOS","subject":"google.com/cloud","description":"Terms of service not accepted"}]},
+		{"@type":"type.googleapis.com/google.rpc.ResourceInfo","resourceType":"sheet","resourceName":"Sheet1","description":"locked"},
+		{"@type":"type.googleapis.com/google.rpc.DebugInfo","stackEntries":["a","b"],"detail":"debug"}
+	]}}`), &errorResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	googleError := NewGoogleError(&errorResponse, nil)
+	if googleError == nil || googleError.Status != StatusFailedPrecondition || googleError.Reason != "" {
+		t.Fatalf("GoogleError is %v", googleError)
+	}
+	if preconditionFailure := googleError.PreconditionFailure(); preconditionFailure == nil || len(preconditionFailure.Violations) != 1 || preconditionFailure.Violations[0].Type != "TOS" {
+		t.Errorf("PreconditionFailure is %+v", preconditionFailure)
+	}
+	if resourceInfo := googleError.ResourceInfo(); resourceInfo == nil || resourceInfo.ResourceName != "Sheet1" {
+		t.Errorf("ResourceInfo is %+v", resourceInfo)
+	}
+	if debugInfo := googleError.DebugInfo(); debugInfo == nil || len(debugInfo.StackEntries) != 2 || debugInfo.Detail != "debug" {
+		t.Errorf("DebugInfo is %+v", debugInfo)
+	}
+	if googleError.ErrorInfo() != nil || googleError.RetryInfo() != nil {
+		t.Error("details that are not in the response are not nil")
+	}
+}
+
+func TestLegacyErrorResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		status     string
+		reason     string
+		message    string
+		retryable  bool
+	}{
+		{"rate limit exceeded", 403, `{"error":{"code":403,"message":"Rate Limit Exceeded","errors":[{"domain":"usageLimits","reason":"rateLimitExceeded","message":"Rate Limit Exceeded"}]}}`, StatusResourceExhausted, "rateLimitExceeded", "Rate Limit Exceeded", true},
+		{"user rate limit exceeded", 403, `{"error":{"code":403,"message":"User Rate Limit Exceeded","errors":[{"domain":"usageLimits","reason":"userRateLimitExceeded","message":"User Rate Limit Exceeded"}]}}`, StatusResourceExhausted, "userRateLimitExceeded", "User Rate Limit Exceeded", true},
+		{"daily limit exceeded", 403, `{"error":{"code":403,"message":"Daily Limit Exceeded","errors":[{"domain":"usageLimits","reason":"dailyLimitExceeded","message":"Daily Limit Exceeded"}]}}`, StatusResourceExhausted, "dailyLimitExceeded", "Daily Limit Exceeded", true},
+		{"not found", 404, `{"error":{"code":404,"message":"File not found: abc","errors":[{"domain":"global","reason":"notFound","message":"File not found: abc","locationType":"parameter","location":"fileId"}]}}`, StatusNotFound, "notFound", "File not found: abc", false},
+		{"backend error", 500, `{"error":{"code":500,"message":"Backend Error","errors":[{"domain":"global","reason":"backendError","message":"Backend Error"}]}}`, StatusUnavailable, "backendError", "Backend Error", true},
+		{"internal error", 500, `{"error":{"code":500,"message":"Internal Error","errors":[{"domain":"global","reason":"internalError","message":"Internal Error"}]}}`, StatusInternal, "internalError", "Internal Error", true},
+		{"insufficient permissions", 403, `{"error":{"code":403,"message":"Insufficient Permission","errors":[{"domain":"global","reason":"insufficientPermissions","message":"Insufficient Permission"}]}}`, StatusPermissionDenied, "insufficientPermissions", "Insufficient Permission", false},
+		{"auth error", 401, `{"error":{"code":401,"message":"Invalid Credentials","errors":[{"domain":"global","reason":"authError","message":"Invalid Credentials","locationType":"header","location":"Authorization"}]}}`, StatusUnauthenticated, "authError", "Invalid Credentials", false},
+		{"invalid", 400, `{"error":{"code":400,"message":"Invalid value","errors":[{"domain":"global","reason":"invalid","message":"Invalid value"}]}}`, StatusInvalidArgument, "invalid", "Invalid value", false},
+		{"condition not met", 412, `{"error":{"code":412,"message":"Precondition Failed","errors":[{"domain":"global","reason":"conditionNotMet","message":"Precondition Failed"}]}}`, StatusFailedPrecondition, "conditionNotMet", "Precondition Failed", false},
+		{"duplicate", 409, `{"error":{"code":409,"message":"Already Exists","errors":[{"domain":"global","reason":"duplicate","message":"Already Exists"}]}}`, StatusAlreadyExists, "duplicate", "Already Exists", false},
+		{"unknown reason falls back to the http code", 409, `{"error":{"code":409,"message":"Something new","errors":[{"domain":"global","reason":"somethingNew","message":"Something new"}]}}`, StatusAborted, "somethingNew", "Something new", true},
+		{"message of the first error", 404, `{"error":{"code":404,"errors":[{"domain":"global","reason":"notFound","message":"Not Found"}]}}`, StatusNotFound, "notFound", "Not Found", false},
+		{"code of the response", 503, `{"error":{"errors":[{"domain":"global","reason":"backendError","message":"Backend Error"}]}}`, StatusUnavailable, "backendError", "Backend Error", true},
+		{"status wins over the reason", 403, `{"error":{"code":403,"message":"Quota exceeded","status":"PERMISSION_DENIED","errors":[{"domain":"usageLimits","reason":"rateLimitExceeded","message":"Quota exceeded"}]}}`, StatusPermissionDenied, "rateLimitExceeded", "Quota exceeded", false},
+		{"no body", 502, ``, StatusInternal, "", "Bad Gateway", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errorResponse := ErrorResponse{}
+			if test.body != "" {
+				err := json.Unmarshal([]byte(test.body), &errorResponse)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			googleError := NewGoogleError(&errorResponse, &http.Response{StatusCode: test.statusCode, Header: http.Header{}})
+			if googleError == nil {
+				t.Fatal("no GoogleError")
+			}
+
+			if googleError.HttpCode != test.statusCode || googleError.Status != test.status || googleError.Reason != test.reason || googleError.Message != test.message {
+				t.Errorf("GoogleError is %v with reason %q, expected %v %s: %s with reason %q", googleError, googleError.Reason, test.statusCode, test.status, test.message, test.reason)
+			}
+			if googleError.IsRetryable() != test.retryable {
+				t.Errorf("IsRetryable is %v", googleError.IsRetryable())
+			}
+			if test.reason != "" && (len(googleError.Errors) != 1 || googleError.Domain != googleError.Errors[0].Domain) {
+				t.Errorf("errors %+v, domain %q", googleError.Errors, googleError.Domain)
+			}
+		})
+	}
+}
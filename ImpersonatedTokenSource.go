@@ -0,0 +1,187 @@
+package google
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	go_token "github.com/leapforce-libraries/go_oauth2/token"
+)
+
+const (
+	defaultIamCredentialsUrl     string        = "https://iamcredentials.googleapis.com"
+	defaultImpersonationLifetime time.Duration = time.Hour
+)
+
+// ImpersonatedTokenSource retrieves short-lived access tokens for a target service account
+// through the IAM Credentials API, authorized by a source Service.
+// NewToken runs while go_oauth2 holds its token lock, so it cannot ask the source Service for a token itself:
+// the source token is resolved beforehand by ResolveSourceToken, which a Service created by
+// NewServiceWithImpersonation calls before every request.
+type ImpersonatedTokenSource struct {
+	tokenCache
+	sourceService          *Service
+	sourceTokenMutex       sync.Mutex
+	sourceToken            string
+	generateAccessTokenUrl string
+	delegates              []string
+	scopes                 []string
+	lifetime               time.Duration
+	httpClient             *http.Client
+}
+
+type ImpersonatedTokenSourceConfig struct {
+	SourceService     *Service
+	TargetPrincipal   string   // email of the service account to impersonate
+	Delegates         []string // emails of the service accounts in the delegation chain
+	Scopes            []string
+	Lifetime          *time.Duration
+	IamCredentialsUrl *string
+	HttpClient        *http.Client // defaults to the http.Client of SourceService
+}
+
+func NewImpersonatedTokenSource(cfg *ImpersonatedTokenSourceConfig) (*ImpersonatedTokenSource, *errortools.Error) {
+	if cfg == nil {
+		return nil, errortools.ErrorMessage("ImpersonatedTokenSourceConfig must not be a nil pointer")
+	}
+
+	if cfg.SourceService == nil {
+		return nil, errortools.ErrorMessage("SourceService not provided")
+	}
+
+	if cfg.TargetPrincipal == "" {
+		return nil, errortools.ErrorMessage("TargetPrincipal not provided")
+	}
+
+	if len(cfg.Scopes) == 0 {
+		return nil, errortools.ErrorMessage("Scopes not provided")
+	}
+
+	iamCredentialsUrl := defaultIamCredentialsUrl
+	if cfg.IamCredentialsUrl != nil {
+		iamCredentialsUrl = strings.TrimSuffix(*cfg.IamCredentialsUrl, "/")
+	}
+
+	httpClient := cfg.HttpClient
+	if httpClient == nil {
+		httpClient = cfg.SourceService.httpClient
+	}
+
+	lifetime := defaultImpersonationLifetime
+	if cfg.Lifetime != nil {
+		lifetime = *cfg.Lifetime
+	}
+
+	return &ImpersonatedTokenSource{
+		sourceService:          cfg.SourceService,
+		generateAccessTokenUrl: fmt.Sprintf("%s/v1/%s:generateAccessToken", iamCredentialsUrl, serviceAccountResourceName(cfg.TargetPrincipal)),
+		delegates:              cfg.Delegates,
+		scopes:                 cfg.Scopes,
+		lifetime:               lifetime,
+		httpClient:             httpClient,
+	}, nil
+}
+
+// ResolveSourceToken retrieves a valid access token of the source Service for the next NewToken,
+// it must not be called while a token of the impersonated credentials is being validated
+func (t *ImpersonatedTokenSource) ResolveSourceToken() *errortools.Error {
+	sourceToken, e := t.sourceService.bearerToken()
+	if e != nil {
+		return e
+	}
+
+	t.sourceTokenMutex.Lock()
+	defer t.sourceTokenMutex.Unlock()
+
+	t.sourceToken = sourceToken
+
+	return nil
+}
+
+// NewToken exchanges the source token for an access token of the target service account
+func (t *ImpersonatedTokenSource) NewToken() (*go_token.Token, *errortools.Error) {
+	t.sourceTokenMutex.Lock()
+	sourceToken := t.sourceToken
+	t.sourceTokenMutex.Unlock()
+
+	if sourceToken == "" {
+		return nil, errortools.ErrorMessage("Source token not resolved, call ResolveSourceToken first")
+	}
+
+	return generateAccessToken(t.httpClient, sourceToken, t.generateAccessTokenUrl, t.delegates, t.scopes, t.lifetime)
+}
+
+type generateAccessTokenRequest struct {
+	Delegates []string `json:"delegates,omitempty"`
+	Scope     []string `json:"scope"`
+	Lifetime  string   `json:"lifetime,omitempty"`
+}
+
+type generateAccessTokenResponse struct {
+	AccessToken string    `json:"accessToken"`
+	ExpireTime  time.Time `json:"expireTime"`
+}
+
+// generateAccessToken calls the IAM Credentials generateAccessToken method authorized by sourceToken.
+// It uses a plain http.Client, a Service would validate its own token and deadlock on the go_oauth2 token lock.
+func generateAccessToken(httpClient *http.Client, sourceToken string, url string, delegates []string, scopes []string, lifetime time.Duration) (*go_token.Token, *errortools.Error) {
+	var _delegates []string
+	for _, delegate := range delegates {
+		_delegates = append(_delegates, serviceAccountResourceName(delegate))
+	}
+
+	body, err := json.Marshal(generateAccessTokenRequest{
+		Delegates: _delegates,
+		Scope:     scopes,
+		Lifetime:  fmt.Sprintf("%vs", int64(lifetime.Seconds())),
+	})
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Authorization", "Bearer "+sourceToken)
+
+	b, e := doTokenRequest(httpClient, request)
+	if e != nil {
+		return nil, e
+	}
+
+	response := generateAccessTokenResponse{}
+	err = json.Unmarshal(b, &response)
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+
+	if response.AccessToken == "" {
+		return nil, errortools.ErrorMessage("generateAccessToken returned no accessToken")
+	}
+
+	// oauth2 service derives the expiry from ExpiresIn
+	expiresIn := json.RawMessage(fmt.Sprintf("%v", int64(time.Until(response.ExpireTime).Seconds())))
+	tokenType := "Bearer"
+
+	return &go_token.Token{
+		AccessToken: &response.AccessToken,
+		TokenType:   &tokenType,
+		ExpiresIn:   &expiresIn,
+	}, nil
+}
+
+func serviceAccountResourceName(email string) string {
+	if strings.HasPrefix(email, "projects/") {
+		return email
+	}
+
+	return fmt.Sprintf("projects/-/serviceAccounts/%s", email)
+}
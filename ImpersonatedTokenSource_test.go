@@ -0,0 +1,181 @@
+package google
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	credentials "github.com/leapforce-libraries/go_google/credentials"
+	testserver "github.com/leapforce-libraries/go_google/internal/testserver"
+	go_http "github.com/leapforce-libraries/go_http"
+)
+
+const (
+	testTargetPrincipal string = "target@project.iam.gserviceaccount.com"
+	testDelegate        string = "delegate@project.iam.gserviceaccount.com"
+
+	testGenerateAccessTokenPath string = "/v1/projects/-/serviceAccounts/" + testTargetPrincipal + ":generateAccessToken"
+)
+
+// newIamCredentialsServer serves a token endpoint for the source credentials, the generateAccessToken method
+// of the IAM Credentials API and an api that requires the impersonated token
+func newIamCredentialsServer(t *testing.T, iamStatusCode int) *testserver.Server {
+	return testserver.New(t, map[string]http.HandlerFunc{
+		"/token": func(w http.ResponseWriter, r *http.Request) {
+			testserver.WriteJson(w, http.StatusOK, `{"access_token":"source-token","expires_in":3600,"token_type":"Bearer"}`)
+		},
+		testGenerateAccessTokenPath: func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer source-token" {
+				t.Errorf("generateAccessToken authorization is %q", r.Header.Get("Authorization"))
+			}
+
+			request := generateAccessTokenRequest{}
+			err := json.NewDecoder(r.Body).Decode(&request)
+			if err != nil {
+				t.Errorf("generateAccessToken body: %v", err)
+			}
+			if len(request.Delegates) != 1 || request.Delegates[0] != "projects/-/serviceAccounts/"+testDelegate {
+				t.Errorf("generateAccessToken delegates are %v", request.Delegates)
+			}
+			if strings.Join(request.Scope, " ") != cloudPlatformScope || request.Lifetime != "3600s" {
+				t.Errorf("generateAccessToken scope %v, lifetime %s", request.Scope, request.Lifetime)
+			}
+
+			if iamStatusCode != http.StatusOK {
+				testserver.WriteJson(w, iamStatusCode, `{"error":{"code":403,"message":"Permission iam.serviceAccounts.getAccessToken denied","status":"PERMISSION_DENIED"}}`)
+				return
+			}
+
+			testserver.WriteJson(w, http.StatusOK, generateAccessTokenResponse{
+				AccessToken: "impersonated-token",
+				ExpireTime:  time.Now().Add(time.Hour),
+			})
+		},
+		"/v1/things": func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer impersonated-token" {
+				testserver.WriteJson(w, http.StatusUnauthorized, `{"error":{"code":401,"message":"wrong token","status":"UNAUTHENTICATED"}}`)
+				return
+			}
+			testserver.WriteJson(w, http.StatusOK, `{"id":"thing"}`)
+		},
+	})
+}
+
+// newImpersonatedTestService returns an impersonating Service whose source is an authorized_user Service,
+// both validate their tokens through go_oauth2
+func newImpersonatedTestService(t *testing.T, server *testserver.Server) *Service {
+	sourceTokenSource, e := NewAuthorizedUserTokenSource(&AuthorizedUserTokenSourceConfig{
+		CredentialsJson: &credentials.CredentialsJson{
+			Type:         credentials.TypeAuthorizedUser,
+			ClientId:     "client-id",
+			ClientSecret: "client-secret",
+			RefreshToken: "refresh-token",
+			TokenUri:     server.URL + "/token",
+		},
+	})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	sourceService, e := newServiceWithTokenSource("test", authorizationModeAuthorizedUser, "client-id", sourceTokenSource, nil)
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	iamCredentialsUrl := server.URL
+	service, e := NewServiceWithImpersonation(&ServiceWithImpersonationConfig{
+		ApiName:           "test",
+		SourceService:     sourceService,
+		TargetPrincipal:   testTargetPrincipal,
+		Delegates:         []string{testDelegate},
+		Scopes:            []string{cloudPlatformScope},
+		IamCredentialsUrl: &iamCredentialsUrl,
+	})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	return service
+}
+
+// httpRequestWithTimeout fails the test if the request does not return, e.g. because of a deadlock
+func httpRequestWithTimeout(t *testing.T, service *Service, requestConfig *go_http.RequestConfig) *errortools.Error {
+	done := make(chan *errortools.Error, 1)
+	go func() {
+		_, _, e := service.HttpRequest(requestConfig)
+		done <- e
+	}()
+
+	select {
+	case e := <-done:
+		return e
+	case <-time.After(10 * time.Second):
+		t.Fatal("HttpRequest did not return, the token validation deadlocked")
+		return nil
+	}
+}
+
+func TestImpersonatedServiceRequest(t *testing.T) {
+	server := newIamCredentialsServer(t, http.StatusOK)
+
+	service := newImpersonatedTestService(t, server)
+
+	for i := 0; i < 3; i++ {
+		response := struct {
+			Id string `json:"id"`
+		}{}
+
+		e := httpRequestWithTimeout(t, service, &go_http.RequestConfig{
+			Url:           server.URL + "/v1/things",
+			ResponseModel: &response,
+		})
+		if e != nil {
+			t.Fatalf("request %v: %s", i, e.Message())
+		}
+		if response.Id != "thing" {
+			t.Fatalf("request %v returned id %q", i, response.Id)
+		}
+	}
+
+	// the impersonated token is cached until it expires
+	if generated := len(server.Requests(testGenerateAccessTokenPath)); generated != 1 {
+		t.Errorf("generateAccessToken called %v times, expected 1", generated)
+	}
+}
+
+func TestImpersonatedServiceDenied(t *testing.T) {
+	server := newIamCredentialsServer(t, http.StatusForbidden)
+
+	service := newImpersonatedTestService(t, server)
+
+	e := httpRequestWithTimeout(t, service, &go_http.RequestConfig{
+		Url: server.URL + "/v1/things",
+	})
+	if e == nil {
+		t.Fatal("expected an error when generateAccessToken is denied")
+	}
+}
+
+func TestImpersonatedTokenSourceUnresolved(t *testing.T) {
+	sourceService, e := NewServiceWithAccessToken(&ServiceWithAccessTokenConfig{AccessToken: "source-token"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	tokenSource, e := NewImpersonatedTokenSource(&ImpersonatedTokenSourceConfig{
+		SourceService:   sourceService,
+		TargetPrincipal: testTargetPrincipal,
+		Scopes:          []string{cloudPlatformScope},
+	})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	_, e = tokenSource.NewToken()
+	if e == nil {
+		t.Fatal("expected an error for a NewToken without resolved source token")
+	}
+}
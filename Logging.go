@@ -0,0 +1,115 @@
+package google
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	credentials "github.com/leapforce-libraries/go_google/credentials"
+	go_http "github.com/leapforce-libraries/go_http"
+)
+
+// bodies up to maxDumpBodySize are included in the dumps, e.g. no uploaded or downloaded media
+const maxDumpBodySize int64 = 64 * 1024
+
+// SetLogger makes the Service log retries and rate limiting to logger, and dump requests and responses at debug level.
+// Credentials are always redacted, see credentials.Redact. Nil discards all logging, which is the default.
+// Set it before the Service is used by multiple goroutines.
+func (service *Service) SetLogger(logger *slog.Logger) {
+	service.logger = credentials.NewRedactLogger(logger)
+}
+
+func (service *Service) log() *slog.Logger {
+	if service.logger == nil {
+		return discardLogger
+	}
+
+	return service.logger
+}
+
+var discardLogger = credentials.NewRedactLogger(nil)
+
+// loggingTransport dumps requests and responses at debug level
+type loggingTransport struct {
+	logger *slog.Logger
+	base   http.RoundTripper
+}
+
+func (t *loggingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx := request.Context()
+
+	if !t.logger.Enabled(ctx, slog.LevelDebug) {
+		return t.base.RoundTrip(request)
+	}
+
+	dump, err := httputil.DumpRequestOut(request, request.ContentLength >= 0 && request.ContentLength <= maxDumpBodySize)
+	if err == nil {
+		t.logger.DebugContext(ctx, "google request", "dump", string(dump))
+	}
+
+	response, err := t.base.RoundTrip(request)
+	if err != nil {
+		t.logger.DebugContext(ctx, "google request failed", "method", request.Method, "url", request.URL.String(), "error", err)
+		return response, err
+	}
+
+	dump, err = httputil.DumpResponse(response, dumpResponseBody(response))
+	if err == nil {
+		t.logger.DebugContext(ctx, "google response", "method", request.Method, "url", request.URL.String(), "dump", string(dump))
+	}
+
+	return response, nil
+}
+
+// loggingHttpClient returns a copy of httpClient that dumps its requests and responses to logger
+func loggingHttpClient(ctx context.Context, logger *slog.Logger, httpClient *http.Client) *http.Client {
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return httpClient
+	}
+
+	client := *httpClient
+
+	base := httpClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = &loggingTransport{logger: logger, base: base}
+
+	return &client
+}
+
+func dumpResponseBody(response *http.Response) bool {
+	if response.ContentLength < 0 {
+		// unknown length, only api responses are dumped
+		return strings.Contains(response.Header.Get("Content-Type"), "json")
+	}
+
+	return response.ContentLength <= maxDumpBodySize
+}
+
+func (service *Service) logRetry(ctx context.Context, requestConfig *go_http.RequestConfig, attempt int, delay time.Duration, response *http.Response, googleError *GoogleError, e *errortools.Error) {
+	logger := service.log()
+	if !logger.Enabled(ctx, slog.LevelWarn) {
+		return
+	}
+
+	method := requestConfig.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	attrs := []any{"api", service.apiName, "method", method, "url", requestConfig.Url, "attempt", attempt, "delay", delay}
+	if response != nil {
+		attrs = append(attrs, "status_code", response.StatusCode)
+	}
+	if googleError != nil && googleError.Reason != "" {
+		attrs = append(attrs, "reason", googleError.Reason)
+	}
+	attrs = append(attrs, "error", e.Message())
+
+	logger.WarnContext(ctx, "retrying google request", attrs...)
+}
@@ -0,0 +1,271 @@
+package google
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	go_http "github.com/leapforce-libraries/go_http"
+)
+
+const (
+	defaultDownloadChunkSize  int64 = 8 * 1024 * 1024
+	defaultDownloadMaxResumes int   = 5
+)
+
+type MediaDownloadConfig struct {
+	Url        string // alt=media is added
+	Parameters *url.Values
+	Writer     io.Writer
+	ChunkSize  *int64 // bytes per Range request, defaults to 8 MiB
+	Offset     int64  // continue an earlier download from this byte, checksums are then not verified
+	MaxResumes *int   // consecutive failed chunks to resume after, defaults to 5
+	OnProgress func(downloaded int64)
+}
+
+// DownloadMedia streams the media to cfg.Writer in Range requests, resuming from the last written byte after a failure.
+// Checksums in x-goog-hash are verified. It returns the offset after the last written byte.
+func (service *Service) DownloadMedia(cfg *MediaDownloadConfig) (int64, *errortools.Error) {
+	return service.DownloadMediaContext(context.Background(), cfg)
+}
+
+// DownloadMediaContext is DownloadMedia honoring the cancellation, deadline and values of ctx
+func (service *Service) DownloadMediaContext(ctx context.Context, cfg *MediaDownloadConfig) (int64, *errortools.Error) {
+	if cfg == nil {
+		return 0, errortools.ErrorMessage("MediaDownloadConfig must not be a nil pointer")
+	}
+
+	if cfg.Url == "" {
+		return 0, errortools.ErrorMessage("Url not provided")
+	}
+
+	if cfg.Writer == nil {
+		return 0, errortools.ErrorMessage("Writer not provided")
+	}
+
+	chunkSize := defaultDownloadChunkSize
+	if cfg.ChunkSize != nil {
+		if *cfg.ChunkSize <= 0 {
+			return 0, errortools.ErrorMessage("ChunkSize must be greater than zero")
+		}
+		chunkSize = *cfg.ChunkSize
+	}
+
+	maxResumes := defaultDownloadMaxResumes
+	if cfg.MaxResumes != nil {
+		maxResumes = *cfg.MaxResumes
+	}
+
+	parameters := url.Values{}
+	if cfg.Parameters != nil {
+		parameters = cloneValues(*cfg.Parameters)
+	}
+	parameters.Set("alt", "media")
+
+	download := mediaDownload{
+		writer:   cfg.Writer,
+		offset:   cfg.Offset,
+		total:    -1,
+		checksum: newChecksums(cfg.Offset == 0),
+	}
+
+	resumes := 0
+
+	for download.total < 0 || download.offset < download.total {
+		header := http.Header{}
+		header.Set("Range", fmt.Sprintf("bytes=%v-%v", download.offset, download.offset+chunkSize-1))
+
+		requestConfig := go_http.RequestConfig{
+			Method:            http.MethodGet,
+			Url:               cfg.Url,
+			Parameters:        &parameters,
+			NonDefaultHeaders: &header,
+		}
+
+		offset := download.offset
+
+		response, done, e := download.chunk(ctx, service, &requestConfig)
+		if cfg.OnProgress != nil && download.offset > offset {
+			cfg.OnProgress(download.offset)
+		}
+		if done {
+			break
+		}
+		if e == nil {
+			resumes = 0
+			continue
+		}
+
+		// resume unless the failure is permanent
+		if download.permanent || (response != nil && response.StatusCode >= 300 && !resumable(response)) || resumes >= maxResumes || ctx.Err() != nil {
+			return download.offset, e
+		}
+		if download.offset == offset {
+			resumes++
+		}
+
+		select {
+		case <-ctx.Done():
+			return download.offset, errortools.ErrorMessage(ctx.Err())
+		case <-time.After(DefaultRetryPolicy().backoff(resumes + 1)):
+		}
+	}
+
+	return download.offset, download.checksum.verify()
+}
+
+type mediaDownload struct {
+	writer    io.Writer
+	offset    int64
+	total     int64 // -1 while unknown
+	checksum  *checksums
+	permanent bool // the failure cannot be resumed from
+}
+
+// chunk downloads one Range, the bool is true when the media is complete
+func (download *mediaDownload) chunk(ctx context.Context, service *Service, requestConfig *go_http.RequestConfig) (*http.Response, bool, *errortools.Error) {
+	_, response, _, _, e := service.httpRequest(ctx, requestConfig)
+	if response != nil && response.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// the offset is at the end of the media
+		response.Body.Close()
+		return response, true, nil
+	}
+	if e != nil {
+		return response, false, e
+	}
+	defer response.Body.Close()
+
+	done := false
+
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+		total, e := contentRangeTotal(response.Header.Get("Content-Range"))
+		if e != nil {
+			return response, false, e
+		}
+		download.total = total
+	default:
+		// the server ignored the Range and sends the complete media
+		if download.offset > 0 {
+			download.permanent = true
+			return response, false, errortools.ErrorMessagef("Server does not support Range requests, cannot continue at byte %v", download.offset)
+		}
+		done = true
+	}
+
+	download.checksum.expect(response.Header)
+
+	writer := &downloadWriter{download: download}
+	_, err := io.Copy(writer, response.Body)
+	if err != nil {
+		return response, false, errortools.ErrorMessage(err)
+	}
+
+	return response, done || download.offset >= download.total, nil
+}
+
+// downloadWriter writes to the writer of the download and the checksums, and advances the offset
+type downloadWriter struct {
+	download *mediaDownload
+}
+
+func (w *downloadWriter) Write(p []byte) (int, error) {
+	n, err := w.download.writer.Write(p)
+	w.download.checksum.write(p[:n])
+	w.download.offset += int64(n)
+	if err != nil {
+		w.download.permanent = true
+	}
+
+	return n, err
+}
+
+// contentRangeTotal returns the total of a Content-Range header like "bytes 0-1023/4096"
+func contentRangeTotal(contentRange string) (int64, *errortools.Error) {
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return 0, errortools.ErrorMessagef("Invalid Content-Range header '%s'", contentRange)
+	}
+
+	total, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return 0, errortools.ErrorMessagef("Content-Range header '%s' has no total size", contentRange)
+	}
+
+	return total, nil
+}
+
+// checksums verifies the crc32c and md5 hashes the server sends in x-goog-hash
+type checksums struct {
+	enabled  bool
+	crc32c   hash.Hash32
+	md5      hash.Hash
+	expected map[string]string
+}
+
+func newChecksums(enabled bool) *checksums {
+	return &checksums{
+		enabled:  enabled,
+		crc32c:   crc32.New(crc32.MakeTable(crc32.Castagnoli)),
+		md5:      md5.New(),
+		expected: make(map[string]string),
+	}
+}
+
+func (c *checksums) write(p []byte) {
+	if !c.enabled {
+		return
+	}
+
+	c.crc32c.Write(p)
+	c.md5.Write(p)
+}
+
+func (c *checksums) expect(header http.Header) {
+	// the hashes of gzip stored objects are of the stored, not the served bytes
+	if header.Get("X-Goog-Stored-Content-Encoding") == "gzip" {
+		c.enabled = false
+	}
+
+	for _, value := range header.Values("X-Goog-Hash") {
+		for _, entry := range strings.Split(value, ",") {
+			name, sum, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if ok {
+				c.expected[name] = sum
+			}
+		}
+	}
+}
+
+func (c *checksums) verify() *errortools.Error {
+	if !c.enabled {
+		return nil
+	}
+
+	if expected, ok := c.expected["crc32c"]; ok {
+		sum := make([]byte, 4)
+		binary.BigEndian.PutUint32(sum, c.crc32c.Sum32())
+		if actual := base64.StdEncoding.EncodeToString(sum); actual != expected {
+			return errortools.ErrorMessagef("crc32c checksum mismatch: expected %s, got %s", expected, actual)
+		}
+	}
+
+	if expected, ok := c.expected["md5"]; ok {
+		if actual := base64.StdEncoding.EncodeToString(c.md5.Sum(nil)); actual != expected {
+			return errortools.ErrorMessagef("md5 checksum mismatch: expected %s, got %s", expected, actual)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,206 @@
+package google
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	testserver "github.com/leapforce-libraries/go_google/internal/testserver"
+)
+
+// mediaServer is a stand-in of a media download endpoint serving media on /download/v1/media
+type mediaServer struct {
+	*testserver.Server
+	media        []byte
+	hash         string // x-goog-hash, defaults to the hashes of media
+	gzipStored   bool   // the media is stored gzip encoded
+	ignoreRange  bool
+	breakRequest int // number of the request that breaks off halfway its body
+}
+
+func newMediaServer(t *testing.T, media []byte) *mediaServer {
+	server := mediaServer{media: media, hash: testGoogHash(media)}
+
+	server.Server = testserver.New(t, map[string]http.HandlerFunc{
+		"GET /download/v1/media": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("alt") != "media" {
+				t.Errorf("media requested with alt %q", r.URL.Query().Get("alt"))
+			}
+
+			w.Header().Set("X-Goog-Hash", server.hash)
+			if server.gzipStored {
+				w.Header().Set("X-Goog-Stored-Content-Encoding", "gzip")
+			}
+
+			body := server.media
+			statusCode := http.StatusOK
+
+			if !server.ignoreRange {
+				var first, last int
+				_, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &first, &last)
+				if err != nil {
+					t.Errorf("invalid Range %q", r.Header.Get("Range"))
+				}
+				if first >= len(server.media) {
+					w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+					return
+				}
+				last = min(last, len(server.media)-1)
+
+				body = server.media[first : last+1]
+				statusCode = http.StatusPartialContent
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %v-%v/%v", first, last, len(server.media)))
+			}
+
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.WriteHeader(statusCode)
+
+			if len(server.Requests("/download/v1/media")) == server.breakRequest {
+				// the connection is closed before Content-Length bytes are written
+				w.Write(body[:len(body)/2])
+				return
+			}
+			w.Write(body)
+		},
+	})
+
+	return &server
+}
+
+// testGoogHash returns the x-goog-hash header of media
+func testGoogHash(media []byte) string {
+	crc32c := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc32c, crc32.Checksum(media, crc32.MakeTable(crc32.Castagnoli)))
+	md5Sum := md5.Sum(media)
+
+	return fmt.Sprintf("crc32c=%s,md5=%s", base64.StdEncoding.EncodeToString(crc32c), base64.StdEncoding.EncodeToString(md5Sum[:]))
+}
+
+func (server *mediaServer) ranges() []string {
+	ranges := []string{}
+	for _, request := range server.Requests("/download/v1/media") {
+		ranges = append(ranges, request.Header.Get("Range"))
+	}
+
+	return ranges
+}
+
+func TestDownloadMedia(t *testing.T) {
+	media := testMedia(2500)
+	otherMedia := testMedia(2501)[1:]
+
+	tests := []struct {
+		name         string
+		media        []byte
+		setup        func(server *mediaServer)
+		offset       int64
+		ranges       []string
+		errorMessage string
+	}{
+		{"chunks", media, nil, 0, []string{"bytes=0-999", "bytes=1000-1999", "bytes=2000-2999"}, ""},
+		{"chunks ending at the end of the media", media[:2000], nil, 0, []string{"bytes=0-999", "bytes=1000-1999"}, ""},
+		{"resume after a partial read", media, func(server *mediaServer) { server.breakRequest = 2 }, 0, []string{"bytes=0-999", "bytes=1000-1999", "bytes=1500-2499"}, ""},
+		{"server ignores Range", media, func(server *mediaServer) { server.ignoreRange = true }, 0, []string{"bytes=0-999"}, ""},
+		{"crc32c mismatch", media, func(server *mediaServer) { server.hash = testGoogHash(otherMedia) }, 0, []string{"bytes=0-999", "bytes=1000-1999", "bytes=2000-2999"}, "crc32c checksum mismatch"},
+		{"md5 mismatch", media, func(server *mediaServer) { server.hash = "md5=" + strings.Split(testGoogHash(otherMedia), "md5=")[1] }, 0, []string{"bytes=0-999", "bytes=1000-1999", "bytes=2000-2999"}, "md5 checksum mismatch"},
+		{"gzip stored media is not verified", media, func(server *mediaServer) { server.hash = testGoogHash(otherMedia); server.gzipStored = true }, 0, []string{"bytes=0-999", "bytes=1000-1999", "bytes=2000-2999"}, ""},
+		{"continue at an offset without verification", media, func(server *mediaServer) { server.hash = testGoogHash(otherMedia) }, 1200, []string{"bytes=1200-2199", "bytes=2200-3199"}, ""},
+		{"continue at the end of the media", media, nil, 2500, []string{"bytes=2500-3499"}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newMediaServer(t, test.media)
+			if test.setup != nil {
+				test.setup(server)
+			}
+
+			service, e := NewServiceWithAccessToken(&ServiceWithAccessTokenConfig{AccessToken: "access-token"})
+			if e != nil {
+				t.Fatal(e.Message())
+			}
+
+			buffer := bytes.Buffer{}
+			chunkSize := int64(1000)
+			progress := []int64{}
+
+			offset, e := service.DownloadMedia(&MediaDownloadConfig{
+				Url:        server.URL + "/download/v1/media",
+				Writer:     &buffer,
+				ChunkSize:  &chunkSize,
+				Offset:     test.offset,
+				OnProgress: func(downloaded int64) { progress = append(progress, downloaded) },
+			})
+
+			if test.errorMessage != "" {
+				if e == nil || !strings.Contains(e.Message(), test.errorMessage) {
+					t.Errorf("error is %v, expected %q", e, test.errorMessage)
+				}
+			} else if e != nil {
+				t.Fatal(e.Message())
+			}
+
+			if !bytes.Equal(buffer.Bytes(), test.media[test.offset:]) {
+				t.Errorf("%v bytes written that differ from the media", buffer.Len())
+			}
+			if offset != int64(len(test.media)) {
+				t.Errorf("offset is %v, expected %v", offset, len(test.media))
+			}
+			if fmt.Sprint(server.ranges()) != fmt.Sprint(test.ranges) {
+				t.Errorf("Ranges are %q, expected %q", server.ranges(), test.ranges)
+			}
+			if len(progress) > 0 && progress[len(progress)-1] != offset {
+				t.Errorf("progress %v ends before offset %v", progress, offset)
+			}
+		})
+	}
+}
+
+func TestDownloadMediaIgnoredRangeAtOffset(t *testing.T) {
+	server := newMediaServer(t, testMedia(2500))
+	server.ignoreRange = true
+
+	service, e := NewServiceWithAccessToken(&ServiceWithAccessTokenConfig{AccessToken: "access-token"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	buffer := bytes.Buffer{}
+	offset, e := service.DownloadMedia(&MediaDownloadConfig{
+		Url:    server.URL + "/download/v1/media",
+		Writer: &buffer,
+		Offset: 1000,
+	})
+
+	// the complete media cannot be appended to a partial download
+	if e == nil || offset != 1000 || buffer.Len() != 0 || len(server.ranges()) != 1 {
+		t.Errorf("DownloadMedia returned %v, %v after %v requests and writing %v bytes", offset, e, len(server.ranges()), buffer.Len())
+	}
+}
+
+func TestContentRangeTotal(t *testing.T) {
+	tests := []struct {
+		contentRange string
+		total        int64
+		valid        bool
+	}{
+		{"bytes 0-1023/4096", 4096, true},
+		{"bytes 4000-4095/4096", 4096, true},
+		{"bytes 0-1023/*", 0, false},
+		{"bytes 0-1023", 0, false},
+	}
+
+	for _, test := range tests {
+		total, e := contentRangeTotal(test.contentRange)
+		if (e == nil) != test.valid || total != test.total {
+			t.Errorf("contentRangeTotal(%q) is %v, %v", test.contentRange, total, e)
+		}
+	}
+}
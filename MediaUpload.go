@@ -0,0 +1,156 @@
+package google
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	go_http "github.com/leapforce-libraries/go_http"
+)
+
+const defaultMediaContentType string = "application/octet-stream"
+
+// MediaUploadConfig describes a simple (uploadType=media) or multipart (uploadType=multipart) upload.
+// The media is sent in a single request, use ResumableUpload for large files.
+type MediaUploadConfig struct {
+	Method        string // defaults to POST
+	Url           string // upload url of the api, uploadType is added
+	Parameters    *url.Values
+	Metadata      interface{} // sent as json, only for multipart uploads
+	Media         io.Reader
+	ContentType   string // of the media, defaults to application/octet-stream
+	ResponseModel interface{}
+}
+
+// UploadMedia uploads the media without metadata (uploadType=media)
+func (service *Service) UploadMedia(cfg *MediaUploadConfig) (*http.Response, *errortools.Error) {
+	return service.UploadMediaContext(context.Background(), cfg)
+}
+
+// UploadMediaContext is UploadMedia honoring the cancellation, deadline and values of ctx
+func (service *Service) UploadMediaContext(ctx context.Context, cfg *MediaUploadConfig) (*http.Response, *errortools.Error) {
+	e := validateMediaUploadConfig(cfg)
+	if e != nil {
+		return nil, e
+	}
+
+	b, err := io.ReadAll(cfg.Media)
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+
+	return service.upload(ctx, cfg, "media", mediaContentType(cfg), b)
+}
+
+// UploadMultipart uploads the json metadata and the media in one multipart/related body (uploadType=multipart)
+func (service *Service) UploadMultipart(cfg *MediaUploadConfig) (*http.Response, *errortools.Error) {
+	return service.UploadMultipartContext(context.Background(), cfg)
+}
+
+// UploadMultipartContext is UploadMultipart honoring the cancellation, deadline and values of ctx
+func (service *Service) UploadMultipartContext(ctx context.Context, cfg *MediaUploadConfig) (*http.Response, *errortools.Error) {
+	e := validateMediaUploadConfig(cfg)
+	if e != nil {
+		return nil, e
+	}
+
+	if cfg.Metadata == nil {
+		return nil, errortools.ErrorMessage("Metadata not provided")
+	}
+
+	metadata, err := json.Marshal(cfg.Metadata)
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+
+	body := bytes.Buffer{}
+	writer := multipart.NewWriter(&body)
+
+	metadataHeader := textproto.MIMEHeader{}
+	metadataHeader.Set("Content-Type", "application/json; charset=UTF-8")
+	part, err := writer.CreatePart(metadataHeader)
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+	_, err = part.Write(metadata)
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+
+	mediaHeader := textproto.MIMEHeader{}
+	mediaHeader.Set("Content-Type", mediaContentType(cfg))
+	part, err = writer.CreatePart(mediaHeader)
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+	_, err = io.Copy(part, cfg.Media)
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+
+	return service.upload(ctx, cfg, "multipart", "multipart/related; boundary="+writer.Boundary(), body.Bytes())
+}
+
+func (service *Service) upload(ctx context.Context, cfg *MediaUploadConfig, uploadType string, contentType string, body []byte) (*http.Response, *errortools.Error) {
+	method := cfg.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	parameters := url.Values{}
+	if cfg.Parameters != nil {
+		parameters = cloneValues(*cfg.Parameters)
+	}
+	parameters.Set("uploadType", uploadType)
+
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+
+	requestConfig := go_http.RequestConfig{
+		Method:            method,
+		Url:               cfg.Url,
+		Parameters:        &parameters,
+		BodyRaw:           &body,
+		ResponseModel:     cfg.ResponseModel,
+		NonDefaultHeaders: &header,
+	}
+
+	_, response, e := service.HttpRequestContext(ctx, &requestConfig)
+
+	return response, e
+}
+
+func validateMediaUploadConfig(cfg *MediaUploadConfig) *errortools.Error {
+	if cfg == nil {
+		return errortools.ErrorMessage("MediaUploadConfig must not be a nil pointer")
+	}
+
+	if cfg.Url == "" {
+		return errortools.ErrorMessage("Url not provided")
+	}
+
+	if cfg.Media == nil {
+		return errortools.ErrorMessage("Media not provided")
+	}
+
+	return nil
+}
+
+func mediaContentType(cfg *MediaUploadConfig) string {
+	if cfg.ContentType == "" {
+		return defaultMediaContentType
+	}
+
+	return cfg.ContentType
+}
@@ -0,0 +1,72 @@
+package google
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	go_token "github.com/leapforce-libraries/go_oauth2/token"
+)
+
+const defaultMetadataServiceAccount string = "default"
+
+// MetadataTokenSource retrieves access tokens of the attached service account from the metadata server
+type MetadataTokenSource struct {
+	tokenCache
+	metadataHost   string
+	serviceAccount string
+	scopes         []string
+	httpClient     *http.Client
+}
+
+type MetadataTokenSourceConfig struct {
+	MetadataHost   string
+	ServiceAccount *string
+	Scopes         []string
+	HttpClient     *http.Client
+}
+
+func NewMetadataTokenSource(cfg *MetadataTokenSourceConfig) (*MetadataTokenSource, *errortools.Error) {
+	if cfg == nil {
+		return nil, errortools.ErrorMessage("MetadataTokenSourceConfig must not be a nil pointer")
+	}
+
+	if cfg.MetadataHost == "" {
+		return nil, errortools.ErrorMessage("MetadataHost not provided")
+	}
+
+	serviceAccount := defaultMetadataServiceAccount
+	if cfg.ServiceAccount != nil {
+		serviceAccount = *cfg.ServiceAccount
+	}
+
+	return &MetadataTokenSource{
+		metadataHost:   cfg.MetadataHost,
+		serviceAccount: serviceAccount,
+		scopes:         cfg.Scopes,
+		httpClient:     cfg.HttpClient,
+	}, nil
+}
+
+// NewToken requests an access token from the metadata server
+func (t *MetadataTokenSource) NewToken() (*go_token.Token, *errortools.Error) {
+	tokenUrl := fmt.Sprintf("http://%s/computeMetadata/v1/instance/service-accounts/%s/token", t.metadataHost, t.serviceAccount)
+	if len(t.scopes) > 0 {
+		tokenUrl = fmt.Sprintf("%s?%s", tokenUrl, url.Values{"scopes": {strings.Join(t.scopes, ",")}}.Encode())
+	}
+
+	request, err := http.NewRequest(http.MethodGet, tokenUrl, nil)
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+	request.Header.Set("Metadata-Flavor", "Google")
+
+	b, e := doTokenRequest(t.httpClient, request)
+	if e != nil {
+		return nil, e
+	}
+
+	return t.UnmarshalToken(b)
+}
@@ -0,0 +1,162 @@
+package google
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// RequestMetric describes one request of a Service, including its retries
+type RequestMetric struct {
+	ApiName     string
+	Method      string
+	UrlTemplate string // path of the url with ids replaced by {id}, see UrlTemplate
+	StatusCode  int    // zero if no response was received
+	Reason      string // reason of the GoogleError, if any
+	Latency     time.Duration
+	Retries     int
+}
+
+// MetricsCollector receives a RequestMetric for every request of the Services it is set on,
+// implementations must be safe for concurrent use
+type MetricsCollector interface {
+	ObserveRequest(metric *RequestMetric)
+}
+
+// SetMetricsCollector makes the Service report its requests to metricsCollector, nil disables reporting.
+// Set it before the Service is used by multiple goroutines.
+func (service *Service) SetMetricsCollector(metricsCollector MetricsCollector) {
+	service.metricsCollector = metricsCollector
+}
+
+func (service *Service) observeRequest(method string, _url string, response *http.Response, googleError *GoogleError, latency time.Duration, attempts int) {
+	if service.metricsCollector == nil {
+		return
+	}
+
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	metric := RequestMetric{
+		ApiName:     service.apiName,
+		Method:      method,
+		UrlTemplate: UrlTemplate(_url),
+		Latency:     latency,
+	}
+	if response != nil {
+		metric.StatusCode = response.StatusCode
+	}
+	if googleError != nil {
+		metric.Reason = googleError.Reason
+	}
+	if attempts > 1 {
+		metric.Retries = attempts - 1
+	}
+
+	service.metricsCollector.ObserveRequest(&metric)
+}
+
+var (
+	versionSegment = regexp.MustCompile(`^v\d+(\.\d+)?([a-z]+\d*)?$`)
+	// ids contain digits or punctuation, or capitals where collections and verbs are lowerCamelCase
+	idSegment = regexp.MustCompile(`[0-9\-_.@~%=+]|^[A-Z]|[A-Z]{2}`)
+	// methods without colon are lowerCamelCase, e.g. files/generateIds
+	methodSegment = regexp.MustCompile(`^[a-z]+[A-Z][A-Za-z]*$`)
+)
+
+// collectionSegments are collections of Google APIs, the segment after them is an id even if it is all lowercase,
+// e.g. datasets/sales
+var collectionSegments = map[string]bool{
+	"accounts":        true,
+	"acl":             true,
+	"b":               true,
+	"buckets":         true,
+	"calendars":       true,
+	"channels":        true,
+	"comments":        true,
+	"customers":       true,
+	"databases":       true,
+	"datasets":        true,
+	"disks":           true,
+	"documents":       true,
+	"drafts":          true,
+	"drives":          true,
+	"events":          true,
+	"files":           true,
+	"filters":         true,
+	"folders":         true,
+	"groups":          true,
+	"instances":       true,
+	"jobs":            true,
+	"keys":            true,
+	"labels":          true,
+	"locations":       true,
+	"members":         true,
+	"messages":        true,
+	"models":          true,
+	"o":               true,
+	"objects":         true,
+	"operations":      true,
+	"organizations":   true,
+	"permissions":     true,
+	"playlists":       true,
+	"presentations":   true,
+	"processors":      true,
+	"projects":        true,
+	"properties":      true,
+	"regions":         true,
+	"replies":         true,
+	"revisions":       true,
+	"routines":        true,
+	"secrets":         true,
+	"serviceAccounts": true,
+	"sheets":          true,
+	"sites":           true,
+	"snapshots":       true,
+	"spreadsheets":    true,
+	"subscriptions":   true,
+	"tables":          true,
+	"threads":         true,
+	"topics":          true,
+	"users":           true,
+	"versions":        true,
+	"videos":          true,
+	"zones":           true,
+}
+
+// UrlTemplate returns the path of url with its ids replaced by {id}, so requests to the same endpoint share metrics.
+// The path up to the version is kept, after it segments that look like ids or follow a known collection are replaced,
+// e.g. /gmail/v1/users/{id}/messages/{id} and /compute/v1/projects/{id}/aggregated/instances.
+// Custom methods are kept, e.g. /v1/projects/{id}/serviceAccounts/{id}:generateAccessToken.
+func UrlTemplate(_url string) string {
+	path := _url
+	if u, err := url.Parse(_url); err == nil {
+		path = u.EscapedPath()
+	}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	first := 0
+	for i, segment := range segments {
+		if versionSegment.MatchString(segment) {
+			first = i + 1
+			break
+		}
+	}
+
+	for i := first; i < len(segments); i++ {
+		id, verb := segments[i], ""
+		if j := strings.LastIndex(id, ":"); j > 0 {
+			id, verb = id[:j], id[j:]
+		}
+
+		if idSegment.MatchString(id) || (i > first && collectionSegments[segments[i-1]] && !methodSegment.MatchString(id)) {
+			segments[i] = "{id}" + verb
+		}
+	}
+
+	return "/" + strings.Join(segments, "/")
+}
@@ -0,0 +1,41 @@
+package google
+
+import "testing"
+
+func TestUrlTemplate(t *testing.T) {
+	tests := []struct {
+		url      string
+		template string
+	}{
+		{"https://gmail.googleapis.com/gmail/v1/users/me/messages/18c2f0a1b2c3d4e5", "/gmail/v1/users/{id}/messages/{id}"},
+		{"https://gmail.googleapis.com/gmail/v1/users/me/settings/filters/ANe1Bmj", "/gmail/v1/users/{id}/settings/filters/{id}"},
+		{"https://gmail.googleapis.com/gmail/v1/users/me/settings/imap", "/gmail/v1/users/{id}/settings/imap"},
+		{"https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/sa@project.iam.gserviceaccount.com:generateAccessToken", "/v1/projects/{id}/serviceAccounts/{id}:generateAccessToken"},
+		{"https://pubsub.googleapis.com/v1/projects/my-project/topics:list", "/v1/projects/{id}/topics:list"},
+		{"https://translation.googleapis.com/v3/projects/my-project/locations/global:translateText", "/v3/projects/{id}/locations/{id}:translateText"},
+		{"https://documentai.googleapis.com/v1/projects/123/locations/us/processors/a1b2c3:process", "/v1/projects/{id}/locations/{id}/processors/{id}:process"},
+		{"https://compute.googleapis.com/compute/v1/projects/my-project/aggregated/instances", "/compute/v1/projects/{id}/aggregated/instances"},
+		{"https://compute.googleapis.com/compute/v1/projects/my-project/zones/europe-west4-a/instances/vm-1/start", "/compute/v1/projects/{id}/zones/{id}/instances/{id}/start"},
+		{"https://storage.googleapis.com/storage/v1/b/my-bucket/o/folder%2Ffile.txt", "/storage/v1/b/{id}/o/{id}"},
+		{"https://www.googleapis.com/drive/v3/files/1AbCdEfGhIjKlMnOpQrStUvWxYz/permissions", "/drive/v3/files/{id}/permissions"},
+		{"https://www.googleapis.com/drive/v3/files/generateIds", "/drive/v3/files/generateIds"},
+		{"https://bigquery.googleapis.com/bigquery/v2/projects/my_project/datasets/sales/tables/orders_2024/insertAll", "/bigquery/v2/projects/{id}/datasets/{id}/tables/{id}/insertAll"},
+		{"https://bigquery.googleapis.com/bigquery/v2/projects/myproject/datasets/sales/tables", "/bigquery/v2/projects/{id}/datasets/{id}/tables"},
+		{"https://pubsub.googleapis.com/v1/projects/myproject/topics/orders:publish", "/v1/projects/{id}/topics/{id}:publish"},
+		{"https://www.googleapis.com/calendar/v3/calendars/primary/events", "/calendar/v3/calendars/{id}/events"},
+		{"https://www.googleapis.com/drive/v3/files/abc/revisions/head", "/drive/v3/files/{id}/revisions/{id}"},
+		{"https://analyticsdata.googleapis.com/v1beta/properties/123456:runReport", "/v1beta/properties/{id}:runReport"},
+		{"https://example.googleapis.com/v1/documents:batchGet?ids=1&ids=2", "/v1/documents:batchGet"},
+		{"https://example.googleapis.com/v1/things/ABCDEF", "/v1/things/{id}"},
+		{"https://example.googleapis.com/v1/things/Abcdef", "/v1/things/{id}"},
+		{"https://example.googleapis.com/upload/v2/things/42", "/upload/v2/things/{id}"},
+		{"https://example.googleapis.com/things/42/parts", "/things/{id}/parts"},
+		{"https://example.googleapis.com/", "/"},
+	}
+
+	for _, test := range tests {
+		if template := UrlTemplate(test.url); template != test.template {
+			t.Errorf("UrlTemplate(%q) is %q, expected %q", test.url, template, test.template)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	go_http "github.com/leapforce-libraries/go_http"
+)
+
+const (
+	defaultOperationInitialInterval time.Duration = 1 * time.Second
+	defaultOperationMaxInterval     time.Duration = 30 * time.Second
+	defaultOperationMultiplier      float64       = 1.5
+)
+
+// Operation is a google.longrunning.Operation
+type Operation struct {
+	Name     string          `json:"name"`
+	Done     bool            `json:"done"`
+	Error    *OperationError `json:"error"`
+	Metadata json.RawMessage `json:"metadata"`
+	Response json.RawMessage `json:"response"`
+}
+
+// OperationError is the google.rpc.Status of a failed Operation, Code is a canonical (grpc) code, not an http code
+type OperationError struct {
+	Code    int           `json:"code"`
+	Message string        `json:"message"`
+	Details []ErrorDetail `json:"details"`
+}
+
+type WaitForOperationConfig struct {
+	BaseUrl         string // url the operation name is relative to, e.g. https://example.googleapis.com/v1/
+	Name            string // e.g. projects/p/locations/l/operations/o, or the complete url of the operation
+	ResponseModel   interface{}
+	MetadataModel   interface{}
+	InitialInterval *time.Duration             // defaults to 1s
+	MaxInterval     *time.Duration             // defaults to 30s
+	Multiplier      *float64                   // defaults to 1.5
+	OnProgress      func(operation *Operation) // called after every poll, the MetadataModel is then decoded
+}
+
+// WaitForOperation polls the operation until it is done. Its response is decoded into the ResponseModel,
+// a failed operation returns its error as GoogleError.
+func (service *Service) WaitForOperation(cfg *WaitForOperationConfig) (*Operation, *GoogleError, *errortools.Error) {
+	return service.WaitForOperationContext(context.Background(), cfg)
+}
+
+// WaitForOperationContext is WaitForOperation honoring the cancellation, deadline and values of ctx
+func (service *Service) WaitForOperationContext(ctx context.Context, cfg *WaitForOperationConfig) (*Operation, *GoogleError, *errortools.Error) {
+	if cfg == nil {
+		return nil, nil, errortools.ErrorMessage("WaitForOperationConfig must not be a nil pointer")
+	}
+
+	if cfg.Name == "" {
+		return nil, nil, errortools.ErrorMessage("Name not provided")
+	}
+
+	_url := cfg.Name
+	if !strings.HasPrefix(_url, "https://") && !strings.HasPrefix(_url, "http://") {
+		if cfg.BaseUrl == "" {
+			return nil, nil, errortools.ErrorMessage("BaseUrl not provided")
+		}
+		_url = strings.TrimSuffix(cfg.BaseUrl, "/") + "/" + strings.TrimPrefix(cfg.Name, "/")
+	}
+
+	interval := defaultOperationInitialInterval
+	if cfg.InitialInterval != nil {
+		interval = *cfg.InitialInterval
+	}
+	maxInterval := defaultOperationMaxInterval
+	if cfg.MaxInterval != nil {
+		maxInterval = *cfg.MaxInterval
+	}
+	multiplier := defaultOperationMultiplier
+	if cfg.Multiplier != nil {
+		multiplier = *cfg.Multiplier
+	}
+
+	for {
+		operation := Operation{}
+
+		requestConfig := go_http.RequestConfig{
+			Method:        http.MethodGet,
+			Url:           _url,
+			ResponseModel: &operation,
+		}
+		_, _, googleError, e := service.HttpRequestWithGoogleErrorContext(ctx, &requestConfig)
+		if e != nil {
+			return nil, googleError, e
+		}
+
+		if cfg.MetadataModel != nil && len(operation.Metadata) > 0 {
+			err := json.Unmarshal(operation.Metadata, cfg.MetadataModel)
+			if err != nil {
+				return &operation, nil, errortools.ErrorMessage(err)
+			}
+		}
+
+		if cfg.OnProgress != nil {
+			cfg.OnProgress(&operation)
+		}
+
+		if operation.Done {
+			return operationResult(&operation, cfg.ResponseModel)
+		}
+
+		select {
+		case <-ctx.Done():
+			return &operation, nil, errortools.ErrorMessage(ctx.Err())
+		case <-time.After(interval):
+		}
+
+		interval = time.Duration(float64(interval) * multiplier)
+		if interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+// operationResult decodes the response of a done operation or converts its error
+func operationResult(operation *Operation, responseModel interface{}) (*Operation, *GoogleError, *errortools.Error) {
+	if operation.Error != nil {
+		errorResponse := operation.Error.errorResponse()
+		googleError := NewGoogleError(errorResponse, nil)
+
+		e := errortools.ErrorMessagef("Operation %s failed", operation.Name)
+		setGoogleError(e, errorResponse, googleError)
+
+		return operation, googleError, e
+	}
+
+	if responseModel != nil && len(operation.Response) > 0 {
+		err := json.Unmarshal(operation.Response, responseModel)
+		if err != nil {
+			return operation, nil, errortools.ErrorMessage(err)
+		}
+	}
+
+	return operation, nil, nil
+}
+
+// errorResponse converts the google.rpc.Status into the layout of an http error response
+func (operationError *OperationError) errorResponse() *ErrorResponse {
+	errorResponse := ErrorResponse{}
+	errorResponse.Error.Message = operationError.Message
+	errorResponse.Error.Details = operationError.Details
+
+	if operationError.Code > 0 && operationError.Code < len(canonicalCodes) {
+		errorResponse.Error.Status = canonicalCodes[operationError.Code].status
+		errorResponse.Error.Code = canonicalCodes[operationError.Code].httpCode
+	} else {
+		errorResponse.Error.Status = StatusUnknown
+		errorResponse.Error.Code = http.StatusInternalServerError
+	}
+
+	return &errorResponse
+}
+
+// canonicalCodes maps google.rpc.Code onto its status and http code, see https://cloud.google.com/apis/design/errors#handling_errors
+var canonicalCodes = []struct {
+	status   string
+	httpCode int
+}{
+	{"OK", http.StatusOK},
+	{StatusCancelled, 499},
+	{StatusUnknown, http.StatusInternalServerError},
+	{StatusInvalidArgument, http.StatusBadRequest},
+	{StatusDeadlineExceeded, http.StatusGatewayTimeout},
+	{StatusNotFound, http.StatusNotFound},
+	{StatusAlreadyExists, http.StatusConflict},
+	{StatusPermissionDenied, http.StatusForbidden},
+	{StatusResourceExhausted, http.StatusTooManyRequests},
+	{StatusFailedPrecondition, http.StatusBadRequest},
+	{StatusAborted, http.StatusConflict},
+	{StatusOutOfRange, http.StatusBadRequest},
+	{StatusUnimplemented, http.StatusNotImplemented},
+	{StatusInternal, http.StatusInternalServerError},
+	{StatusUnavailable, http.StatusServiceUnavailable},
+	{StatusDataLoss, http.StatusInternalServerError},
+	{StatusUnauthenticated, http.StatusUnauthorized},
+}
@@ -0,0 +1,208 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	go_http "github.com/leapforce-libraries/go_http"
+)
+
+const defaultPageTokenParameter string = "pageToken"
+
+// DecodePageFunc extracts the items and the nextPageToken from the response body of a list call
+type DecodePageFunc[T any] func(b json.RawMessage) ([]T, string, *errortools.Error)
+
+type PaginatorConfig[T any] struct {
+	Service            *Service
+	RequestConfig      *go_http.RequestConfig // request of the first page, left untouched
+	DecodePage         DecodePageFunc[T]      // defaults to DecodeItems[T]("items")
+	PageToken          string                 // resume from a token saved with PageToken()
+	PageTokenParameter *string                // defaults to "pageToken"
+	MaxPages           int                    // zero means no limit
+	MaxItems           int                    // zero means no limit
+}
+
+// Paginator lazily fetches the pages of a list call and returns their items one by one
+type Paginator[T any] struct {
+	service            *Service
+	requestConfig      *go_http.RequestConfig
+	decodePage         DecodePageFunc[T]
+	pageTokenParameter string
+	maxPages           int
+	maxItems           int
+	pageToken          string // token of the page in items
+	nextPageToken      string
+	items              []T
+	pages              int
+	itemCount          int
+	done               bool
+}
+
+func NewPaginator[T any](cfg *PaginatorConfig[T]) (*Paginator[T], *errortools.Error) {
+	if cfg == nil {
+		return nil, errortools.ErrorMessage("PaginatorConfig must not be a nil pointer")
+	}
+
+	if cfg.Service == nil {
+		return nil, errortools.ErrorMessage("Service not provided")
+	}
+
+	if cfg.RequestConfig == nil {
+		return nil, errortools.ErrorMessage("RequestConfig not provided")
+	}
+
+	decodePage := cfg.DecodePage
+	if decodePage == nil {
+		decodePage = DecodeItems[T]("items")
+	}
+
+	pageTokenParameter := defaultPageTokenParameter
+	if cfg.PageTokenParameter != nil {
+		pageTokenParameter = *cfg.PageTokenParameter
+	}
+
+	return &Paginator[T]{
+		service:            cfg.Service,
+		requestConfig:      cfg.RequestConfig,
+		decodePage:         decodePage,
+		pageTokenParameter: pageTokenParameter,
+		maxPages:           cfg.MaxPages,
+		maxItems:           cfg.MaxItems,
+		pageToken:          cfg.PageToken,
+		nextPageToken:      cfg.PageToken,
+	}, nil
+}
+
+// DecodeItems decodes pages shaped like {"<itemsField>": [...], "nextPageToken": "..."}
+func DecodeItems[T any](itemsField string) DecodePageFunc[T] {
+	return func(b json.RawMessage) ([]T, string, *errortools.Error) {
+		page := make(map[string]json.RawMessage)
+		err := json.Unmarshal(b, &page)
+		if err != nil {
+			return nil, "", errortools.ErrorMessage(err)
+		}
+
+		var items []T
+		if raw, ok := page[itemsField]; ok {
+			err = json.Unmarshal(raw, &items)
+			if err != nil {
+				return nil, "", errortools.ErrorMessage(err)
+			}
+		}
+
+		var nextPageToken string
+		if raw, ok := page["nextPageToken"]; ok {
+			err = json.Unmarshal(raw, &nextPageToken)
+			if err != nil {
+				return nil, "", errortools.ErrorMessage(err)
+			}
+		}
+
+		return items, nextPageToken, nil
+	}
+}
+
+// Next returns the next item, fetching the next page when needed.
+// The bool is false when all items (or MaxPages/MaxItems) have been returned.
+func (paginator *Paginator[T]) Next(ctx context.Context) (T, bool, *errortools.Error) {
+	var item T
+
+	if paginator.maxItems > 0 && paginator.itemCount >= paginator.maxItems {
+		return item, false, nil
+	}
+
+	for len(paginator.items) == 0 {
+		if paginator.done {
+			return item, false, nil
+		}
+
+		e := paginator.fetch(ctx)
+		if e != nil {
+			return item, false, e
+		}
+	}
+
+	item = paginator.items[0]
+	paginator.items = paginator.items[1:]
+	paginator.itemCount++
+
+	return item, true, nil
+}
+
+// All returns all remaining items
+func (paginator *Paginator[T]) All(ctx context.Context) ([]T, *errortools.Error) {
+	items := []T{}
+
+	for {
+		item, ok, e := paginator.Next(ctx)
+		if e != nil {
+			return items, e
+		}
+		if !ok {
+			return items, nil
+		}
+
+		items = append(items, item)
+	}
+}
+
+// PageToken returns the token to resume from with PaginatorConfig.PageToken.
+// Items of a partially returned page are returned again after resuming.
+func (paginator *Paginator[T]) PageToken() string {
+	if len(paginator.items) == 0 {
+		return paginator.nextPageToken
+	}
+
+	return paginator.pageToken
+}
+
+// Pages returns the number of pages fetched so far
+func (paginator *Paginator[T]) Pages() int {
+	return paginator.pages
+}
+
+func (paginator *Paginator[T]) fetch(ctx context.Context) *errortools.Error {
+	if paginator.maxPages > 0 && paginator.pages >= paginator.maxPages {
+		paginator.done = true
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return errortools.ErrorMessage(err)
+	}
+
+	requestConfig := *paginator.requestConfig
+	parameters := url.Values{}
+	if paginator.requestConfig.Parameters != nil {
+		parameters = cloneValues(*paginator.requestConfig.Parameters)
+	}
+	if paginator.nextPageToken != "" {
+		parameters.Set(paginator.pageTokenParameter, paginator.nextPageToken)
+	}
+	if len(parameters) > 0 {
+		requestConfig.Parameters = &parameters
+	}
+
+	raw := json.RawMessage{}
+	requestConfig.ResponseModel = &raw
+
+	_, _, _, e := paginator.service.HttpRequestWithGoogleErrorContext(ctx, &requestConfig)
+	if e != nil {
+		return e
+	}
+
+	items, nextPageToken, e := paginator.decodePage(raw)
+	if e != nil {
+		return e
+	}
+
+	paginator.pages++
+	paginator.pageToken = paginator.nextPageToken
+	paginator.nextPageToken = nextPageToken
+	paginator.items = items
+	paginator.done = nextPageToken == ""
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	testserver "github.com/leapforce-libraries/go_google/internal/testserver"
+	go_http "github.com/leapforce-libraries/go_http"
+)
+
+// testPages are the pages of the fake list call by page token, the last page is empty
+var testPages = map[string]struct {
+	items         []int
+	nextPageToken string
+}{
+	"":   {[]int{1, 2, 3}, "p2"},
+	"p2": {[]int{4, 5, 6}, "p3"},
+	"p3": {[]int{}, ""},
+}
+
+// newPagedServer serves testPages on /v1/things
+func newPagedServer(t *testing.T) *testserver.Server {
+	return testserver.New(t, map[string]http.HandlerFunc{
+		"/v1/things": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("filter") != "active" {
+				t.Errorf("parameter filter of the RequestConfig is %q", r.URL.Query().Get("filter"))
+			}
+
+			page, ok := testPages[r.URL.Query().Get("pageToken")]
+			if !ok {
+				testserver.WriteJson(w, http.StatusBadRequest, `{"error":{"code":400,"message":"Invalid page token","status":"INVALID_ARGUMENT"}}`)
+				return
+			}
+
+			testserver.WriteJson(w, http.StatusOK, fmt.Sprintf(`{"items":%s,"nextPageToken":%q}`, jsonInts(page.items), page.nextPageToken))
+		},
+	})
+}
+
+// requestedPageTokens returns the page token of every request
+func requestedPageTokens(server *testserver.Server) []string {
+	pageTokens := []string{}
+	for _, request := range server.Requests("/v1/things") {
+		pageTokens = append(pageTokens, request.Query.Get("pageToken"))
+	}
+
+	return pageTokens
+}
+
+func jsonInts(ints []int) string {
+	s := "["
+	for i, n := range ints {
+		if i > 0 {
+			s += ","
+		}
+		s += fmt.Sprint(n)
+	}
+	return s + "]"
+}
+
+func newTestPaginator(t *testing.T, server *testserver.Server, cfg PaginatorConfig[int]) *Paginator[int] {
+	service, e := NewServiceWithAccessToken(&ServiceWithAccessTokenConfig{AccessToken: "access-token"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	cfg.Service = service
+	cfg.RequestConfig = &go_http.RequestConfig{
+		Url:        server.URL + "/v1/things",
+		Parameters: &url.Values{"filter": {"active"}},
+	}
+
+	paginator, e := NewPaginator(&cfg)
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	return paginator
+}
+
+func TestPaginator(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       PaginatorConfig[int]
+		items     []int
+		requests  []string
+		pageToken string
+	}{
+		{"all pages", PaginatorConfig[int]{}, []int{1, 2, 3, 4, 5, 6}, []string{"", "p2", "p3"}, ""},
+		{"max pages", PaginatorConfig[int]{MaxPages: 1}, []int{1, 2, 3}, []string{""}, "p2"},
+		{"max pages beyond the last page", PaginatorConfig[int]{MaxPages: 5}, []int{1, 2, 3, 4, 5, 6}, []string{"", "p2", "p3"}, ""},
+		{"max items within a page", PaginatorConfig[int]{MaxItems: 4}, []int{1, 2, 3, 4}, []string{"", "p2"}, "p2"},
+		{"max items at the end of a page", PaginatorConfig[int]{MaxItems: 3}, []int{1, 2, 3}, []string{""}, "p2"},
+		{"max pages and max items", PaginatorConfig[int]{MaxPages: 1, MaxItems: 5}, []int{1, 2, 3}, []string{""}, "p2"},
+		{"resume", PaginatorConfig[int]{PageToken: "p2"}, []int{4, 5, 6}, []string{"p2", "p3"}, ""},
+		{"resume at the empty last page", PaginatorConfig[int]{PageToken: "p3"}, []int{}, []string{"p3"}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newPagedServer(t)
+
+			paginator := newTestPaginator(t, server, test.cfg)
+
+			items, e := paginator.All(context.Background())
+			if e != nil {
+				t.Fatal(e.Message())
+			}
+
+			if !reflect.DeepEqual(items, test.items) {
+				t.Errorf("items are %v, expected %v", items, test.items)
+			}
+			if !reflect.DeepEqual(requestedPageTokens(server), test.requests) {
+				t.Errorf("page tokens requested are %q, expected %q", requestedPageTokens(server), test.requests)
+			}
+			if paginator.Pages() != len(test.requests) {
+				t.Errorf("Pages is %v, expected %v", paginator.Pages(), len(test.requests))
+			}
+			if paginator.PageToken() != test.pageToken {
+				t.Errorf("PageToken is %q, expected %q", paginator.PageToken(), test.pageToken)
+			}
+
+			// a finished paginator does not request again
+			_, ok, e := paginator.Next(context.Background())
+			if ok || e != nil || len(requestedPageTokens(server)) != len(test.requests) {
+				t.Errorf("Next after the last item returned %v, %v after %v requests", ok, e, len(requestedPageTokens(server)))
+			}
+		})
+	}
+}
+
+func TestPaginatorResumeFromSavedPageToken(t *testing.T) {
+	server := newPagedServer(t)
+
+	paginator := newTestPaginator(t, server, PaginatorConfig[int]{MaxItems: 4})
+	items, e := paginator.All(context.Background())
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	// the partially returned page is returned again after resuming
+	resumed := newTestPaginator(t, server, PaginatorConfig[int]{PageToken: paginator.PageToken()})
+	remaining, e := resumed.All(context.Background())
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	if !reflect.DeepEqual(items, []int{1, 2, 3, 4}) || !reflect.DeepEqual(remaining, []int{4, 5, 6}) {
+		t.Errorf("items are %v, after resuming %v", items, remaining)
+	}
+}
+
+func TestPaginatorCancelBetweenPages(t *testing.T) {
+	server := newPagedServer(t)
+
+	paginator := newTestPaginator(t, server, PaginatorConfig[int]{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 1; i <= 3; i++ {
+		item, ok, e := paginator.Next(ctx)
+		if e != nil || !ok || item != i {
+			t.Fatalf("Next returned %v, %v, %v", item, ok, e)
+		}
+	}
+
+	cancel()
+
+	_, ok, e := paginator.Next(ctx)
+	if e == nil || ok {
+		t.Fatalf("Next after cancel returned %v, %v", ok, e)
+	}
+	if len(requestedPageTokens(server)) != 1 {
+		t.Errorf("%v pages requested, expected the second page not to be requested", len(requestedPageTokens(server)))
+	}
+
+	// the page token still points at the page that was not fetched
+	if paginator.PageToken() != "p2" {
+		t.Errorf("PageToken is %q, expected p2", paginator.PageToken())
+	}
+}
+
+func TestPaginatorError(t *testing.T) {
+	server := newPagedServer(t)
+
+	paginator := newTestPaginator(t, server, PaginatorConfig[int]{PageToken: "invalid"})
+
+	items, e := paginator.All(context.Background())
+	if e == nil || len(items) != 0 {
+		t.Fatalf("All returned %v, %v", items, e)
+	}
+	if paginator.PageToken() != "invalid" {
+		t.Errorf("PageToken is %q, expected the token of the failed page", paginator.PageToken())
+	}
+}
@@ -0,0 +1,190 @@
+package google
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+)
+
+const (
+	codeChallengeMethod          string        = "S256"
+	codeVerifierByteCount        int           = 32 // 43 characters after encoding
+	defaultCodeVerifierStoreTtl  time.Duration = 10 * time.Minute
+	codeChallengeParameter       string        = "code_challenge"
+	codeChallengeMethodParameter string        = "code_challenge_method"
+	codeVerifierParameter        string        = "code_verifier"
+)
+
+// CodeVerifierStore keeps the PKCE code verifiers between the authorize redirect and the code exchange, keyed by state.
+// Use a shared implementation if the redirect can arrive at another instance than the one that created the url.
+type CodeVerifierStore interface {
+	Set(state string, codeVerifier string) *errortools.Error
+	Pop(state string) (string, bool, *errortools.Error) // returns and removes the code verifier of state
+}
+
+// AuthorizeUrlWithPkce returns the authorize url with a S256 code challenge, its code verifier is stored under state
+// and sent by GetTokenFromCode when the redirect with the same state arrives
+func (service *Service) AuthorizeUrlWithPkce(scope string, accessType *string, prompt *string, state string) (string, *errortools.Error) {
+	if service.authorizationMode != authorizationModeOAuth2 {
+		return "", errortools.ErrorMessage("AuthorizeUrlWithPkce requires an oauth2 Service")
+	}
+
+	if state == "" {
+		return "", errortools.ErrorMessage("State not provided")
+	}
+
+	codeVerifier, e := newCodeVerifier()
+	if e != nil {
+		return "", e
+	}
+
+	e = service.pkceStore().Set(state, codeVerifier)
+	if e != nil {
+		return "", e
+	}
+
+	authorizeUrl, err := url.Parse(service.AuthorizeUrl(scope, accessType, prompt, &state))
+	if err != nil {
+		return "", errortools.ErrorMessage(err)
+	}
+
+	query := authorizeUrl.Query()
+	query.Set(codeChallengeParameter, codeChallenge(codeVerifier))
+	query.Set(codeChallengeMethodParameter, codeChallengeMethod)
+	authorizeUrl.RawQuery = query.Encode()
+
+	return authorizeUrl.String(), nil
+}
+
+// pkceStore returns the CodeVerifierStore of the Service, a MemoryCodeVerifierStore is created if it has none
+func (service *Service) pkceStore() CodeVerifierStore {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	if service.codeVerifierStore == nil {
+		service.codeVerifierStore = NewMemoryCodeVerifierStore(nil)
+	}
+
+	return service.codeVerifierStore
+}
+
+// codeVerifierData returns the code verifier stored for the state of the redirect r, nil if the Service does not use PKCE.
+// A redirect without stored code verifier is an error, it is not exchanged without PKCE.
+func (service *Service) codeVerifierData(r *http.Request) (*url.Values, *errortools.Error) {
+	service.mutex.Lock()
+	codeVerifierStore := service.codeVerifierStore
+	service.mutex.Unlock()
+
+	if codeVerifierStore == nil {
+		return nil, nil
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+
+	state := r.FormValue("state")
+	if state == "" {
+		return nil, errortools.ErrorMessage("State not provided, it is required to find the PKCE verifier")
+	}
+
+	codeVerifier, ok, e := codeVerifierStore.Pop(state)
+	if e != nil {
+		return nil, e
+	}
+	if !ok {
+		return nil, errortools.ErrorMessage("PKCE verifier for state not found or expired")
+	}
+
+	return &url.Values{codeVerifierParameter: []string{codeVerifier}}, nil
+}
+
+func newCodeVerifier() (string, *errortools.Error) {
+	b := make([]byte, codeVerifierByteCount)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errortools.ErrorMessage(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func codeChallenge(codeVerifier string) string {
+	hash := sha256.Sum256([]byte(codeVerifier))
+
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// MemoryCodeVerifierStore keeps code verifiers in memory, they expire after Ttl
+type MemoryCodeVerifierStore struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	entries map[string]codeVerifierEntry
+}
+
+type codeVerifierEntry struct {
+	codeVerifier string
+	expires      time.Time
+}
+
+type MemoryCodeVerifierStoreConfig struct {
+	Ttl *time.Duration // defaults to 10 minutes
+}
+
+func NewMemoryCodeVerifierStore(cfg *MemoryCodeVerifierStoreConfig) *MemoryCodeVerifierStore {
+	ttl := defaultCodeVerifierStoreTtl
+	if cfg != nil && cfg.Ttl != nil {
+		ttl = *cfg.Ttl
+	}
+
+	return &MemoryCodeVerifierStore{
+		ttl:     ttl,
+		entries: make(map[string]codeVerifierEntry),
+	}
+}
+
+func (store *MemoryCodeVerifierStore) Set(state string, codeVerifier string) *errortools.Error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now()
+
+	// abandoned authorizations are removed here
+	for _state, entry := range store.entries {
+		if now.After(entry.expires) {
+			delete(store.entries, _state)
+		}
+	}
+
+	store.entries[state] = codeVerifierEntry{
+		codeVerifier: codeVerifier,
+		expires:      now.Add(store.ttl),
+	}
+
+	return nil
+}
+
+func (store *MemoryCodeVerifierStore) Pop(state string) (string, bool, *errortools.Error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	entry, ok := store.entries[state]
+	if !ok {
+		return "", false, nil
+	}
+	delete(store.entries, state)
+
+	if time.Now().After(entry.expires) {
+		return "", false, nil
+	}
+
+	return entry.codeVerifier, true, nil
+}
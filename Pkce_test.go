@@ -0,0 +1,175 @@
+package google
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestOAuth2Service(t *testing.T, codeVerifierStore CodeVerifierStore) *Service {
+	service, e := NewServiceWithOAuth2(&ServiceWithOAuth2Config{
+		ClientId:          "client-id.apps.googleusercontent.com",
+		ClientSecret:      "client-secret",
+		CodeVerifierStore: codeVerifierStore,
+	})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	return service
+}
+
+func TestAuthorizeUrlWithPkce(t *testing.T) {
+	store := NewMemoryCodeVerifierStore(nil)
+	service := newTestOAuth2Service(t, store)
+
+	accessType := "offline"
+	authorizeUrl, e := service.AuthorizeUrlWithPkce("https://www.googleapis.com/auth/drive", &accessType, nil, "state-1")
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	u, err := url.Parse(authorizeUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+
+	if !strings.HasPrefix(authorizeUrl, authUrl+"?") || query.Get("state") != "state-1" || query.Get("access_type") != "offline" || query.Get("client_id") != "client-id.apps.googleusercontent.com" {
+		t.Errorf("authorize url is %s", authorizeUrl)
+	}
+	if query.Get(codeChallengeMethodParameter) != "S256" {
+		t.Errorf("code_challenge_method is %q", query.Get(codeChallengeMethodParameter))
+	}
+
+	codeVerifier, ok, e := store.Pop("state-1")
+	if e != nil || !ok {
+		t.Fatalf("no code verifier stored for the state: %v", e)
+	}
+
+	// RFC 7636: 43 to 128 unreserved characters, the challenge is their base64url encoded SHA-256 without padding
+	if !regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`).MatchString(codeVerifier) {
+		t.Errorf("code verifier %q is invalid", codeVerifier)
+	}
+	hash := sha256.Sum256([]byte(codeVerifier))
+	if challenge := base64.RawURLEncoding.EncodeToString(hash[:]); query.Get(codeChallengeParameter) != challenge {
+		t.Errorf("code_challenge is %q, expected %q", query.Get(codeChallengeParameter), challenge)
+	}
+
+	// every url has its own code verifier
+	_, e = service.AuthorizeUrlWithPkce("https://www.googleapis.com/auth/drive", nil, nil, "state-2")
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	otherCodeVerifier, _, _ := store.Pop("state-2")
+	if otherCodeVerifier == codeVerifier || otherCodeVerifier == "" {
+		t.Errorf("code verifiers %q and %q", codeVerifier, otherCodeVerifier)
+	}
+
+	_, e = service.AuthorizeUrlWithPkce("https://www.googleapis.com/auth/drive", nil, nil, "")
+	if e == nil {
+		t.Error("expected an error without state")
+	}
+
+	apiKeyService, e := NewServiceWithApiKey(&ServiceWithApiKeyConfig{ApiKey: "api-key"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	_, e = apiKeyService.AuthorizeUrlWithPkce("https://www.googleapis.com/auth/drive", nil, nil, "state")
+	if e == nil {
+		t.Error("expected an error for a Service without oauth2")
+	}
+}
+
+func TestCodeVerifierData(t *testing.T) {
+	service := newTestOAuth2Service(t, nil)
+
+	// without AuthorizeUrlWithPkce the code is exchanged without PKCE
+	data, e := service.codeVerifierData(httptest.NewRequest("GET", "/oauth/redirect?state=state-1&code=code", nil))
+	if e != nil || data != nil {
+		t.Errorf("code verifier data without PKCE is %v, %v", data, e)
+	}
+
+	_, e = service.AuthorizeUrlWithPkce("https://www.googleapis.com/auth/drive", nil, nil, "state-1")
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	data, e = service.codeVerifierData(httptest.NewRequest("GET", "/oauth/redirect?state=state-1&code=code", nil))
+	if e != nil || data == nil || len(data.Get(codeVerifierParameter)) != 43 {
+		t.Fatalf("code verifier data is %v, %v", data, e)
+	}
+
+	// a code verifier is used once, unknown and missing states are not exchanged without PKCE
+	for _, query := range []string{"state=state-1&code=code", "state=unknown&code=code"} {
+		request := httptest.NewRequest("GET", "/oauth/redirect?"+query, nil)
+
+		_, e = service.codeVerifierData(request)
+		if e == nil || !strings.Contains(e.Message(), "PKCE verifier for state not found or expired") {
+			t.Errorf("error for %s is %v", query, e)
+		}
+
+		e = service.GetTokenFromCode(request)
+		if e == nil || !strings.Contains(e.Message(), "PKCE verifier for state not found or expired") {
+			t.Errorf("GetTokenFromCode for %s returned %v", query, e)
+		}
+	}
+
+	_, e = service.codeVerifierData(httptest.NewRequest("GET", "/oauth/redirect?code=code", nil))
+	if e == nil {
+		t.Error("expected an error without state")
+	}
+}
+
+func TestMemoryCodeVerifierStore(t *testing.T) {
+	ttl := 50 * time.Millisecond
+	store := NewMemoryCodeVerifierStore(&MemoryCodeVerifierStoreConfig{Ttl: &ttl})
+
+	store.Set("state-1", "verifier-1")
+	store.Set("state-2", "verifier-2")
+
+	codeVerifier, ok, e := store.Pop("state-1")
+	if e != nil || !ok || codeVerifier != "verifier-1" {
+		t.Errorf("Pop returned %q, %v, %v", codeVerifier, ok, e)
+	}
+
+	// popped verifiers are removed
+	_, ok, _ = store.Pop("state-1")
+	if ok {
+		t.Error("code verifier returned twice")
+	}
+
+	_, ok, _ = store.Pop("unknown")
+	if ok {
+		t.Error("code verifier returned for an unknown state")
+	}
+
+	time.Sleep(2 * ttl)
+
+	// expired verifiers are not returned, and removed when another is set
+	store.Set("state-3", "verifier-3")
+	if len(store.entries) != 1 {
+		t.Errorf("%v entries after expiry, expected 1", len(store.entries))
+	}
+
+	_, ok, _ = store.Pop("state-2")
+	if ok {
+		t.Error("expired code verifier returned")
+	}
+
+	store.Set("state-4", "verifier-4")
+	time.Sleep(2 * ttl)
+	_, ok, _ = store.Pop("state-4")
+	if ok {
+		t.Error("expired code verifier returned")
+	}
+
+	if NewMemoryCodeVerifierStore(nil).ttl != defaultCodeVerifierStoreTtl {
+		t.Errorf("default ttl is %v", NewMemoryCodeVerifierStore(nil).ttl)
+	}
+}
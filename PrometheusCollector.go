@@ -0,0 +1,202 @@
+package google
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultPrometheusNamespace string = "google_api"
+
+var defaultPrometheusBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// PrometheusCollector aggregates RequestMetrics and exposes them in the Prometheus text format,
+// it can be served directly as the /metrics handler
+type PrometheusCollector struct {
+	mutex     sync.Mutex
+	namespace string
+	buckets   []float64
+	requests  map[prometheusRequestLabels]uint64
+	retries   map[prometheusEndpointLabels]uint64
+	latencies map[prometheusEndpointLabels]*prometheusHistogram
+}
+
+type PrometheusCollectorConfig struct {
+	Namespace *string   // prefix of the metric names, defaults to google_api
+	Buckets   []float64 // upper bounds in seconds of the latency histogram
+}
+
+type prometheusEndpointLabels struct {
+	api    string
+	method string
+	url    string
+}
+
+type prometheusRequestLabels struct {
+	prometheusEndpointLabels
+	status string
+	reason string
+}
+
+type prometheusHistogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func NewPrometheusCollector(cfg *PrometheusCollectorConfig) *PrometheusCollector {
+	namespace := defaultPrometheusNamespace
+	buckets := defaultPrometheusBuckets
+
+	if cfg != nil {
+		if cfg.Namespace != nil {
+			namespace = *cfg.Namespace
+		}
+		if len(cfg.Buckets) > 0 {
+			buckets = append([]float64{}, cfg.Buckets...)
+			sort.Float64s(buckets)
+		}
+	}
+
+	return &PrometheusCollector{
+		namespace: namespace,
+		buckets:   buckets,
+		requests:  make(map[prometheusRequestLabels]uint64),
+		retries:   make(map[prometheusEndpointLabels]uint64),
+		latencies: make(map[prometheusEndpointLabels]*prometheusHistogram),
+	}
+}
+
+func (collector *PrometheusCollector) ObserveRequest(metric *RequestMetric) {
+	endpoint := prometheusEndpointLabels{
+		api:    metric.ApiName,
+		method: metric.Method,
+		url:    metric.UrlTemplate,
+	}
+
+	status := ""
+	if metric.StatusCode != 0 {
+		status = strconv.Itoa(metric.StatusCode)
+	}
+
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	collector.requests[prometheusRequestLabels{endpoint, status, metric.Reason}]++
+	collector.retries[endpoint] += uint64(metric.Retries)
+
+	histogram, ok := collector.latencies[endpoint]
+	if !ok {
+		histogram = &prometheusHistogram{counts: make([]uint64, len(collector.buckets))}
+		collector.latencies[endpoint] = histogram
+	}
+
+	seconds := metric.Latency.Seconds()
+	for i, bucket := range collector.buckets {
+		if seconds <= bucket {
+			histogram.counts[i]++
+			break
+		}
+	}
+	histogram.count++
+	histogram.sum += seconds
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (collector *PrometheusCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	collector.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (collector *PrometheusCollector) WriteTo(w io.Writer) (int64, error) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	writer := &countingWriter{writer: bufio.NewWriter(w)}
+
+	name := collector.namespace + "_requests_total"
+	fmt.Fprintf(writer, "# HELP %s Requests by endpoint, status code and error reason.\n# TYPE %s counter\n", name, name)
+	requestLabels := make([]prometheusRequestLabels, 0, len(collector.requests))
+	for labels := range collector.requests {
+		requestLabels = append(requestLabels, labels)
+	}
+	sort.Slice(requestLabels, func(i, j int) bool {
+		return requestLabels[i].String() < requestLabels[j].String()
+	})
+	for _, labels := range requestLabels {
+		fmt.Fprintf(writer, "%s{%s} %v\n", name, labels.String(), collector.requests[labels])
+	}
+
+	endpoints := make([]prometheusEndpointLabels, 0, len(collector.latencies))
+	for labels := range collector.latencies {
+		endpoints = append(endpoints, labels)
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].String() < endpoints[j].String()
+	})
+
+	name = collector.namespace + "_request_retries_total"
+	fmt.Fprintf(writer, "# HELP %s Retries by endpoint.\n# TYPE %s counter\n", name, name)
+	for _, labels := range endpoints {
+		fmt.Fprintf(writer, "%s{%s} %v\n", name, labels.String(), collector.retries[labels])
+	}
+
+	name = collector.namespace + "_request_duration_seconds"
+	fmt.Fprintf(writer, "# HELP %s Latency by endpoint, including retries.\n# TYPE %s histogram\n", name, name)
+	for _, labels := range endpoints {
+		histogram := collector.latencies[labels]
+
+		cumulative := uint64(0)
+		for i, bucket := range collector.buckets {
+			cumulative += histogram.counts[i]
+			fmt.Fprintf(writer, "%s_bucket{%s,le=\"%s\"} %v\n", name, labels.String(), strconv.FormatFloat(bucket, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(writer, "%s_bucket{%s,le=\"+Inf\"} %v\n", name, labels.String(), histogram.count)
+		fmt.Fprintf(writer, "%s_sum{%s} %v\n", name, labels.String(), strconv.FormatFloat(histogram.sum, 'g', -1, 64))
+		fmt.Fprintf(writer, "%s_count{%s} %v\n", name, labels.String(), histogram.count)
+	}
+
+	if writer.err != nil {
+		return writer.count, writer.err
+	}
+
+	return writer.count, writer.writer.Flush()
+}
+
+func (labels prometheusEndpointLabels) String() string {
+	return fmt.Sprintf(`api="%s",method="%s",url="%s"`, escapeLabel(labels.api), escapeLabel(labels.method), escapeLabel(labels.url))
+}
+
+func (labels prometheusRequestLabels) String() string {
+	return fmt.Sprintf(`%s,status="%s",reason="%s"`, labels.prometheusEndpointLabels.String(), escapeLabel(labels.status), escapeLabel(labels.reason))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+type countingWriter struct {
+	writer *bufio.Writer
+	count  int64
+	err    error
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	n, err := w.writer.Write(p)
+	w.count += int64(n)
+	w.err = err
+
+	return n, err
+}
@@ -0,0 +1,177 @@
+package google
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+)
+
+const (
+	defaultRateLimitPause time.Duration = 10 * time.Second
+	rateLimitMinFraction  float64       = 1.0 / 16
+	rateLimitRecovery     float64       = 0.05
+)
+
+// RateLimitConfig configures the token bucket of an apiName and quota bucket
+type RateLimitConfig struct {
+	RequestsPerMinute float64
+	Burst             int  // maximum number of requests sent at once, defaults to 1
+	FailFast          bool // return an error instead of waiting when the bucket is empty
+}
+
+// rateLimiter is a token bucket that slows down after RESOURCE_EXHAUSTED responses
+// and recovers gradually with every successful request
+type rateLimiter struct {
+	mutex       sync.Mutex
+	key         string
+	rate        float64 // tokens per second currently in use
+	maxRate     float64 // tokens per second as configured
+	capacity    float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+	failFast    bool
+}
+
+var (
+	rateLimiters      = make(map[string]*rateLimiter)
+	rateLimitersMutex sync.Mutex
+)
+
+// SetRateLimit limits the requests of all Services with apiName that use quota bucket, within this process.
+// An empty bucket applies to Services without a bucket of their own (see SetQuotaBucket). A nil cfg removes the limit.
+func SetRateLimit(apiName string, bucket string, cfg *RateLimitConfig) *errortools.Error {
+	key := rateLimitKey(apiName, bucket)
+
+	rateLimitersMutex.Lock()
+	defer rateLimitersMutex.Unlock()
+
+	if cfg == nil {
+		delete(rateLimiters, key)
+		return nil
+	}
+
+	if cfg.RequestsPerMinute <= 0 {
+		return errortools.ErrorMessage("RequestsPerMinute must be greater than zero")
+	}
+
+	burst := cfg.Burst
+	if burst < 1 {
+		burst = 1
+	}
+
+	rate := cfg.RequestsPerMinute / 60
+
+	rateLimiters[key] = &rateLimiter{
+		key:      key,
+		rate:     rate,
+		maxRate:  rate,
+		capacity: float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+		failFast: cfg.FailFast,
+	}
+
+	return nil
+}
+
+// findRateLimiter returns the limiter of apiName and bucket, falling back to the limiter of apiName without bucket
+func findRateLimiter(apiName string, bucket string) *rateLimiter {
+	rateLimitersMutex.Lock()
+	defer rateLimitersMutex.Unlock()
+
+	if rateLimiter, ok := rateLimiters[rateLimitKey(apiName, bucket)]; ok {
+		return rateLimiter
+	}
+
+	return rateLimiters[rateLimitKey(apiName, "")]
+}
+
+func rateLimitKey(apiName string, bucket string) string {
+	if bucket == "" {
+		return apiName
+	}
+
+	return apiName + "/" + bucket
+}
+
+// wait takes a token from the bucket, waiting until one is available unless the limiter fails fast
+func (limiter *rateLimiter) wait(ctx context.Context) *errortools.Error {
+	for {
+		limiter.mutex.Lock()
+		now := time.Now()
+		limiter.refill(now)
+
+		var delay time.Duration
+		if now.Before(limiter.pausedUntil) {
+			delay = limiter.pausedUntil.Sub(now)
+		} else if limiter.tokens >= 1 {
+			limiter.tokens--
+			limiter.mutex.Unlock()
+			return nil
+		} else {
+			delay = time.Duration((1 - limiter.tokens) / limiter.rate * float64(time.Second))
+		}
+		limiter.mutex.Unlock()
+
+		if limiter.failFast {
+			return errortools.ErrorMessagef("Rate limit of %s exceeded, retry in %v", limiter.key, delay.Round(time.Millisecond))
+		}
+
+		select {
+		case <-ctx.Done():
+			return errortools.ErrorMessage(ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (limiter *rateLimiter) refill(now time.Time) {
+	limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+	if limiter.tokens > limiter.capacity {
+		limiter.tokens = limiter.capacity
+	}
+	limiter.last = now
+}
+
+// exhausted halves the rate and pauses all requests for delay, or a default pause if the server did not specify one
+func (limiter *rateLimiter) exhausted(delay time.Duration) {
+	if delay <= 0 {
+		delay = defaultRateLimitPause
+	}
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+	limiter.refill(now)
+
+	limiter.rate /= 2
+	if limiter.rate < limiter.maxRate*rateLimitMinFraction {
+		limiter.rate = limiter.maxRate * rateLimitMinFraction
+	}
+	limiter.tokens = 0
+
+	if pausedUntil := now.Add(delay); pausedUntil.After(limiter.pausedUntil) {
+		limiter.pausedUntil = pausedUntil
+	}
+}
+
+// succeeded lets the rate recover towards the configured rate
+func (limiter *rateLimiter) succeeded() {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	if limiter.rate >= limiter.maxRate {
+		return
+	}
+
+	limiter.refill(time.Now())
+
+	limiter.rate += limiter.maxRate * rateLimitRecovery
+	if limiter.rate > limiter.maxRate {
+		limiter.rate = limiter.maxRate
+	}
+}
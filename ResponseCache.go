@@ -0,0 +1,437 @@
+package google
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	go_http "github.com/leapforce-libraries/go_http"
+)
+
+const (
+	defaultMemoryCacheMaxBytes int64  = 64 * 1024 * 1024
+	defaultDiskCacheMaxBytes   int64  = 256 * 1024 * 1024
+	diskCacheFileExtension     string = ".json"
+)
+
+// CachedResponse is a GET response body stored with its ETag, per resource url and query
+type CachedResponse struct {
+	Url   string `json:"url"`
+	Query string `json:"query"` // encoded parameters of the request
+	ETag  string `json:"etag"`
+	Body  []byte `json:"body"`
+}
+
+// ResponseCache stores CachedResponses by url and query, implementations must be safe for concurrent use
+type ResponseCache interface {
+	Get(url string, query string) (*CachedResponse, bool)
+	Set(response *CachedResponse) // stored under its Url and Query
+	Delete(url string)            // removes the responses of url for all queries
+}
+
+type ResponseCacheConfig struct {
+	Cache   ResponseCache
+	IfMatch bool // send the cached ETag of a url as If-Match when writing to it
+}
+
+// SetResponseCache makes the Service send If-None-Match for GET requests with a ResponseModel
+// and serve the cached body on 304 Not Modified. The response of a cached request then has status 304,
+// it counts as a successful request.
+// The cache is meant for a single principal, do not share it between Services with different credentials.
+// Set it before the Service is used by multiple goroutines, nil disables caching.
+func (service *Service) SetResponseCache(cfg *ResponseCacheConfig) {
+	service.responseCacheConfig = cfg
+}
+
+// cacheRequest prepares requestConfig for the response cache, it returns the requestConfig to send,
+// the cached response if any and the raw body the response is read into
+func (service *Service) cacheRequest(requestConfig *go_http.RequestConfig) (*go_http.RequestConfig, *CachedResponse, *json.RawMessage) {
+	cfg := service.responseCacheConfig
+	if cfg == nil || cfg.Cache == nil {
+		return requestConfig, nil, nil
+	}
+
+	isGet := requestConfig.Method == "" || requestConfig.Method == http.MethodGet
+	if isGet && requestConfig.ResponseModel == nil {
+		return requestConfig, nil, nil
+	}
+
+	_requestConfig := *requestConfig
+	header := http.Header{}
+	if requestConfig.NonDefaultHeaders != nil {
+		header = requestConfig.NonDefaultHeaders.Clone()
+	}
+	_requestConfig.NonDefaultHeaders = &header
+
+	cached, ok := cfg.Cache.Get(requestConfig.Url, cacheQuery(requestConfig))
+
+	if !isGet {
+		if !ok {
+			// writes usually have other parameters than the read of the resource
+			cached, ok = cfg.Cache.Get(requestConfig.Url, "")
+		}
+		if ok && cfg.IfMatch && header.Get("If-Match") == "" {
+			header.Set("If-Match", cached.ETag)
+		}
+		return &_requestConfig, nil, nil
+	}
+
+	if !ok {
+		cached = nil
+	}
+	if cached != nil && header.Get("If-None-Match") == "" {
+		header.Set("If-None-Match", cached.ETag)
+	}
+
+	// the body is stored as is, it is decoded into the ResponseModel afterwards
+	raw := json.RawMessage{}
+	_requestConfig.ResponseModel = &raw
+
+	return &_requestConfig, cached, &raw
+}
+
+// cacheResponse stores or serves the response of a request prepared by cacheRequest.
+// The bool is true if the response was served from the cache.
+func (service *Service) cacheResponse(requestConfig *go_http.RequestConfig, response *http.Response, cached *CachedResponse, raw *json.RawMessage, e *errortools.Error) (bool, *errortools.Error) {
+	cfg := service.responseCacheConfig
+	if cfg == nil || cfg.Cache == nil {
+		return false, e
+	}
+
+	if raw == nil {
+		// a write, the cached response is outdated
+		if requestConfig.Method != "" && requestConfig.Method != http.MethodGet {
+			cfg.Cache.Delete(requestConfig.Url)
+		}
+		return false, e
+	}
+
+	if e != nil {
+		return false, e
+	}
+
+	if response.StatusCode == http.StatusNotModified {
+		if cached == nil {
+			// the caller sent its own If-None-Match, there is no body to serve
+			return false, nil
+		}
+
+		response.Body = io.NopCloser(bytes.NewReader(cached.Body))
+		return true, decodeCachedBody(cached.Body, requestConfig.ResponseModel)
+	}
+
+	response.Body = io.NopCloser(bytes.NewReader(*raw))
+
+	if etag := response.Header.Get("ETag"); etag != "" {
+		cfg.Cache.Set(&CachedResponse{
+			Url:   requestConfig.Url,
+			Query: cacheQuery(requestConfig),
+			ETag:  etag,
+			Body:  *raw,
+		})
+	} else {
+		cfg.Cache.Delete(requestConfig.Url)
+	}
+
+	return false, decodeCachedBody(*raw, requestConfig.ResponseModel)
+}
+
+func decodeCachedBody(body []byte, responseModel interface{}) *errortools.Error {
+	if len(body) == 0 {
+		return nil
+	}
+
+	err := json.Unmarshal(body, responseModel)
+	if err != nil {
+		return errortools.ErrorMessage(err)
+	}
+
+	return nil
+}
+
+func cacheQuery(requestConfig *go_http.RequestConfig) string {
+	if requestConfig.Parameters == nil {
+		return ""
+	}
+
+	return requestConfig.Parameters.Encode()
+}
+
+// MemoryResponseCache keeps responses in memory, the least recently used are removed above MaxBytes
+type MemoryResponseCache struct {
+	mutex    sync.Mutex
+	maxBytes int64
+	bytes    int64
+	entries  map[memoryCacheKey]*list.Element
+	queries  map[string]map[string]bool // queries cached per url
+	lru      *list.List
+}
+
+type memoryCacheKey struct {
+	url   string
+	query string
+}
+
+type MemoryResponseCacheConfig struct {
+	MaxBytes *int64 // defaults to 64 MiB
+}
+
+func NewMemoryResponseCache(cfg *MemoryResponseCacheConfig) *MemoryResponseCache {
+	maxBytes := defaultMemoryCacheMaxBytes
+	if cfg != nil && cfg.MaxBytes != nil {
+		maxBytes = *cfg.MaxBytes
+	}
+
+	return &MemoryResponseCache{
+		maxBytes: maxBytes,
+		entries:  make(map[memoryCacheKey]*list.Element),
+		queries:  make(map[string]map[string]bool),
+		lru:      list.New(),
+	}
+}
+
+func (cache *MemoryResponseCache) Get(url string, query string) (*CachedResponse, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	element, ok := cache.entries[memoryCacheKey{url, query}]
+	if !ok {
+		return nil, false
+	}
+	cache.lru.MoveToFront(element)
+
+	return element.Value.(*CachedResponse), true
+}
+
+func (cache *MemoryResponseCache) Set(response *CachedResponse) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	key := memoryCacheKey{response.Url, response.Query}
+	cache.delete(key)
+
+	if int64(len(response.Body)) > cache.maxBytes {
+		return
+	}
+
+	cache.entries[key] = cache.lru.PushFront(response)
+	if cache.queries[response.Url] == nil {
+		cache.queries[response.Url] = make(map[string]bool)
+	}
+	cache.queries[response.Url][response.Query] = true
+	cache.bytes += int64(len(response.Body))
+
+	for cache.bytes > cache.maxBytes {
+		oldest := cache.lru.Back().Value.(*CachedResponse)
+		cache.delete(memoryCacheKey{oldest.Url, oldest.Query})
+	}
+}
+
+func (cache *MemoryResponseCache) Delete(url string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	for query := range cache.queries[url] {
+		cache.delete(memoryCacheKey{url, query})
+	}
+}
+
+func (cache *MemoryResponseCache) delete(key memoryCacheKey) {
+	element, ok := cache.entries[key]
+	if !ok {
+		return
+	}
+
+	cache.lru.Remove(element)
+	delete(cache.entries, key)
+	cache.bytes -= int64(len(element.Value.(*CachedResponse).Body))
+
+	delete(cache.queries[key.url], key.query)
+	if len(cache.queries[key.url]) == 0 {
+		delete(cache.queries, key.url)
+	}
+}
+
+// DiskResponseCache keeps responses in files in Directory, one subdirectory per url,
+// the least recently used are removed above MaxBytes
+type DiskResponseCache struct {
+	mutex     sync.Mutex
+	directory string
+	maxBytes  int64
+}
+
+type DiskResponseCacheConfig struct {
+	Directory string
+	MaxBytes  *int64 // defaults to 256 MiB
+}
+
+func NewDiskResponseCache(cfg *DiskResponseCacheConfig) (*DiskResponseCache, *errortools.Error) {
+	if cfg == nil {
+		return nil, errortools.ErrorMessage("DiskResponseCacheConfig must not be a nil pointer")
+	}
+
+	if cfg.Directory == "" {
+		return nil, errortools.ErrorMessage("Directory not provided")
+	}
+
+	err := os.MkdirAll(cfg.Directory, 0o700)
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+
+	maxBytes := defaultDiskCacheMaxBytes
+	if cfg.MaxBytes != nil {
+		maxBytes = *cfg.MaxBytes
+	}
+
+	return &DiskResponseCache{
+		directory: cfg.Directory,
+		maxBytes:  maxBytes,
+	}, nil
+}
+
+func (cache *DiskResponseCache) Get(url string, query string) (*CachedResponse, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	path := cache.path(url, query)
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	response := CachedResponse{}
+	err = json.Unmarshal(b, &response)
+	if err != nil || response.Url != url || response.Query != query {
+		return nil, false
+	}
+
+	// the modification time tracks the last use
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	return &response, true
+}
+
+// Set stores response, a failure to write is ignored as the response is then simply not cached
+func (cache *DiskResponseCache) Set(response *CachedResponse) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	b, err := json.Marshal(response)
+	if err != nil || int64(len(b)) > cache.maxBytes {
+		return
+	}
+
+	err = os.MkdirAll(cache.urlDirectory(response.Url), 0o700)
+	if err != nil {
+		return
+	}
+
+	file, err := os.CreateTemp(cache.directory, "tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = file.Write(b)
+	file.Close()
+	if err == nil {
+		err = os.Rename(file.Name(), cache.path(response.Url, response.Query))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return
+	}
+
+	cache.evict()
+}
+
+func (cache *DiskResponseCache) Delete(url string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	os.RemoveAll(cache.urlDirectory(url))
+}
+
+func (cache *DiskResponseCache) urlDirectory(url string) string {
+	return filepath.Join(cache.directory, sha256Hex(url))
+}
+
+func (cache *DiskResponseCache) path(url string, query string) string {
+	return filepath.Join(cache.urlDirectory(url), sha256Hex(query)+diskCacheFileExtension)
+}
+
+func sha256Hex(s string) string {
+	hash := sha256.Sum256([]byte(s))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// evict removes the least recently used files until the cache fits in maxBytes
+func (cache *DiskResponseCache) evict() {
+	urlEntries, err := os.ReadDir(cache.directory)
+	if err != nil {
+		return
+	}
+
+	type cacheFile struct {
+		path string
+		info os.FileInfo
+	}
+
+	var files []cacheFile
+	var size int64
+
+	for _, urlEntry := range urlEntries {
+		if !urlEntry.IsDir() {
+			continue
+		}
+
+		urlDirectory := filepath.Join(cache.directory, urlEntry.Name())
+		dirEntries, err := os.ReadDir(urlDirectory)
+		if err != nil {
+			continue
+		}
+
+		for _, dirEntry := range dirEntries {
+			if !strings.HasSuffix(dirEntry.Name(), diskCacheFileExtension) {
+				continue
+			}
+
+			info, err := dirEntry.Info()
+			if err != nil {
+				continue
+			}
+
+			files = append(files, cacheFile{filepath.Join(urlDirectory, dirEntry.Name()), info})
+			size += info.Size()
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+
+	for _, file := range files {
+		if size <= cache.maxBytes {
+			return
+		}
+
+		if os.Remove(file.path) == nil {
+			size -= file.info.Size()
+			// fails while other queries of the url are cached
+			os.Remove(filepath.Dir(file.path))
+		}
+	}
+}
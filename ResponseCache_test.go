@@ -0,0 +1,161 @@
+package google
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+
+	testserver "github.com/leapforce-libraries/go_google/internal/testserver"
+	go_http "github.com/leapforce-libraries/go_http"
+)
+
+type recordingCollector struct {
+	mutex   sync.Mutex
+	metrics []RequestMetric
+}
+
+func (collector *recordingCollector) ObserveRequest(metric *RequestMetric) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	collector.metrics = append(collector.metrics, *metric)
+}
+
+// newETagServer serves a thing whose ETag depends on the query, it answers 304 if If-None-Match matches
+func newETagServer(t *testing.T, notModified *int) *testserver.Server {
+	return testserver.New(t, map[string]http.HandlerFunc{
+		"GET /v1/things/thing": func(w http.ResponseWriter, r *http.Request) {
+			view := r.URL.Query().Get("view")
+			etag := fmt.Sprintf(`"etag-%s"`, view)
+
+			if r.Header.Get("If-None-Match") == etag {
+				*notModified++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			w.Header().Set("ETag", etag)
+			testserver.WriteJson(w, http.StatusOK, fmt.Sprintf(`{"id":"thing","view":%q}`, view))
+		},
+		"/v1/things/thing": func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("If-Match") != `"etag-"` {
+				t.Errorf("%s with If-Match %q", r.Method, r.Header.Get("If-Match"))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		},
+	})
+}
+
+func testResponseCache(t *testing.T, cache ResponseCache) {
+	var notModified int
+	server := newETagServer(t, &notModified)
+
+	service, e := NewServiceWithAccessToken(&ServiceWithAccessTokenConfig{AccessToken: "access-token"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	collector := recordingCollector{}
+	service.SetMetricsCollector(&collector)
+	service.SetResponseCache(&ResponseCacheConfig{Cache: cache, IfMatch: true})
+
+	get := func(view string) (string, int) {
+		thing := struct {
+			View string `json:"view"`
+		}{}
+		requestConfig := go_http.RequestConfig{
+			Url:           server.URL + "/v1/things/thing",
+			ResponseModel: &thing,
+		}
+		if view != "" {
+			requestConfig.Parameters = &url.Values{"view": {view}}
+		}
+
+		_, response, googleError, e := service.HttpRequestWithGoogleError(&requestConfig)
+		if e != nil || googleError != nil {
+			t.Fatalf("view %q: %v, %v", view, e, googleError)
+		}
+
+		return thing.View, response.StatusCode
+	}
+
+	// the queries are cached next to each other
+	for round := 0; round < 2; round++ {
+		for _, view := range []string{"", "full", "basic"} {
+			_view, statusCode := get(view)
+			if _view != view {
+				t.Errorf("view %q returned view %q", view, _view)
+			}
+			if expected := []int{http.StatusOK, http.StatusNotModified}[round]; statusCode != expected {
+				t.Errorf("round %v view %q returned status %v, expected %v", round, view, statusCode, expected)
+			}
+		}
+	}
+	if notModified != 3 {
+		t.Errorf("%v responses not modified, expected 3", notModified)
+	}
+
+	// a 304 served from the cache is a success
+	cachedCount := 0
+	for _, metric := range collector.metrics {
+		if metric.Reason != "" || (metric.StatusCode != http.StatusOK && metric.StatusCode != http.StatusNotModified) {
+			t.Errorf("metric %+v", metric)
+		}
+		if metric.StatusCode == http.StatusNotModified {
+			cachedCount++
+		}
+	}
+	if cachedCount != 3 {
+		t.Errorf("%v metrics with status 304, expected 3", cachedCount)
+	}
+
+	// a write sends the ETag of the resource without query and removes the cached responses of all queries
+	_, _, e = service.HttpRequest(&go_http.RequestConfig{
+		Method: http.MethodPatch,
+		Url:    server.URL + "/v1/things/thing",
+	})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	for _, query := range []string{"", "view=full", "view=basic"} {
+		if _, ok := cache.Get(server.URL+"/v1/things/thing", query); ok {
+			t.Errorf("query %q still cached after a write", query)
+		}
+	}
+	if _, statusCode := get("full"); statusCode != http.StatusOK {
+		t.Errorf("after a write status is %v, expected 200", statusCode)
+	}
+}
+
+func TestMemoryResponseCache(t *testing.T) {
+	testResponseCache(t, NewMemoryResponseCache(nil))
+}
+
+func TestDiskResponseCache(t *testing.T) {
+	cache, e := NewDiskResponseCache(&DiskResponseCacheConfig{Directory: t.TempDir()})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	testResponseCache(t, cache)
+}
+
+func TestNotModifiedWithoutCache(t *testing.T) {
+	var notModified int
+	server := newETagServer(t, &notModified)
+
+	service, e := NewServiceWithAccessToken(&ServiceWithAccessTokenConfig{AccessToken: "access-token"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	// the caller's own conditional request is answered with 304, which is no error
+	_, response, googleError, e := service.HttpRequestWithGoogleError(&go_http.RequestConfig{
+		Url:               server.URL + "/v1/things/thing",
+		NonDefaultHeaders: &http.Header{"If-None-Match": {`"etag-"`}},
+	})
+	if e != nil || googleError != nil || response.StatusCode != http.StatusNotModified {
+		t.Errorf("conditional request returned %v, %v", e, googleError)
+	}
+}
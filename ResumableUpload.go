@@ -0,0 +1,390 @@
+package google
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	go_http "github.com/leapforce-libraries/go_http"
+)
+
+const (
+	uploadChunkGranularity  int = 256 * 1024
+	defaultUploadChunkSize  int = 32 * uploadChunkGranularity // 8 MiB
+	defaultUploadMaxResumes int = 5
+	statusResumeIncomplete  int = 308
+)
+
+type ResumableUploadConfig struct {
+	Service       *Service
+	Url           string // upload url of the api, uploadType=resumable is added
+	Parameters    *url.Values
+	Metadata      interface{} // sent as json when the session is started
+	Media         io.Reader   // if it is an io.Seeker, resuming a session seeks instead of skipping bytes
+	ContentType   string
+	ContentLength *int64 // total size of Media, if known
+	ChunkSize     *int   // a multiple of 256 KiB, defaults to 8 MiB
+	SessionUri    *string
+	MaxResumes    *int                    // consecutive failed chunks to resume after, defaults to 5
+	ResponseModel interface{}             // the response of the completed upload is decoded into it
+	OnSession     func(sessionUri string) // called when the session starts, e.g. to persist its uri
+	OnProgress    func(uploaded int64)
+}
+
+// ResumableUpload uploads media in chunks using the resumable upload protocol.
+// After a failure it asks the server how many bytes it has received and continues from there.
+type ResumableUpload struct {
+	service       *Service
+	cfg           *ResumableUploadConfig
+	chunkSize     int
+	maxResumes    int
+	sessionUri    string
+	media         *bufio.Reader
+	contentLength int64 // -1 if unknown
+	uploaded      int64 // bytes confirmed by the server
+	buffer        []byte
+	bufferOffset  int64 // position of buffer in the media
+	read          int64 // bytes read from media
+}
+
+func NewResumableUpload(cfg *ResumableUploadConfig) (*ResumableUpload, *errortools.Error) {
+	if cfg == nil {
+		return nil, errortools.ErrorMessage("ResumableUploadConfig must not be a nil pointer")
+	}
+
+	if cfg.Service == nil {
+		return nil, errortools.ErrorMessage("Service not provided")
+	}
+
+	if cfg.Media == nil {
+		return nil, errortools.ErrorMessage("Media not provided")
+	}
+
+	if cfg.Url == "" && cfg.SessionUri == nil {
+		return nil, errortools.ErrorMessage("Url not provided")
+	}
+
+	chunkSize := defaultUploadChunkSize
+	if cfg.ChunkSize != nil {
+		chunkSize = *cfg.ChunkSize
+		if chunkSize <= 0 || chunkSize%uploadChunkGranularity != 0 {
+			return nil, errortools.ErrorMessagef("ChunkSize must be a multiple of %v", uploadChunkGranularity)
+		}
+	}
+
+	maxResumes := defaultUploadMaxResumes
+	if cfg.MaxResumes != nil {
+		maxResumes = *cfg.MaxResumes
+	}
+
+	contentLength := int64(-1)
+	if cfg.ContentLength != nil {
+		contentLength = *cfg.ContentLength
+	}
+
+	sessionUri := ""
+	if cfg.SessionUri != nil {
+		sessionUri = *cfg.SessionUri
+	}
+
+	return &ResumableUpload{
+		service:       cfg.Service,
+		cfg:           cfg,
+		chunkSize:     chunkSize,
+		maxResumes:    maxResumes,
+		sessionUri:    sessionUri,
+		media:         bufio.NewReaderSize(cfg.Media, chunkSize),
+		contentLength: contentLength,
+	}, nil
+}
+
+// SessionUri returns the uri of the upload session, pass it as ResumableUploadConfig.SessionUri to continue the upload later
+func (upload *ResumableUpload) SessionUri() string {
+	return upload.sessionUri
+}
+
+// Uploaded returns the number of bytes the server has confirmed, the size of the media once the upload is complete
+func (upload *ResumableUpload) Uploaded() int64 {
+	return upload.uploaded
+}
+
+// Upload starts or continues the session and uploads the remaining media
+func (upload *ResumableUpload) Upload(ctx context.Context) (*http.Response, *errortools.Error) {
+	if upload.sessionUri == "" {
+		e := upload.start(ctx)
+		if e != nil {
+			return nil, e
+		}
+	} else {
+		response, done, e := upload.queryStatus(ctx)
+		if e != nil {
+			return nil, e
+		}
+		if done {
+			return response, nil
+		}
+	}
+
+	resumes := 0
+
+	for {
+		response, done, e := upload.sendChunk(ctx)
+		if e == nil {
+			if done {
+				return response, nil
+			}
+			resumes = 0
+			continue
+		}
+
+		if !resumable(response) || resumes >= upload.maxResumes || ctx.Err() != nil {
+			return response, e
+		}
+		resumes++
+
+		select {
+		case <-ctx.Done():
+			return response, errortools.ErrorMessage(ctx.Err())
+		case <-time.After(DefaultRetryPolicy().backoff(resumes)):
+		}
+
+		response, done, e = upload.queryStatus(ctx)
+		if e != nil {
+			return response, e
+		}
+		if done {
+			return response, nil
+		}
+	}
+}
+
+// start initiates the session, its uri is returned in the Location header
+func (upload *ResumableUpload) start(ctx context.Context) *errortools.Error {
+	parameters := url.Values{}
+	if upload.cfg.Parameters != nil {
+		parameters = cloneValues(*upload.cfg.Parameters)
+	}
+	parameters.Set("uploadType", "resumable")
+
+	header := http.Header{}
+	if upload.cfg.ContentType != "" {
+		header.Set("X-Upload-Content-Type", upload.cfg.ContentType)
+	}
+	if upload.contentLength >= 0 {
+		header.Set("X-Upload-Content-Length", strconv.FormatInt(upload.contentLength, 10))
+	}
+
+	requestConfig := go_http.RequestConfig{
+		Method:            http.MethodPost,
+		Url:               upload.cfg.Url,
+		Parameters:        &parameters,
+		BodyModel:         upload.cfg.Metadata,
+		NonDefaultHeaders: &header,
+	}
+
+	_, response, _, _, e := upload.service.httpRequest(ctx, &requestConfig)
+	if e != nil {
+		return e
+	}
+	response.Body.Close()
+
+	upload.sessionUri = response.Header.Get("Location")
+	if upload.sessionUri == "" {
+		return errortools.ErrorMessage("Resumable upload session returned no Location")
+	}
+
+	if upload.cfg.OnSession != nil {
+		upload.cfg.OnSession(upload.sessionUri)
+	}
+
+	return nil
+}
+
+// queryStatus asks the server how many bytes it has received and positions the media accordingly
+func (upload *ResumableUpload) queryStatus(ctx context.Context) (*http.Response, bool, *errortools.Error) {
+	total := "*"
+	if upload.contentLength >= 0 {
+		total = strconv.FormatInt(upload.contentLength, 10)
+	}
+
+	return upload.put(ctx, nil, fmt.Sprintf("bytes */%s", total))
+}
+
+// sendChunk sends the next chunk of the media
+func (upload *ResumableUpload) sendChunk(ctx context.Context) (*http.Response, bool, *errortools.Error) {
+	// fill the buffer up to a chunk
+	for len(upload.buffer) < upload.chunkSize {
+		b := make([]byte, upload.chunkSize-len(upload.buffer))
+		n, err := io.ReadFull(upload.media, b)
+		upload.buffer = append(upload.buffer, b[:n]...)
+		upload.read += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, false, errortools.ErrorMessage(err)
+		}
+	}
+
+	// the last chunk also tells the total size
+	total := "*"
+	if upload.contentLength >= 0 {
+		total = strconv.FormatInt(upload.contentLength, 10)
+	} else if _, err := upload.media.Peek(1); err == io.EOF {
+		total = strconv.FormatInt(upload.read, 10)
+	}
+
+	if len(upload.buffer) == 0 {
+		return upload.put(ctx, nil, fmt.Sprintf("bytes */%s", total))
+	}
+
+	contentRange := fmt.Sprintf("bytes %v-%v/%s", upload.bufferOffset, upload.bufferOffset+int64(len(upload.buffer))-1, total)
+
+	return upload.put(ctx, upload.buffer, contentRange)
+}
+
+// put sends a chunk, or a status query if chunk is nil, and processes the persisted range in the response.
+// The bool is true when the upload is complete.
+func (upload *ResumableUpload) put(ctx context.Context, chunk []byte, contentRange string) (*http.Response, bool, *errortools.Error) {
+	header := http.Header{}
+	header.Set("Content-Range", contentRange)
+
+	body := chunk
+	if body == nil {
+		body = []byte{}
+	}
+
+	requestConfig := go_http.RequestConfig{
+		Method:            http.MethodPut,
+		Url:               upload.sessionUri,
+		BodyRaw:           &body,
+		ResponseModel:     upload.cfg.ResponseModel,
+		NonDefaultHeaders: &header,
+	}
+
+	_, response, _, _, e := upload.service.httpRequest(ctx, &requestConfig)
+	if response == nil {
+		return nil, false, e
+	}
+
+	if response.StatusCode != statusResumeIncomplete {
+		if e != nil {
+			return response, false, e
+		}
+
+		// upload complete
+		upload.uploaded = upload.completedSize()
+		upload.buffer = nil
+		upload.progress()
+
+		return response, true, nil
+	}
+
+	persisted, e := persistedBytes(response.Header.Get("Range"))
+	if e != nil {
+		return response, false, e
+	}
+
+	e = upload.seek(persisted)
+	if e != nil {
+		return response, false, e
+	}
+	upload.progress()
+
+	return response, false, nil
+}
+
+// seek continues the media at offset, which is the number of bytes the server has persisted
+func (upload *ResumableUpload) seek(offset int64) *errortools.Error {
+	upload.uploaded = offset
+
+	// resend the part of the buffer the server did not persist
+	if offset >= upload.bufferOffset && offset <= upload.bufferOffset+int64(len(upload.buffer)) {
+		upload.buffer = upload.buffer[offset-upload.bufferOffset:]
+		upload.bufferOffset = offset
+		return nil
+	}
+
+	if offset < upload.bufferOffset || upload.read > upload.bufferOffset+int64(len(upload.buffer)) {
+		return errortools.ErrorMessagef("Server persisted %v bytes, which cannot be resumed from", offset)
+	}
+
+	// a resumed session, position the media at offset
+	upload.buffer = nil
+	upload.bufferOffset = offset
+
+	if seeker, ok := upload.cfg.Media.(io.Seeker); ok && upload.read == 0 {
+		_, err := seeker.Seek(offset, io.SeekStart)
+		if err != nil {
+			return errortools.ErrorMessage(err)
+		}
+		upload.media.Reset(upload.cfg.Media)
+		upload.read = offset
+		return nil
+	}
+
+	n, err := io.CopyN(io.Discard, upload.media, offset-upload.read)
+	upload.read += n
+	if err != nil {
+		return errortools.ErrorMessagef("Media is shorter than the %v bytes the server persisted", offset)
+	}
+
+	return nil
+}
+
+// completedSize returns the size of the completed media, a resumed session can be complete before any media is read
+func (upload *ResumableUpload) completedSize() int64 {
+	if upload.contentLength >= 0 {
+		return upload.contentLength
+	}
+
+	if seeker, ok := upload.cfg.Media.(io.Seeker); ok && upload.read == 0 {
+		size, err := seeker.Seek(0, io.SeekEnd)
+		if err == nil {
+			return size
+		}
+	}
+
+	return upload.read
+}
+
+func (upload *ResumableUpload) progress() {
+	if upload.cfg.OnProgress != nil {
+		upload.cfg.OnProgress(upload.uploaded)
+	}
+}
+
+// persistedBytes parses a Range header like "bytes=0-1048575", no header means nothing was persisted
+func persistedBytes(_range string) (int64, *errortools.Error) {
+	if _range == "" {
+		return 0, nil
+	}
+
+	i := strings.LastIndex(_range, "-")
+	if i < 0 {
+		return 0, errortools.ErrorMessagef("Invalid Range header '%s'", _range)
+	}
+
+	last, err := strconv.ParseInt(_range[i+1:], 10, 64)
+	if err != nil {
+		return 0, errortools.ErrorMessagef("Invalid Range header '%s'", _range)
+	}
+
+	return last + 1, nil
+}
+
+// resumable reports whether a chunk that failed with response can be resumed
+func resumable(response *http.Response) bool {
+	if response == nil {
+		return true
+	}
+
+	return response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,403 @@
+package google
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	testserver "github.com/leapforce-libraries/go_google/internal/testserver"
+)
+
+const testUploadChunkSize int = uploadChunkGranularity
+
+// uploadSession is the state of a session of the fake upload server
+type uploadSession struct {
+	data     []byte
+	total    int64 // -1 until the client tells it
+	complete bool
+}
+
+// uploadServer is a stand-in of a resumable upload endpoint.
+// failPut is the number of the PUT request that fails with 503, partialPut the one that persists only half its chunk.
+type uploadServer struct {
+	*testserver.Server
+	t              *testing.T
+	mutex          sync.Mutex
+	sessions       map[string]*uploadSession
+	contentRanges  []string
+	failPut        int
+	partialPut     int
+	sessionCounter int
+}
+
+func newUploadServer(t *testing.T) *uploadServer {
+	server := uploadServer{t: t, sessions: map[string]*uploadSession{}}
+
+	server.Server = testserver.New(t, map[string]http.HandlerFunc{
+		"POST /upload/v1/files":    server.start,
+		"PUT /upload/session/{id}": server.put,
+	})
+
+	return &server
+}
+
+// addSession adds a session that has persisted data, as if it was started by an earlier process
+func (server *uploadServer) addSession(id string, data []byte, total int64, complete bool) string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.sessions[id] = &uploadSession{data: append([]byte{}, data...), total: total, complete: complete}
+
+	return server.URL + "/upload/session/" + id
+}
+
+func (server *uploadServer) session(id string) *uploadSession {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	return server.sessions[id]
+}
+
+func (server *uploadServer) ContentRanges() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	return append([]string{}, server.contentRanges...)
+}
+
+func (server *uploadServer) start(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("uploadType") != "resumable" {
+		server.t.Errorf("session started with uploadType %q", r.URL.Query().Get("uploadType"))
+	}
+
+	total := int64(-1)
+	if contentLength := r.Header.Get("X-Upload-Content-Length"); contentLength != "" {
+		total, _ = strconv.ParseInt(contentLength, 10, 64)
+	}
+
+	server.mutex.Lock()
+	server.sessionCounter++
+	id := fmt.Sprintf("s%v", server.sessionCounter)
+	server.sessions[id] = &uploadSession{total: total}
+	server.mutex.Unlock()
+
+	w.Header().Set("Location", server.URL+"/upload/session/"+id)
+	testserver.WriteJson(w, http.StatusOK, `{}`)
+}
+
+func (server *uploadServer) put(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.contentRanges = append(server.contentRanges, r.Header.Get("Content-Range"))
+	number := len(server.contentRanges)
+
+	session, ok := server.sessions[r.PathValue("id")]
+	if !ok {
+		testserver.WriteJson(w, http.StatusNotFound, `{"error":{"code":404,"message":"Session not found","status":"NOT_FOUND"}}`)
+		return
+	}
+
+	if number == server.failPut {
+		testserver.WriteJson(w, http.StatusServiceUnavailable, `{"error":{"code":503,"message":"Backend unavailable","status":"UNAVAILABLE"}}`)
+		return
+	}
+
+	// Content-Range is "bytes first-last/total" for a chunk or "bytes */total" for a status query
+	contentRange := strings.TrimPrefix(r.Header.Get("Content-Range"), "bytes ")
+	_range, total, _ := strings.Cut(contentRange, "/")
+	if total != "*" {
+		session.total, _ = strconv.ParseInt(total, 10, 64)
+	}
+
+	if _range != "*" {
+		first, _, _ := strings.Cut(_range, "-")
+		offset, _ := strconv.ParseInt(first, 10, 64)
+		if offset > int64(len(session.data)) {
+			testserver.WriteJson(w, http.StatusBadRequest, `{"error":{"code":400,"message":"Chunk after the persisted bytes","status":"INVALID_ARGUMENT"}}`)
+			return
+		}
+
+		body = body[int64(len(session.data))-offset:]
+		if number == server.partialPut {
+			body = body[:len(body)/2]
+		}
+		session.data = append(session.data, body...)
+	}
+
+	if session.complete || int64(len(session.data)) == session.total {
+		session.complete = true
+		testserver.WriteJson(w, http.StatusOK, fmt.Sprintf(`{"id":"file","size":"%v"}`, len(session.data)))
+		return
+	}
+
+	if len(session.data) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%v", len(session.data)-1))
+	}
+	w.WriteHeader(statusResumeIncomplete)
+}
+
+// testMedia returns size bytes of media that differ per position
+func testMedia(size int) []byte {
+	media := make([]byte, size)
+	for i := range media {
+		media[i] = byte(i % 251)
+	}
+
+	return media
+}
+
+type testUploadedFile struct {
+	Id   string `json:"id"`
+	Size string `json:"size"`
+}
+
+func newTestUpload(t *testing.T, server *uploadServer, cfg ResumableUploadConfig) *ResumableUpload {
+	service, e := NewServiceWithAccessToken(&ServiceWithAccessTokenConfig{AccessToken: "access-token"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	// failed chunks are resumed by the upload, not retried by the Service
+	service.SetRetryPolicy(&RetryPolicy{MaxAttempts: 1})
+
+	chunkSize := testUploadChunkSize
+	cfg.Service = service
+	cfg.ChunkSize = &chunkSize
+	if cfg.SessionUri == nil {
+		cfg.Url = server.URL + "/upload/v1/files"
+	}
+
+	upload, e := NewResumableUpload(&cfg)
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	return upload
+}
+
+// onlyReader hides the io.Seeker of a reader
+type onlyReader struct {
+	io.Reader
+}
+
+func TestResumableUpload(t *testing.T) {
+	size := 2*testUploadChunkSize + 1000
+	media := testMedia(size)
+	contentLength := int64(size)
+
+	tests := []struct {
+		name          string
+		contentLength *int64
+		failPut       int
+		partialPut    int
+		contentRanges []string
+	}{
+		{"known length", &contentLength, 0, 0, []string{
+			fmt.Sprintf("bytes 0-262143/%v", size),
+			fmt.Sprintf("bytes 262144-524287/%v", size),
+			fmt.Sprintf("bytes 524288-525287/%v", size),
+		}},
+		{"unknown length", nil, 0, 0, []string{
+			"bytes 0-262143/*",
+			"bytes 262144-524287/*",
+			fmt.Sprintf("bytes 524288-525287/%v", size),
+		}},
+		{"resume after a failed chunk", &contentLength, 2, 0, []string{
+			fmt.Sprintf("bytes 0-262143/%v", size),
+			fmt.Sprintf("bytes 262144-524287/%v", size),
+			fmt.Sprintf("bytes */%v", size),
+			fmt.Sprintf("bytes 262144-524287/%v", size),
+			fmt.Sprintf("bytes 524288-525287/%v", size),
+		}},
+		{"resume after a partially persisted chunk", &contentLength, 0, 2, []string{
+			fmt.Sprintf("bytes 0-262143/%v", size),
+			fmt.Sprintf("bytes 262144-524287/%v", size),
+			fmt.Sprintf("bytes 393216-525287/%v", size),
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newUploadServer(t)
+			server.failPut = test.failPut
+			server.partialPut = test.partialPut
+
+			sessionUris := []string{}
+			progress := []int64{}
+			file := testUploadedFile{}
+
+			upload := newTestUpload(t, server, ResumableUploadConfig{
+				Media:         bytes.NewReader(media),
+				ContentType:   "application/octet-stream",
+				ContentLength: test.contentLength,
+				ResponseModel: &file,
+				OnSession:     func(sessionUri string) { sessionUris = append(sessionUris, sessionUri) },
+				OnProgress:    func(uploaded int64) { progress = append(progress, uploaded) },
+			})
+
+			_, e := upload.Upload(context.Background())
+			if e != nil {
+				t.Fatal(e.Message())
+			}
+
+			if len(sessionUris) != 1 || sessionUris[0] != upload.SessionUri() || upload.SessionUri() != server.URL+"/upload/session/s1" {
+				t.Errorf("OnSession called with %v, SessionUri is %s", sessionUris, upload.SessionUri())
+			}
+			if starts := server.Requests("/upload/v1/files"); len(starts) != 1 || starts[0].Header.Get("X-Upload-Content-Type") != "application/octet-stream" {
+				t.Errorf("session started %v times", len(starts))
+			}
+
+			if !bytes.Equal(server.session("s1").data, media) {
+				t.Errorf("server received %v bytes that differ from the media", len(server.session("s1").data))
+			}
+			if fmt.Sprint(server.ContentRanges()) != fmt.Sprint(test.contentRanges) {
+				t.Errorf("Content-Ranges are %q, expected %q", server.ContentRanges(), test.contentRanges)
+			}
+
+			if upload.Uploaded() != contentLength || progress[len(progress)-1] != contentLength {
+				t.Errorf("Uploaded is %v, progress %v", upload.Uploaded(), progress)
+			}
+			if file.Id != "file" || file.Size != strconv.Itoa(size) {
+				t.Errorf("response is %+v", file)
+			}
+		})
+	}
+}
+
+func TestResumableUploadStoredSession(t *testing.T) {
+	size := 2*testUploadChunkSize + 1000
+	media := testMedia(size)
+	contentLength := int64(size)
+	persisted := testUploadChunkSize + 100
+
+	// a seekable media is positioned by seeking, other media by skipping the persisted bytes
+	for _, seekable := range []bool{true, false} {
+		t.Run(fmt.Sprintf("seekable %v", seekable), func(t *testing.T) {
+			server := newUploadServer(t)
+			sessionUri := server.addSession("stored", media[:persisted], contentLength, false)
+
+			var reader io.Reader = bytes.NewReader(media)
+			if !seekable {
+				reader = onlyReader{reader}
+			}
+
+			onSession := 0
+			upload := newTestUpload(t, server, ResumableUploadConfig{
+				Media:         reader,
+				ContentLength: &contentLength,
+				SessionUri:    &sessionUri,
+				OnSession:     func(string) { onSession++ },
+			})
+
+			_, e := upload.Upload(context.Background())
+			if e != nil {
+				t.Fatal(e.Message())
+			}
+
+			// the session is not started again, its status is queried first
+			if len(server.Requests("/upload/v1/files")) != 0 || onSession != 0 {
+				t.Errorf("stored session started again")
+			}
+			expected := []string{
+				fmt.Sprintf("bytes */%v", size),
+				fmt.Sprintf("bytes %v-%v/%v", persisted, persisted+testUploadChunkSize-1, size),
+				fmt.Sprintf("bytes %v-%v/%v", persisted+testUploadChunkSize, size-1, size),
+			}
+			if fmt.Sprint(server.ContentRanges()) != fmt.Sprint(expected) {
+				t.Errorf("Content-Ranges are %q, expected %q", server.ContentRanges(), expected)
+			}
+
+			if !bytes.Equal(server.session("stored").data, media) || upload.Uploaded() != contentLength {
+				t.Errorf("server received %v bytes that differ from the media, Uploaded is %v", len(server.session("stored").data), upload.Uploaded())
+			}
+		})
+	}
+}
+
+func TestResumableUploadCompletedSession(t *testing.T) {
+	media := testMedia(testUploadChunkSize + 10)
+	contentLength := int64(len(media))
+
+	tests := []struct {
+		name          string
+		contentLength *int64
+		media         io.Reader
+	}{
+		{"known length", &contentLength, onlyReader{bytes.NewReader(media)}},
+		{"seekable media of unknown length", nil, bytes.NewReader(media)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newUploadServer(t)
+			sessionUri := server.addSession("done", media, contentLength, true)
+
+			upload := newTestUpload(t, server, ResumableUploadConfig{
+				Media:         test.media,
+				ContentLength: test.contentLength,
+				SessionUri:    &sessionUri,
+			})
+
+			response, e := upload.Upload(context.Background())
+			if e != nil {
+				t.Fatal(e.Message())
+			}
+
+			if response.StatusCode != http.StatusOK || len(server.ContentRanges()) != 1 || !strings.HasPrefix(server.ContentRanges()[0], "bytes */") {
+				t.Errorf("status %v after Content-Ranges %q", response.StatusCode, server.ContentRanges())
+			}
+			if upload.Uploaded() != contentLength {
+				t.Errorf("Uploaded is %v, expected %v", upload.Uploaded(), contentLength)
+			}
+		})
+	}
+}
+
+func TestResumableUploadMaxResumes(t *testing.T) {
+	server := newUploadServer(t)
+	server.failPut = 1
+
+	maxResumes := 0
+	upload := newTestUpload(t, server, ResumableUploadConfig{
+		Media:      bytes.NewReader(testMedia(100)),
+		MaxResumes: &maxResumes,
+	})
+
+	response, e := upload.Upload(context.Background())
+	if e == nil || response == nil || response.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Upload returned %v", e)
+	}
+	if len(server.ContentRanges()) != 1 {
+		t.Errorf("Content-Ranges are %q, expected no resume", server.ContentRanges())
+	}
+}
+
+func TestPersistedBytes(t *testing.T) {
+	tests := []struct {
+		_range    string
+		persisted int64
+		valid     bool
+	}{
+		{"", 0, true},
+		{"bytes=0-0", 1, true},
+		{"bytes=0-262143", 262144, true},
+		{"bytes=0-1048575", 1048576, true},
+		{"bytes", 0, false},
+		{"bytes=0-last", 0, false},
+	}
+
+	for _, test := range tests {
+		persisted, e := persistedBytes(test._range)
+		if (e == nil) != test.valid || persisted != test.persisted {
+			t.Errorf("persistedBytes(%q) is %v, %v", test._range, persisted, e)
+		}
+	}
+}
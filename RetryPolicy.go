@@ -0,0 +1,158 @@
+package google
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts    int           = 5
+	defaultRetryInitialBackoff time.Duration = time.Second
+	defaultRetryMaxBackoff     time.Duration = 32 * time.Second
+	defaultRetryMultiplier     float64       = 2
+)
+
+// RetryPolicy describes when and how often a failed request is sent again
+type RetryPolicy struct {
+	MaxAttempts        int // including the first attempt
+	InitialBackoff     time.Duration
+	MaxBackoff         time.Duration
+	Multiplier         float64
+	RetryStatusCodes   []int    // http status codes to retry, e.g. 429 and 503
+	RetryReasons       []string // GoogleError reasons to retry, e.g. "rateLimitExceeded"
+	RetryNonIdempotent bool     // also retry POST and PATCH requests
+}
+
+// defaultRetryPolicy is used by Services without RetryPolicy, it is never modified
+var defaultRetryPolicy = DefaultRetryPolicy()
+
+// DefaultRetryPolicy retries rate limited and temporarily failing idempotent requests up to 5 times
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    defaultRetryMaxAttempts,
+		InitialBackoff: defaultRetryInitialBackoff,
+		MaxBackoff:     defaultRetryMaxBackoff,
+		Multiplier:     defaultRetryMultiplier,
+		RetryStatusCodes: []int{
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+		RetryReasons: []string{
+			"rateLimitExceeded",
+			"userRateLimitExceeded",
+			"backendError",
+			"internalError",
+		},
+	}
+}
+
+// shouldRetry reports whether attempt, that failed with response and googleError, is to be retried.
+// A nil response means the request did not get a response at all, e.g. because of a network error.
+func (retryPolicy *RetryPolicy) shouldRetry(method string, attempt int, response *http.Response, googleError *GoogleError) bool {
+	if retryPolicy == nil || attempt >= retryPolicy.MaxAttempts {
+		return false
+	}
+
+	if !retryPolicy.RetryNonIdempotent && !isIdempotent(method) {
+		return false
+	}
+
+	if response == nil {
+		return true
+	}
+
+	for _, statusCode := range retryPolicy.RetryStatusCodes {
+		if response.StatusCode == statusCode {
+			return true
+		}
+	}
+
+	if googleError != nil && googleError.Reason != "" {
+		for _, reason := range retryPolicy.RetryReasons {
+			if googleError.Reason == reason {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// delay returns how long to wait before the next attempt.
+// A delay requested by the server through Retry-After or RetryInfo takes precedence over the backoff.
+func (retryPolicy *RetryPolicy) delay(attempt int, response *http.Response, googleError *GoogleError) time.Duration {
+	if delay := serverDelay(response, googleError); delay > 0 {
+		return delay
+	}
+
+	return retryPolicy.backoff(attempt)
+}
+
+// serverDelay returns the delay requested by the server through RetryInfo or Retry-After, zero if there is none
+func serverDelay(response *http.Response, googleError *GoogleError) time.Duration {
+	if googleError != nil {
+		if retryInfo := googleError.RetryInfo(); retryInfo != nil {
+			if delay := retryInfo.Delay(); delay > 0 {
+				return delay
+			}
+		}
+	}
+
+	if response != nil {
+		if delay := retryAfter(response.Header.Get("Retry-After"), time.Now()); delay > 0 {
+			return delay
+		}
+	}
+
+	return 0
+}
+
+// backoff returns the exponential backoff after attempt with "equal jitter":
+// half of the backoff is fixed, the other half random
+func (retryPolicy *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := retryPolicy.Multiplier
+	if multiplier < 1 {
+		multiplier = defaultRetryMultiplier
+	}
+
+	backoff := float64(retryPolicy.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if retryPolicy.MaxBackoff > 0 && backoff > float64(retryPolicy.MaxBackoff) {
+		backoff = float64(retryPolicy.MaxBackoff)
+	}
+
+	return time.Duration(backoff/2 + rand.Float64()*backoff/2)
+}
+
+// retryAfter parses a Retry-After header, which holds either seconds or an http date
+func retryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0
+	}
+
+	return date.Sub(now)
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
@@ -1,28 +1,52 @@
 package google
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	errortools "github.com/leapforce-libraries/go_errortools"
+	credentials "github.com/leapforce-libraries/go_google/credentials"
 	go_http "github.com/leapforce-libraries/go_http"
 	oauth2 "github.com/leapforce-libraries/go_oauth2"
 	go_token "github.com/leapforce-libraries/go_oauth2/token"
 	tokensource "github.com/leapforce-libraries/go_oauth2/tokensource"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Service stores GoogleService configuration
 type Service struct {
-	apiName           string
-	authorizationMode authorizationMode
-	clientId          string
-	apiKey            *string
-	accessToken       *string
-	httpService       *go_http.Service
-	oAuth2Service     *oauth2.Service
-	errorResponse     *ErrorResponse
+	apiName                   string
+	authorizationMode         authorizationMode
+	clientId                  string
+	clientSecret              string
+	tokenSource               tokensource.TokenSource
+	apiKey                    *string
+	accessToken               *string
+	httpClient                *http.Client
+	requestCount              int64
+	oAuth2Service             *oauth2.Service
+	serviceAccountTokenSource *ServiceAccountTokenSource
+	impersonatedTokenSource   *ImpersonatedTokenSource
+	refreshMargin             *time.Duration
+	credentialsSource         string
+	retryPolicy               *RetryPolicy
+	quotaBucket               string
+	autoFieldMask             bool
+	responseCacheConfig       *ResponseCacheConfig
+	metricsCollector          MetricsCollector
+	tracerProvider            trace.TracerProvider
+	logger                    *slog.Logger
+	codeVerifierStore         CodeVerifierStore
+	mutex                     sync.Mutex
+	errorResponse             *ErrorResponse
+	googleError               *GoogleError
 }
 
 const (
@@ -36,18 +60,34 @@ const (
 type authorizationMode string
 
 const (
-	authorizationModeOAuth2      authorizationMode = "oauth2"
-	authorizationModeApiKey      authorizationMode = "apikey"
-	authorizationModeAccessToken authorizationMode = "accesstoken"
+	authorizationModeOAuth2          authorizationMode = "oauth2"
+	authorizationModeApiKey          authorizationMode = "apikey"
+	authorizationModeAccessToken     authorizationMode = "accesstoken"
+	authorizationModeServiceAccount  authorizationMode = "serviceaccount"
+	authorizationModeImpersonation   authorizationMode = "impersonation"
+	authorizationModeExternalAccount authorizationMode = "externalaccount"
+	authorizationModeAuthorizedUser  authorizationMode = "authorizeduser"
+	authorizationModeMetadata        authorizationMode = "metadata"
 )
 
+func init() {
+	// go_http calls errortools.SetContext("http_url", ...) on every request, and errortools.SetContext
+	// creates its package level context map with an unguarded nil check before taking its mutex.
+	// Concurrent first requests would race on that check, so the map is created once here, before
+	// any request can run; the key itself is removed again and leaves no context behind.
+	// This can go once errortools creates the map under its lock.
+	errortools.SetContext("go_google", "")
+	errortools.RemoveContext("go_google")
+}
+
 type ServiceWithOAuth2Config struct {
-	ApiName       string
-	ClientId      string
-	ClientSecret  string
-	TokenSource   tokensource.TokenSource
-	RedirectUrl   *string
-	RefreshMargin *time.Duration
+	ApiName           string
+	ClientId          string
+	ClientSecret      string
+	TokenSource       tokensource.TokenSource
+	RedirectUrl       *string
+	RefreshMargin     *time.Duration
+	CodeVerifierStore CodeVerifierStore // PKCE code verifiers, defaults to a MemoryCodeVerifierStore once AuthorizeUrlWithPkce is used
 }
 
 func NewServiceWithOAuth2(cfg *ServiceWithOAuth2Config) (*Service, *errortools.Error) {
@@ -83,7 +123,11 @@ func NewServiceWithOAuth2(cfg *ServiceWithOAuth2Config) (*Service, *errortools.E
 		apiName:           cfg.ApiName,
 		authorizationMode: authorizationModeOAuth2,
 		clientId:          cfg.ClientId,
+		clientSecret:      cfg.ClientSecret,
+		tokenSource:       cfg.TokenSource,
+		httpClient:        &http.Client{},
 		oAuth2Service:     oauth2Service,
+		codeVerifierStore: cfg.CodeVerifierStore,
 	}, nil
 }
 
@@ -101,16 +145,11 @@ func NewServiceWithAccessToken(cfg *ServiceWithAccessTokenConfig) (*Service, *er
 		return nil, errortools.ErrorMessage("AccessToken not provided")
 	}
 
-	httpService, e := go_http.NewService(&go_http.ServiceConfig{})
-	if e != nil {
-		return nil, e
-	}
-
 	return &Service{
 		apiName:           cfg.ApiName,
 		authorizationMode: authorizationModeAccessToken,
 		accessToken:       &cfg.AccessToken,
-		httpService:       httpService,
+		httpClient:        &http.Client{},
 	}, nil
 }
 
@@ -128,60 +167,544 @@ func NewServiceWithApiKey(cfg *ServiceWithApiKeyConfig) (*Service, *errortools.E
 		return nil, errortools.ErrorMessage("ApiKey not provided")
 	}
 
-	httpService, e := go_http.NewService(&go_http.ServiceConfig{})
+	return &Service{
+		apiName:           cfg.ApiName,
+		authorizationMode: authorizationModeApiKey,
+		apiKey:            &cfg.ApiKey,
+		httpClient:        &http.Client{},
+	}, nil
+}
+
+type ServiceWithServiceAccountConfig struct {
+	ApiName         string
+	CredentialsJson *credentials.CredentialsJson
+	Scopes          []string
+	Subject         string // user to impersonate through domain-wide delegation
+	RefreshMargin   *time.Duration
+}
+
+func NewServiceWithServiceAccount(cfg *ServiceWithServiceAccountConfig) (*Service, *errortools.Error) {
+	if cfg == nil {
+		return nil, errortools.ErrorMessage("ServiceConfig must not be a nil pointer")
+	}
+
+	if cfg.CredentialsJson == nil {
+		return nil, errortools.ErrorMessage("CredentialsJson not provided")
+	}
+
+	tokenSource, e := NewServiceAccountTokenSource(&ServiceAccountTokenSourceConfig{
+		CredentialsJson: cfg.CredentialsJson,
+		Scopes:          cfg.Scopes,
+		Subject:         cfg.Subject,
+	})
+	if e != nil {
+		return nil, e
+	}
+
+	return newServiceWithServiceAccountTokenSource(cfg.ApiName, cfg.CredentialsJson.ClientId, tokenSource, cfg.RefreshMargin)
+}
+
+// newServiceWithTokenSource returns a Service whose tokens are generated by tokenSource and validated by the oauth2 service
+func newServiceWithTokenSource(apiName string, authorizationMode authorizationMode, clientId string, tokenSource tokensource.TokenSource, refreshMargin *time.Duration) (*Service, *errortools.Error) {
+	oauth2ServiceConfig := oauth2.ServiceConfig{
+		ClientId:        clientId,
+		TokenUrl:        tokenUrl,
+		RefreshMargin:   refreshMargin,
+		TokenHttpMethod: tokenHttpMethod,
+		TokenSource:     tokenSource,
+	}
+	oauth2Service, e := oauth2.NewService(&oauth2ServiceConfig)
 	if e != nil {
 		return nil, e
 	}
 
 	return &Service{
-		apiName:           cfg.ApiName,
-		authorizationMode: authorizationModeApiKey,
-		apiKey:            &cfg.ApiKey,
-		httpService:       httpService,
+		apiName:           apiName,
+		authorizationMode: authorizationMode,
+		clientId:          clientId,
+		httpClient:        &http.Client{},
+		oAuth2Service:     oauth2Service,
+		refreshMargin:     refreshMargin,
 	}, nil
 }
 
+func newServiceWithServiceAccountTokenSource(apiName string, clientId string, tokenSource *ServiceAccountTokenSource, refreshMargin *time.Duration) (*Service, *errortools.Error) {
+	service, e := newServiceWithTokenSource(apiName, authorizationModeServiceAccount, clientId, tokenSource, refreshMargin)
+	if e != nil {
+		return nil, e
+	}
+
+	service.serviceAccountTokenSource = tokenSource
+
+	return service, nil
+}
+
+// WithSubject returns a Service that impersonates subject using the same service account key
+// and the settings of service, e.g. its retry policy, quota bucket, cache, metrics, tracing and logger.
+// Each returned Service keeps its own token cache.
+func (service *Service) WithSubject(subject string) (*Service, *errortools.Error) {
+	if service.authorizationMode != authorizationModeServiceAccount {
+		return nil, errortools.ErrorMessage("WithSubject requires a service account Service")
+	}
+
+	if subject == "" {
+		return nil, errortools.ErrorMessage("Subject not provided")
+	}
+
+	_service, e := newServiceWithServiceAccountTokenSource(service.apiName, service.clientId, service.serviceAccountTokenSource.WithSubject(subject), service.refreshMargin)
+	if e != nil {
+		return nil, e
+	}
+
+	_service.copySettings(service)
+
+	return _service, nil
+}
+
+// copySettings copies the settings of from that do not depend on its credentials
+func (service *Service) copySettings(from *Service) {
+	service.httpClient = from.httpClient
+	service.credentialsSource = from.credentialsSource
+	service.retryPolicy = from.retryPolicy
+	service.quotaBucket = from.quotaBucket
+	service.autoFieldMask = from.autoFieldMask
+	service.responseCacheConfig = from.responseCacheConfig
+	service.metricsCollector = from.metricsCollector
+	service.tracerProvider = from.tracerProvider
+	service.logger = from.logger
+}
+
+type ServiceWithImpersonationConfig struct {
+	ApiName           string
+	SourceService     *Service
+	TargetPrincipal   string   // email of the service account to impersonate
+	Delegates         []string // emails of the service accounts in the delegation chain
+	Scopes            []string
+	Lifetime          *time.Duration
+	IamCredentialsUrl *string
+	RefreshMargin     *time.Duration
+}
+
+// NewServiceWithImpersonation returns a Service that uses short-lived tokens of TargetPrincipal,
+// generated with the credentials of SourceService
+func NewServiceWithImpersonation(cfg *ServiceWithImpersonationConfig) (*Service, *errortools.Error) {
+	if cfg == nil {
+		return nil, errortools.ErrorMessage("ServiceConfig must not be a nil pointer")
+	}
+
+	tokenSource, e := NewImpersonatedTokenSource(&ImpersonatedTokenSourceConfig{
+		SourceService:     cfg.SourceService,
+		TargetPrincipal:   cfg.TargetPrincipal,
+		Delegates:         cfg.Delegates,
+		Scopes:            cfg.Scopes,
+		Lifetime:          cfg.Lifetime,
+		IamCredentialsUrl: cfg.IamCredentialsUrl,
+	})
+	if e != nil {
+		return nil, e
+	}
+
+	service, e := newServiceWithTokenSource(cfg.ApiName, authorizationModeImpersonation, cfg.TargetPrincipal, tokenSource, cfg.RefreshMargin)
+	if e != nil {
+		return nil, e
+	}
+
+	service.impersonatedTokenSource = tokenSource
+
+	return service, nil
+}
+
+type ServiceWithExternalAccountConfig struct {
+	ApiName         string
+	CredentialsJson *credentials.CredentialsJson
+	Scopes          []string
+	RefreshMargin   *time.Duration
+}
+
+// NewServiceWithExternalAccount returns a Service that authorizes with external_account (workload identity federation) credentials
+func NewServiceWithExternalAccount(cfg *ServiceWithExternalAccountConfig) (*Service, *errortools.Error) {
+	if cfg == nil {
+		return nil, errortools.ErrorMessage("ServiceConfig must not be a nil pointer")
+	}
+
+	tokenSource, e := NewExternalAccountTokenSource(&ExternalAccountTokenSourceConfig{
+		CredentialsJson: cfg.CredentialsJson,
+		Scopes:          cfg.Scopes,
+	})
+	if e != nil {
+		return nil, e
+	}
+
+	return newServiceWithTokenSource(cfg.ApiName, authorizationModeExternalAccount, cfg.CredentialsJson.Audience, tokenSource, cfg.RefreshMargin)
+}
+
+type ServiceFromDefaultCredentialsConfig struct {
+	ApiName                  string
+	Scopes                   []string
+	RefreshMargin            *time.Duration
+	DefaultCredentialsConfig *credentials.DefaultCredentialsConfig
+}
+
+// NewServiceFromDefaultCredentials returns a Service that authorizes with Application Default Credentials,
+// CredentialsSource reports where they were found
+func NewServiceFromDefaultCredentials(cfg *ServiceFromDefaultCredentialsConfig) (*Service, *errortools.Error) {
+	if cfg == nil {
+		return nil, errortools.ErrorMessage("ServiceConfig must not be a nil pointer")
+	}
+
+	defaultCredentials, e := credentials.FindDefaultCredentials(cfg.DefaultCredentialsConfig)
+	if e != nil {
+		return nil, e
+	}
+
+	var service *Service
+
+	if defaultCredentials.Source == credentials.DefaultCredentialsSourceMetadata {
+		tokenSource, e := NewMetadataTokenSource(&MetadataTokenSourceConfig{
+			MetadataHost: defaultCredentials.MetadataHost,
+			Scopes:       cfg.Scopes,
+		})
+		if e != nil {
+			return nil, e
+		}
+
+		service, e = newServiceWithTokenSource(cfg.ApiName, authorizationModeMetadata, defaultCredentials.MetadataHost, tokenSource, cfg.RefreshMargin)
+		if e != nil {
+			return nil, e
+		}
+	} else {
+		credentialsJson := defaultCredentials.CredentialsJson
+
+		switch credentialsJson.Type {
+		case credentials.TypeServiceAccount:
+			service, e = NewServiceWithServiceAccount(&ServiceWithServiceAccountConfig{
+				ApiName:         cfg.ApiName,
+				CredentialsJson: credentialsJson,
+				Scopes:          cfg.Scopes,
+				RefreshMargin:   cfg.RefreshMargin,
+			})
+		case credentials.TypeExternalAccount:
+			service, e = NewServiceWithExternalAccount(&ServiceWithExternalAccountConfig{
+				ApiName:         cfg.ApiName,
+				CredentialsJson: credentialsJson,
+				Scopes:          cfg.Scopes,
+				RefreshMargin:   cfg.RefreshMargin,
+			})
+		case credentials.TypeAuthorizedUser:
+			var tokenSource *AuthorizedUserTokenSource
+			tokenSource, e = NewAuthorizedUserTokenSource(&AuthorizedUserTokenSourceConfig{
+				CredentialsJson: credentialsJson,
+			})
+			if e == nil {
+				service, e = newServiceWithTokenSource(cfg.ApiName, authorizationModeAuthorizedUser, credentialsJson.ClientId, tokenSource, cfg.RefreshMargin)
+			}
+		default:
+			e = errortools.ErrorMessagef("Unsupported credentials type '%s'", credentialsJson.Type)
+		}
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	service.credentialsSource = defaultCredentials.String()
+
+	return service, nil
+}
+
 /*
 func (service *Service) InitToken(scope string, accessType *string, prompt *string, state *string) *errortools.Error {
 	return service.oAuth2Service.InitToken(scope, accessType, prompt, state)
 }*/
 
+// HttpRequest sends the request and keeps its error in ErrorResponse and GoogleError.
+// Use HttpRequestWithGoogleError when the Service is shared between goroutines.
 func (service *Service) HttpRequest(requestConfig *go_http.RequestConfig) (*http.Request, *http.Response, *errortools.Error) {
-	var request *http.Request
-	var response *http.Response
-	var e *errortools.Error
+	return service.HttpRequestContext(context.Background(), requestConfig)
+}
 
-	// add error model
-	service.errorResponse = &ErrorResponse{}
-	requestConfig.ErrorModel = service.errorResponse
+// HttpRequestContext is HttpRequest honoring the cancellation, deadline and values of ctx
+func (service *Service) HttpRequestContext(ctx context.Context, requestConfig *go_http.RequestConfig) (*http.Request, *http.Response, *errortools.Error) {
+	request, response, errorResponse, googleError, e := service.httpRequest(ctx, requestConfig)
+
+	service.mutex.Lock()
+	service.errorResponse = errorResponse
+	service.googleError = googleError
+	service.mutex.Unlock()
 
-	if service.authorizationMode == authorizationModeOAuth2 {
-		request, response, e = service.oAuth2Service.HttpRequest(requestConfig)
+	return request, response, e
+}
+
+// HttpRequestWithGoogleError sends the request and returns the typed error of this call only.
+// The passed requestConfig is not modified, so it can be used by multiple goroutines at once.
+func (service *Service) HttpRequestWithGoogleError(requestConfig *go_http.RequestConfig) (*http.Request, *http.Response, *GoogleError, *errortools.Error) {
+	return service.HttpRequestWithGoogleErrorContext(context.Background(), requestConfig)
+}
+
+// HttpRequestWithGoogleErrorContext is HttpRequestWithGoogleError honoring the cancellation, deadline and values of ctx
+func (service *Service) HttpRequestWithGoogleErrorContext(ctx context.Context, requestConfig *go_http.RequestConfig) (*http.Request, *http.Response, *GoogleError, *errortools.Error) {
+	request, response, _, googleError, e := service.httpRequest(ctx, requestConfig)
+
+	return request, response, googleError, e
+}
+
+func (service *Service) httpRequest(ctx context.Context, requestConfig *go_http.RequestConfig) (*http.Request, *http.Response, *ErrorResponse, *GoogleError, *errortools.Error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, &ErrorResponse{}, nil, errortools.ErrorMessage(err)
+	}
+
+	if service.autoFieldMask && requestConfig.ResponseModel != nil {
+		_requestConfig := *requestConfig
+		if requestConfig.Parameters != nil {
+			parameters := cloneValues(*requestConfig.Parameters)
+			_requestConfig.Parameters = &parameters
+		}
+
+		// response models that are no struct, e.g. a map, get the complete response
+		_ = SetFieldMask(&_requestConfig)
+		requestConfig = &_requestConfig
+	}
+
+	_requestConfig, cached, raw := service.cacheRequest(requestConfig)
+
+	ctx, span := service.startSpan(ctx, requestConfig.Method, requestConfig.Url)
+
+	start := time.Now()
+	request, response, errorResponse, googleError, attempts, e := service.httpRequestWithRetry(ctx, _requestConfig)
+	service.observeRequest(requestConfig.Method, requestConfig.Url, response, googleError, time.Since(start), attempts)
+
+	fromCache, e := service.cacheResponse(requestConfig, response, cached, raw, e)
+	if fromCache {
+		errorResponse = &ErrorResponse{}
+		googleError = nil
+	}
+
+	if e != nil {
+		endSpan(span, response, googleError, attempts, true, e.Message())
 	} else {
-		if service.authorizationMode == authorizationModeApiKey {
-			// add api key
-			requestConfig.SetParameter("key", *service.apiKey)
-		} else if service.authorizationMode == authorizationModeAccessToken {
-			// add accesstoken to header
-			header := http.Header{}
-			header.Set("Authorization", fmt.Sprintf("Bearer %s", *service.accessToken))
-			requestConfig.NonDefaultHeaders = &header
+		endSpan(span, response, googleError, attempts, false, "")
+	}
+
+	return request, response, errorResponse, googleError, e
+}
+
+// httpRequestWithRetry sends the request according to the retry policy, it also returns the number of attempts
+func (service *Service) httpRequestWithRetry(ctx context.Context, requestConfig *go_http.RequestConfig) (*http.Request, *http.Response, *ErrorResponse, *GoogleError, int, *errortools.Error) {
+	retryPolicy := service.retryPolicy
+	if retryPolicy == nil {
+		retryPolicy = defaultRetryPolicy
+	}
+
+	// an explicit MaxRetries of the request overrides the number of attempts of the policy
+	if requestConfig.MaxRetries != nil {
+		_retryPolicy := *retryPolicy
+		_retryPolicy.MaxAttempts = int(*requestConfig.MaxRetries) + 1
+		retryPolicy = &_retryPolicy
+	}
+
+	for attempt := 1; ; attempt++ {
+		request, response, errorResponse, googleError, e := service.httpRequestAttempt(ctx, requestConfig)
+		if e == nil || request == nil || ctx.Err() != nil {
+			return request, response, errorResponse, googleError, attempt, e
 		}
 
-		request, response, e = service.httpService.HttpRequest(requestConfig)
+		if !retryPolicy.shouldRetry(requestConfig.Method, attempt, response, googleError) {
+			return request, response, errorResponse, googleError, attempt, e
+		}
+
+		delay := retryPolicy.delay(attempt, response, googleError)
+		service.logRetry(ctx, requestConfig, attempt, delay, response, googleError, e)
+
+		select {
+		case <-ctx.Done():
+			return request, response, errorResponse, googleError, attempt, e
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (service *Service) httpRequestAttempt(ctx context.Context, requestConfig *go_http.RequestConfig) (*http.Request, *http.Response, *ErrorResponse, *GoogleError, *errortools.Error) {
+	// work on a copy, the caller's config is left untouched
+	_requestConfig := *requestConfig
+	if requestConfig.Parameters != nil {
+		parameters := cloneValues(*requestConfig.Parameters)
+		_requestConfig.Parameters = &parameters
+	}
+	if requestConfig.NonDefaultHeaders != nil {
+		header := requestConfig.NonDefaultHeaders.Clone()
+		_requestConfig.NonDefaultHeaders = &header
+	}
+
+	// add error model
+	errorResponse := &ErrorResponse{}
+	_requestConfig.ErrorModel = errorResponse
+
+	// retries are done by the Service, go_http would retry without the context and print to stdout
+	maxRetries := uint(0)
+	_requestConfig.MaxRetries = &maxRetries
+
+	e := service.authorize(&_requestConfig)
+	if e != nil {
+		return nil, nil, errorResponse, nil, e
+	}
+
+	// go_http.Service is not safe for concurrent use and does not take a context, so every call gets its own
+	httpClient := contextHttpClient(ctx, loggingHttpClient(ctx, service.log(), service.httpClient))
+	httpService, e := go_http.NewService(&go_http.ServiceConfig{HttpClient: httpClient})
+	if e != nil {
+		return nil, nil, errorResponse, nil, e
+	}
+
+	rateLimiter := findRateLimiter(service.apiName, service.quotaBucket)
+	if rateLimiter != nil {
+		e = rateLimiter.wait(ctx)
+		if e != nil {
+			return nil, nil, errorResponse, nil, e
+		}
+	}
+
+	atomic.AddInt64(&service.requestCount, 1)
+
+	request, response, e := httpService.HttpRequest(&_requestConfig)
+	if e == nil || isNotModified(&_requestConfig, response) {
+		if rateLimiter != nil {
+			rateLimiter.succeeded()
+		}
+		return request, response, errorResponse, nil, nil
+	}
+
+	googleError := NewGoogleError(errorResponse, response)
+	if rateLimiter != nil && googleError != nil && googleError.Status == StatusResourceExhausted {
+		delay := serverDelay(response, googleError)
+		service.log().WarnContext(ctx, "google quota exhausted, slowing down", "api", service.apiName, "bucket", service.quotaBucket, "delay", delay)
+		rateLimiter.exhausted(delay)
+	}
+	setGoogleError(e, errorResponse, googleError)
+
+	return request, response, errorResponse, googleError, e
+}
+
+// isNotModified reports whether response answers a conditional GET with 304 Not Modified, which is no error
+func isNotModified(requestConfig *go_http.RequestConfig, response *http.Response) bool {
+	if response == nil || response.StatusCode != http.StatusNotModified || requestConfig.NonDefaultHeaders == nil {
+		return false
+	}
+
+	return requestConfig.NonDefaultHeaders.Get("If-None-Match") != ""
+}
+
+// setGoogleError adds the message, status, reason and request id of googleError to e
+func setGoogleError(e *errortools.Error, errorResponse *ErrorResponse, googleError *GoogleError) {
+	if googleError == nil {
+		return
+	}
+
+	if errorResponse.Error.Message != "" || len(errorResponse.Error.Errors) > 0 {
+		e.SetMessage(googleError.Message)
+	}
+	e.SetExtra("google_status", googleError.Status)
+	if googleError.Reason != "" {
+		e.SetExtra("google_reason", googleError.Reason)
+	}
+	if googleError.RequestId != "" {
+		e.SetExtra("google_request_id", googleError.RequestId)
+	}
+}
+
+// SetRetryPolicy makes the Service retry failed requests according to retryPolicy, nil restores DefaultRetryPolicy.
+// A RetryPolicy with MaxAttempts 1 disables retries.
+// RequestConfig.MaxRetries, if set, overrides MaxAttempts for that request with MaxRetries+1,
+// go_http itself never retries.
+// Set it before the Service is used by multiple goroutines.
+func (service *Service) SetRetryPolicy(retryPolicy *RetryPolicy) {
+	service.retryPolicy = retryPolicy
+}
+
+// SetQuotaBucket makes the Service share the rate limit of apiName and bucket, e.g. a user, see SetRateLimit.
+// Set it before the Service is used by multiple goroutines.
+func (service *Service) SetQuotaBucket(bucket string) {
+	service.quotaBucket = bucket
+}
+
+// SetAutoFieldMask makes the Service request only the fields of the ResponseModel, see SetFieldMask.
+// Set it before the Service is used by multiple goroutines.
+func (service *Service) SetAutoFieldMask(autoFieldMask bool) {
+	service.autoFieldMask = autoFieldMask
+}
+
+// authorize adds the api key or the bearer token of the Service to requestConfig
+func (service *Service) authorize(requestConfig *go_http.RequestConfig) *errortools.Error {
+	if service.authorizationMode == authorizationModeApiKey {
+		// add api key
+		requestConfig.SetParameter("key", *service.apiKey)
+		return nil
 	}
 
+	accessToken, e := service.bearerToken()
 	if e != nil {
-		if service.errorResponse.Error.Message != "" {
-			e.SetMessage(service.errorResponse.Error.Message)
+		return e
+	}
+
+	// add accesstoken to header
+	if requestConfig.NonDefaultHeaders == nil {
+		requestConfig.NonDefaultHeaders = &http.Header{}
+	}
+	requestConfig.NonDefaultHeaders.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+	return nil
+}
+
+// bearerToken returns a valid access token, oauth2 and token source based tokens are validated by the oauth2 service
+func (service *Service) bearerToken() (string, *errortools.Error) {
+	if service.oAuth2Service == nil {
+		return *service.accessToken, nil
+	}
+
+	if service.impersonatedTokenSource != nil {
+		// go_oauth2 validates tokens under a global lock, the source token cannot be validated inside it
+		e := service.impersonatedTokenSource.ResolveSourceToken()
+		if e != nil {
+			return "", e
 		}
 	}
 
+	token, e := service.oAuth2Service.ValidateToken()
 	if e != nil {
-		return request, response, e
+		return "", e
 	}
 
-	return request, response, nil
+	return *token.AccessToken, nil
+}
+
+// contextTransport sends every request with ctx
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(request.WithContext(t.ctx))
+}
+
+// contextHttpClient returns a copy of httpClient that sends its requests with ctx
+func contextHttpClient(ctx context.Context, httpClient *http.Client) *http.Client {
+	client := *httpClient
+
+	base := httpClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = &contextTransport{ctx: ctx, base: base}
+
+	return &client
+}
+
+func cloneValues(values url.Values) url.Values {
+	_values := url.Values{}
+	for key, value := range values {
+		_values[key] = append([]string{}, value...)
+	}
+
+	return _values
 }
 
 func (service *Service) AuthorizeUrl(scope string, accessType *string, prompt *string, state *string) string {
@@ -192,10 +715,27 @@ func (service *Service) ValidateToken() (*go_token.Token, *errortools.Error) {
 	return service.oAuth2Service.ValidateToken()
 }
 
+// GetTokenFromCode exchanges the code of the redirect r for a token.
+// With a CodeVerifierStore, configured or created by AuthorizeUrlWithPkce, it sends the code verifier of the state
+// of the redirect and fails if there is none.
 func (service *Service) GetTokenFromCode(r *http.Request) *errortools.Error {
+	data, e := service.codeVerifierData(r)
+	if e != nil {
+		return e
+	}
+
+	if data != nil {
+		return service.oAuth2Service.GetTokenFromCodeWithData(r, data, nil)
+	}
+
 	return service.oAuth2Service.GetTokenFromCode(r, nil)
 }
 
+// CredentialsSource returns where the Application Default Credentials of the Service were found
+func (service *Service) CredentialsSource() string {
+	return service.credentialsSource
+}
+
 func (service *Service) ApiName() string {
 	return service.apiName
 }
@@ -204,20 +744,13 @@ func (service *Service) ApiKey() string {
 	return clientIdShort(service.clientId)
 }
 
+// ApiCallCount returns the number of requests sent, including retries, see SetMetricsCollector for details per endpoint
 func (service *Service) ApiCallCount() int64 {
-	if service.authorizationMode == authorizationModeOAuth2 {
-		return service.oAuth2Service.ApiCallCount()
-	} else {
-		return service.httpService.RequestCount()
-	}
+	return atomic.LoadInt64(&service.requestCount)
 }
 
 func (service *Service) ApiReset() {
-	if service.authorizationMode == authorizationModeOAuth2 {
-		service.oAuth2Service.ApiReset()
-	} else {
-		service.httpService.ResetRequestCount()
-	}
+	atomic.StoreInt64(&service.requestCount, 0)
 }
 
 func clientIdShort(clientId string) string {
@@ -225,5 +758,16 @@ func clientIdShort(clientId string) string {
 }
 
 func (service *Service) ErrorResponse() *ErrorResponse {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
 	return service.errorResponse
 }
+
+// GoogleError returns the typed error of the last failed HttpRequest, nil if it succeeded
+func (service *Service) GoogleError() *GoogleError {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	return service.googleError
+}
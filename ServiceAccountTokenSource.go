@@ -0,0 +1,186 @@
+package google
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	credentials "github.com/leapforce-libraries/go_google/credentials"
+	go_token "github.com/leapforce-libraries/go_oauth2/token"
+)
+
+const (
+	jwtBearerGrantType string        = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	jwtLifetime        time.Duration = time.Hour
+)
+
+// ServiceAccountTokenSource retrieves access tokens by exchanging a JWT assertion signed with a service account key
+type ServiceAccountTokenSource struct {
+	tokenCache
+	clientEmail  string
+	privateKeyId string
+	privateKey   *rsa.PrivateKey
+	tokenUrl     string
+	scopes       []string
+	subject      string
+	httpClient   *http.Client
+}
+
+type ServiceAccountTokenSourceConfig struct {
+	CredentialsJson *credentials.CredentialsJson
+	Scopes          []string
+	Subject         string // user to impersonate through domain-wide delegation
+	HttpClient      *http.Client
+}
+
+func NewServiceAccountTokenSource(cfg *ServiceAccountTokenSourceConfig) (*ServiceAccountTokenSource, *errortools.Error) {
+	if cfg == nil {
+		return nil, errortools.ErrorMessage("ServiceAccountTokenSourceConfig must not be a nil pointer")
+	}
+
+	if cfg.CredentialsJson == nil {
+		return nil, errortools.ErrorMessage("CredentialsJson not provided")
+	}
+
+	if cfg.CredentialsJson.ClientEmail == "" {
+		return nil, errortools.ErrorMessage("ClientEmail not provided")
+	}
+
+	if len(cfg.Scopes) == 0 {
+		return nil, errortools.ErrorMessage("Scopes not provided")
+	}
+
+	privateKey, e := parsePrivateKey(cfg.CredentialsJson.PrivateKey)
+	if e != nil {
+		return nil, e
+	}
+
+	_tokenUrl := tokenUrl
+	if cfg.CredentialsJson.TokenUri != "" {
+		_tokenUrl = cfg.CredentialsJson.TokenUri
+	}
+
+	return &ServiceAccountTokenSource{
+		clientEmail:  cfg.CredentialsJson.ClientEmail,
+		privateKeyId: cfg.CredentialsJson.PrivateKeyId,
+		privateKey:   privateKey,
+		tokenUrl:     _tokenUrl,
+		scopes:       cfg.Scopes,
+		subject:      cfg.Subject,
+		httpClient:   cfg.HttpClient,
+	}, nil
+}
+
+// WithSubject returns a token source for the same key impersonating subject, with its own token cache
+func (t *ServiceAccountTokenSource) WithSubject(subject string) *ServiceAccountTokenSource {
+	return &ServiceAccountTokenSource{
+		clientEmail:  t.clientEmail,
+		privateKeyId: t.privateKeyId,
+		privateKey:   t.privateKey,
+		tokenUrl:     t.tokenUrl,
+		scopes:       t.scopes,
+		subject:      subject,
+		httpClient:   t.httpClient,
+	}
+}
+
+func (t *ServiceAccountTokenSource) Subject() string {
+	return t.subject
+}
+
+// NewToken signs a new JWT assertion and exchanges it for an access token
+func (t *ServiceAccountTokenSource) NewToken() (*go_token.Token, *errortools.Error) {
+	assertion, e := t.assertion(time.Now())
+	if e != nil {
+		return nil, e
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", jwtBearerGrantType)
+	data.Set("assertion", assertion)
+
+	b, e := postTokenRequest(t.httpClient, t.tokenUrl, data, nil)
+	if e != nil {
+		return nil, e
+	}
+
+	return t.UnmarshalToken(b)
+}
+
+func (t *ServiceAccountTokenSource) assertion(now time.Time) (string, *errortools.Error) {
+	header := map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+	}
+	if t.privateKeyId != "" {
+		header["kid"] = t.privateKeyId
+	}
+
+	claims := map[string]interface{}{
+		"iss":   t.clientEmail,
+		"scope": strings.Join(t.scopes, " "),
+		"aud":   t.tokenUrl,
+		"iat":   now.Unix(),
+		"exp":   now.Add(jwtLifetime).Unix(),
+	}
+	if t.subject != "" {
+		claims["sub"] = t.subject
+	}
+
+	headerJson, err := json.Marshal(header)
+	if err != nil {
+		return "", errortools.ErrorMessage(err)
+	}
+
+	claimsJson, err := json.Marshal(claims)
+	if err != nil {
+		return "", errortools.ErrorMessage(err)
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(headerJson) + "." + base64.RawURLEncoding.EncodeToString(claimsJson)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, t.privateKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", errortools.ErrorMessage(err)
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func parsePrivateKey(privateKey string) (*rsa.PrivateKey, *errortools.Error) {
+	if privateKey == "" {
+		return nil, errortools.ErrorMessage("PrivateKey not provided")
+	}
+
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return nil, errortools.ErrorMessage("PrivateKey is not PEM encoded")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		pkcs1Key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errortools.ErrorMessage(err)
+		}
+		return pkcs1Key, nil
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errortools.ErrorMessage("PrivateKey is not an RSA key")
+	}
+
+	return rsaKey, nil
+}
@@ -0,0 +1,333 @@
+package google
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	credentials "github.com/leapforce-libraries/go_google/credentials"
+	testserver "github.com/leapforce-libraries/go_google/internal/testserver"
+	go_http "github.com/leapforce-libraries/go_http"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// TestConcurrentHttpRequest shares one Service between goroutines, run it with -race.
+// Tokens are valid for half a second and the requests come in bursts that each refresh the token,
+// every fifth item is rate limited once so the rate limiter slows down and recovers.
+func TestConcurrentHttpRequest(t *testing.T) {
+	const apiName = "concurrency-test"
+	const requestCount = 60
+
+	var server *testserver.Server
+	server = testserver.New(t, map[string]http.HandlerFunc{
+		"/token": func(w http.ResponseWriter, r *http.Request) {
+			testserver.WriteJson(w, http.StatusOK, fmt.Sprintf(`{"access_token":"token-%v","expires_in":1,"token_type":"Bearer"}`, len(server.Requests("/token"))))
+		},
+		"/v1/items/{id}": func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token-") {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			id, err := strconv.Atoi(r.PathValue("id"))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			switch {
+			case id%5 == 0 && len(server.Requests(r.URL.Path)) == 1:
+				testserver.WriteJson(w, http.StatusTooManyRequests, fmt.Sprintf(`{"error":{"code":429,"message":"quota of item %v","status":"RESOURCE_EXHAUSTED","details":[{"@type":"type.googleapis.com/google.rpc.RetryInfo","retryDelay":"0.01s"}]}}`, id))
+			case id%2 == 1:
+				testserver.WriteJson(w, http.StatusNotFound, fmt.Sprintf(`{"error":{"code":404,"message":"item %v not found","status":"NOT_FOUND"}}`, id))
+			default:
+				testserver.WriteJson(w, http.StatusOK, fmt.Sprintf(`{"id":"%v"}`, id))
+			}
+		},
+	})
+
+	tokenSource, e := NewAuthorizedUserTokenSource(&AuthorizedUserTokenSourceConfig{
+		CredentialsJson: &credentials.CredentialsJson{
+			Type:         credentials.TypeAuthorizedUser,
+			ClientId:     "client-id",
+			RefreshToken: "refresh-token",
+			TokenUri:     server.URL + "/token",
+		},
+	})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	refreshMargin := 500 * time.Millisecond
+	service, e := newServiceWithTokenSource(apiName, authorizationModeAuthorizedUser, "client-id", tokenSource, &refreshMargin)
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	retryPolicy := DefaultRetryPolicy()
+	retryPolicy.InitialBackoff = time.Millisecond
+	service.SetRetryPolicy(retryPolicy)
+
+	e = SetRateLimit(apiName, "", &RateLimitConfig{RequestsPerMinute: 600000, Burst: 20})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	defer SetRateLimit(apiName, "", nil)
+
+	var wg sync.WaitGroup
+	for id := 0; id < requestCount; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+
+			// three bursts of concurrent requests, each burst needs a new token
+			time.Sleep(time.Duration(id/20) * 600 * time.Millisecond)
+
+			item := struct {
+				Id string `json:"id"`
+			}{}
+			requestConfig := go_http.RequestConfig{
+				Url:           fmt.Sprintf("%s/v1/items/%v", server.URL, id),
+				ResponseModel: &item,
+			}
+
+			if id%4 < 2 {
+				_, _, googleError, e := service.HttpRequestWithGoogleError(&requestConfig)
+				checkItemResult(t, id, item.Id, googleError, e == nil)
+				return
+			}
+
+			_, _, e := service.HttpRequest(&requestConfig)
+			if id%2 == 1 {
+				if e == nil || !strings.Contains(e.Message(), fmt.Sprintf("item %v not found", id)) {
+					t.Errorf("item %v: expected its own not found error", id)
+				}
+			} else if e != nil || item.Id != strconv.Itoa(id) {
+				t.Errorf("item %v: unexpected result %q", id, item.Id)
+			}
+
+			// the last error of the Service is shared, it may belong to any goroutine
+			_ = service.GoogleError()
+			_ = service.ErrorResponse()
+		}(id)
+	}
+	wg.Wait()
+
+	if tokenCount := len(server.Requests("/token")); tokenCount < 2 {
+		t.Errorf("token requested %v times, expected it to be refreshed", tokenCount)
+	}
+	if service.ApiCallCount() != requestCount+requestCount/5 {
+		t.Errorf("ApiCallCount is %v, expected %v", service.ApiCallCount(), requestCount+requestCount/5)
+	}
+}
+
+func checkItemResult(t *testing.T, id int, itemId string, googleError *GoogleError, ok bool) {
+	if id%2 == 0 {
+		if !ok || googleError != nil || itemId != strconv.Itoa(id) {
+			t.Errorf("item %v: unexpected result %q, %v", id, itemId, googleError)
+		}
+		return
+	}
+
+	if ok || googleError == nil || !googleError.IsNotFound() || googleError.Message != fmt.Sprintf("item %v not found", id) {
+		t.Errorf("item %v: expected its own not found error, got %v", id, googleError)
+	}
+}
+
+// TestDefaultRetryPolicy checks that a Service without RetryPolicy retries by itself, go_http does not retry,
+// and that the context stops the backoff
+func TestDefaultRetryPolicy(t *testing.T) {
+	unavailable := func(w http.ResponseWriter, r *http.Request) {
+		testserver.WriteJson(w, http.StatusServiceUnavailable, `{"error":{"code":503,"message":"Backend unavailable","status":"UNAVAILABLE","details":[{"@type":"type.googleapis.com/google.rpc.RetryInfo","retryDelay":"0.01s"}]}}`)
+	}
+
+	var server *testserver.Server
+	server = testserver.New(t, map[string]http.HandlerFunc{
+		"/v1/flaky": func(w http.ResponseWriter, r *http.Request) {
+			if len(server.Requests("/v1/flaky")) <= 2 {
+				unavailable(w, r)
+				return
+			}
+			testserver.WriteJson(w, http.StatusOK, `{"id":"flaky"}`)
+		},
+		"/v1/down": unavailable,
+	})
+
+	service, e := NewServiceWithAccessToken(&ServiceWithAccessTokenConfig{AccessToken: "access-token"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	_, _, e = service.HttpRequest(&go_http.RequestConfig{Url: server.URL + "/v1/flaky"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	if requestCount := len(server.Requests("/v1/flaky")); requestCount != 3 || service.ApiCallCount() != 3 {
+		t.Errorf("%v requests sent for %v attempts, expected 3", requestCount, service.ApiCallCount())
+	}
+
+	_, _, e = service.HttpRequest(&go_http.RequestConfig{Url: server.URL + "/v1/down"})
+	if e == nil {
+		t.Fatal("expected an error")
+	}
+	if requestCount := len(server.Requests("/v1/down")); requestCount != defaultRetryMaxAttempts {
+		t.Errorf("%v requests sent, expected %v", requestCount, defaultRetryMaxAttempts)
+	}
+
+	// the context ends the backoff
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	service.SetRetryPolicy(&RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Minute, RetryStatusCodes: []int{http.StatusServiceUnavailable}})
+	_, _, e = service.HttpRequestContext(ctx, &go_http.RequestConfig{Url: server.URL + "/v1/down"})
+	if e == nil || time.Since(start) > 5*time.Second {
+		t.Errorf("request returned %v after %v, expected the context to stop the backoff", e, time.Since(start))
+	}
+
+	// MaxAttempts 1 disables retries
+	requestCount := len(server.Requests("/v1/down"))
+	service.SetRetryPolicy(&RetryPolicy{MaxAttempts: 1})
+	_, _, e = service.HttpRequest(&go_http.RequestConfig{Url: server.URL + "/v1/down"})
+	if sent := len(server.Requests("/v1/down")) - requestCount; e == nil || sent != 1 {
+		t.Errorf("%v requests sent, expected 1", sent)
+	}
+}
+
+func TestRequestMaxRetries(t *testing.T) {
+	server := testserver.New(t, map[string]http.HandlerFunc{
+		"/v1/down": func(w http.ResponseWriter, r *http.Request) {
+			testserver.WriteJson(w, http.StatusServiceUnavailable, `{"error":{"code":503,"message":"Backend unavailable","status":"UNAVAILABLE","details":[{"@type":"type.googleapis.com/google.rpc.RetryInfo","retryDelay":"0.01s"}]}}`)
+		},
+	})
+
+	service, e := NewServiceWithAccessToken(&ServiceWithAccessTokenConfig{AccessToken: "access-token"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	// MaxRetries of the request overrides MaxAttempts of the default and of a custom policy
+	for _, retryPolicy := range []*RetryPolicy{nil, {MaxAttempts: 1, RetryStatusCodes: []int{http.StatusServiceUnavailable}}} {
+		service.SetRetryPolicy(retryPolicy)
+
+		for _, maxRetries := range []uint{0, 2} {
+			requestCount := len(server.Requests("/v1/down"))
+
+			_, _, e = service.HttpRequest(&go_http.RequestConfig{Url: server.URL + "/v1/down", MaxRetries: &maxRetries})
+			if e == nil {
+				t.Fatal("expected an error")
+			}
+			if sent := len(server.Requests("/v1/down")) - requestCount; sent != int(maxRetries)+1 {
+				t.Errorf("%v requests sent with MaxRetries %v, expected %v", sent, maxRetries, maxRetries+1)
+			}
+		}
+	}
+
+	if service.retryPolicy.MaxAttempts != 1 {
+		t.Errorf("MaxAttempts of the RetryPolicy changed to %v", service.retryPolicy.MaxAttempts)
+	}
+}
+
+func TestWithSubject(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := testserver.New(t, map[string]http.HandlerFunc{
+		"/token": func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			claims := struct {
+				Sub string `json:"sub"`
+			}{}
+			payload, err := base64.RawURLEncoding.DecodeString(strings.Split(r.PostForm.Get("assertion"), ".")[1])
+			if err == nil {
+				err = json.Unmarshal(payload, &claims)
+			}
+			if err != nil {
+				t.Errorf("assertion: %v", err)
+			}
+			testserver.WriteJson(w, http.StatusOK, fmt.Sprintf(`{"access_token":"token-%s","expires_in":3600,"token_type":"Bearer"}`, claims.Sub))
+		},
+		"/v1/things": func(w http.ResponseWriter, r *http.Request) {
+			testserver.WriteJson(w, http.StatusOK, fmt.Sprintf(`{"id":%q}`, r.Header.Get("Authorization")))
+		},
+	})
+
+	service, e := NewServiceWithServiceAccount(&ServiceWithServiceAccountConfig{
+		ApiName: "subject-test",
+		CredentialsJson: &credentials.CredentialsJson{
+			Type:        credentials.TypeServiceAccount,
+			ClientEmail: "sa@project.iam.gserviceaccount.com",
+			ClientId:    "client-id",
+			PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})),
+			TokenUri:    server.URL + "/token",
+		},
+		Scopes:  []string{cloudPlatformScope},
+		Subject: "admin@example.com",
+	})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	collector := recordingCollector{}
+	service.SetRetryPolicy(&RetryPolicy{MaxAttempts: 2})
+	service.SetQuotaBucket("bucket")
+	service.SetAutoFieldMask(true)
+	service.SetResponseCache(&ResponseCacheConfig{Cache: NewMemoryResponseCache(nil)})
+	service.SetMetricsCollector(&collector)
+	service.SetTracerProvider(noop.NewTracerProvider())
+	service.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	subjectService, e := service.WithSubject("user@example.com")
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+
+	if subjectService.retryPolicy != service.retryPolicy || subjectService.quotaBucket != "bucket" || !subjectService.autoFieldMask ||
+		subjectService.responseCacheConfig != service.responseCacheConfig || subjectService.metricsCollector != service.metricsCollector ||
+		subjectService.tracerProvider != service.tracerProvider || subjectService.logger != service.logger || subjectService.httpClient != service.httpClient {
+		t.Error("the Service returned by WithSubject lost settings of its parent")
+	}
+
+	// each Service has its own token for its own subject
+	for _, test := range []struct {
+		service       *Service
+		authorization string
+	}{
+		{service, "Bearer token-admin@example.com"},
+		{subjectService, "Bearer token-user@example.com"},
+		{service, "Bearer token-admin@example.com"},
+	} {
+		thing := struct {
+			Id string `json:"id"`
+		}{}
+		_, _, e := test.service.HttpRequest(&go_http.RequestConfig{Url: server.URL + "/v1/things", ResponseModel: &thing})
+		if e != nil {
+			t.Fatal(e.Message())
+		}
+		if thing.Id != test.authorization {
+			t.Errorf("request authorized with %q, expected %q", thing.Id, test.authorization)
+		}
+	}
+
+	if tokenCount := len(server.Requests("/token")); tokenCount != 2 {
+		t.Errorf("token requested %v times, expected once per subject", tokenCount)
+	}
+	if len(collector.metrics) != 3 {
+		t.Errorf("%v requests observed, expected the metrics collector to be shared", len(collector.metrics))
+	}
+}
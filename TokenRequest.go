@@ -0,0 +1,118 @@
+package google
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+	go_token "github.com/leapforce-libraries/go_oauth2/token"
+)
+
+// tokenCache keeps a token in memory, it implements the non-generating part of tokensource.TokenSource
+type tokenCache struct {
+	mutex sync.Mutex
+	token *go_token.Token
+}
+
+func (c *tokenCache) Token() *go_token.Token {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.token
+}
+
+func (c *tokenCache) SetToken(token *go_token.Token, save bool) *errortools.Error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.token = token
+
+	return nil
+}
+
+func (c *tokenCache) RetrieveToken() *errortools.Error {
+	return nil
+}
+
+func (c *tokenCache) SaveToken() *errortools.Error {
+	return nil
+}
+
+func (c *tokenCache) UnmarshalToken(b []byte) (*go_token.Token, *errortools.Error) {
+	token := go_token.Token{}
+
+	err := json.Unmarshal(b, &token)
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+
+	return &token, nil
+}
+
+type tokenErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// postTokenRequest posts url encoded data to a token endpoint and returns the raw response body
+func postTokenRequest(httpClient *http.Client, tokenUrl string, data url.Values, header *http.Header) ([]byte, *errortools.Error) {
+	request, err := http.NewRequest(http.MethodPost, tokenUrl, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if header != nil {
+		for key, values := range *header {
+			request.Header.Del(key)
+			for _, value := range values {
+				request.Header.Add(key, value)
+			}
+		}
+	}
+
+	return doTokenRequest(httpClient, request)
+}
+
+// doTokenRequest sends a request to a token endpoint and returns the raw response body
+func doTokenRequest(httpClient *http.Client, request *http.Request) ([]byte, *errortools.Error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	e := new(errortools.Error)
+	e.SetRequest(request)
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		e.SetMessage(err)
+		return nil, e
+	}
+	defer response.Body.Close()
+	e.SetResponse(response)
+
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		e.SetMessage(err)
+		return nil, e
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		tokenError := tokenErrorResponse{}
+		_ = json.Unmarshal(b, &tokenError)
+
+		if tokenError.Error != "" {
+			e.SetMessagef("Token request returned statuscode %v: %s %s", response.StatusCode, tokenError.Error, tokenError.ErrorDescription)
+		} else {
+			e.SetMessagef("Token request returned statuscode %v", response.StatusCode)
+			e.SetExtra("response_message", string(b))
+		}
+		return nil, e
+	}
+
+	return b, nil
+}
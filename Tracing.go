@@ -0,0 +1,75 @@
+package google
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName string = "github.com/leapforce-libraries/go_google"
+
+// SetTracerProvider makes the Service create its spans with tracerProvider instead of the global otel TracerProvider.
+// Set it before the Service is used by multiple goroutines.
+func (service *Service) SetTracerProvider(tracerProvider trace.TracerProvider) {
+	service.tracerProvider = tracerProvider
+}
+
+func (service *Service) tracer() trace.Tracer {
+	tracerProvider := service.tracerProvider
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+
+	return tracerProvider.Tracer(tracerName)
+}
+
+// startSpan starts the client span of a request, it is named after the method and the url template
+func (service *Service) startSpan(ctx context.Context, method string, _url string) (context.Context, trace.Span) {
+	if method == "" {
+		method = http.MethodGet
+	}
+	urlTemplate := UrlTemplate(_url)
+
+	return service.tracer().Start(ctx, method+" "+urlTemplate,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("google.api_name", service.apiName),
+			attribute.String("http.request.method", method),
+			attribute.String("url.template", urlTemplate),
+		),
+	)
+}
+
+// endSpan records the outcome of the request on span and ends it
+func endSpan(span trace.Span, response *http.Response, googleError *GoogleError, attempts int, failed bool, message string) {
+	if response != nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", response.StatusCode))
+	}
+	if attempts > 1 {
+		span.SetAttributes(attribute.Int("google.retries", attempts-1))
+	}
+	requestId := ""
+	if googleError != nil {
+		span.SetAttributes(attribute.String("google.status", googleError.Status))
+		if googleError.Reason != "" {
+			span.SetAttributes(attribute.String("google.reason", googleError.Reason))
+		}
+		requestId = googleError.RequestId
+	}
+	// successful responses only have the request id in their headers
+	if requestId == "" && response != nil {
+		requestId = requestIdFromHeader(response.Header)
+	}
+	if requestId != "" {
+		span.SetAttributes(attribute.String("google.request_id", requestId))
+	}
+	if failed {
+		span.SetStatus(codes.Error, message)
+	}
+
+	span.End()
+}
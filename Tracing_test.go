@@ -0,0 +1,98 @@
+package google
+
+import (
+	"net/http"
+	"testing"
+
+	testserver "github.com/leapforce-libraries/go_google/internal/testserver"
+	testtrace "github.com/leapforce-libraries/go_google/internal/testtrace"
+	go_http "github.com/leapforce-libraries/go_http"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	var server *testserver.Server
+	server = testserver.New(t, map[string]http.HandlerFunc{
+		"/v1/things/{id}": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Goog-Request-Id", "request-"+r.PathValue("id"))
+
+			switch r.PathValue("id") {
+			case "missing-1":
+				testserver.WriteJson(w, http.StatusNotFound, `{"error":{"code":404,"message":"Thing not found","status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.rpc.ErrorInfo","reason":"THING_NOT_FOUND","domain":"example.googleapis.com"},{"@type":"type.googleapis.com/google.rpc.RequestInfo","requestId":"detail-request-id"}]}}`)
+			case "flaky-1":
+				if len(server.Requests("/v1/things/flaky-1")) == 1 {
+					testserver.WriteJson(w, http.StatusServiceUnavailable, `{"error":{"code":503,"message":"Backend unavailable","status":"UNAVAILABLE","details":[{"@type":"type.googleapis.com/google.rpc.RetryInfo","retryDelay":"0.01s"}]}}`)
+					return
+				}
+				testserver.WriteJson(w, http.StatusOK, `{"id":"flaky"}`)
+			default:
+				testserver.WriteJson(w, http.StatusOK, `{"id":"thing"}`)
+			}
+		},
+	})
+
+	tracerProvider := testtrace.NewTracerProvider()
+
+	service, e := NewServiceWithAccessToken(&ServiceWithAccessTokenConfig{ApiName: "example", AccessToken: "access-token"})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	service.SetTracerProvider(tracerProvider)
+
+	tests := []struct {
+		id         string
+		method     string
+		statusCode string
+		status     string
+		reason     string
+		requestId  string
+		retries    string
+		failed     bool
+	}{
+		{"thing-1", http.MethodGet, "200", "", "", "request-thing-1", "", false},
+		{"thing-2", http.MethodPut, "200", "", "", "request-thing-2", "", false},
+		{"missing-1", http.MethodGet, "404", StatusNotFound, "THING_NOT_FOUND", "detail-request-id", "", true},
+		{"flaky-1", http.MethodGet, "200", "", "", "request-flaky-1", "1", false},
+	}
+
+	for _, test := range tests {
+		_, _, e = service.HttpRequest(&go_http.RequestConfig{Method: test.method, Url: server.URL + "/v1/things/" + test.id})
+		if (e != nil) != test.failed {
+			t.Fatalf("request to %s returned %v", test.id, e)
+		}
+	}
+
+	spans := tracerProvider.Spans()
+	if len(spans) != len(tests) {
+		t.Fatalf("%v spans, expected %v", len(spans), len(tests))
+	}
+
+	for i, test := range tests {
+		span := spans[i]
+
+		if span.Name != test.method+" /v1/things/{id}" || span.Kind != trace.SpanKindClient || !span.Ended {
+			t.Errorf("span %v is %q of kind %v, ended %v", i, span.Name, span.Kind, span.Ended)
+		}
+
+		attributes := map[string]string{
+			"google.api_name":           "example",
+			"http.request.method":       test.method,
+			"url.template":              "/v1/things/{id}",
+			"http.response.status_code": test.statusCode,
+			"google.status":             test.status,
+			"google.reason":             test.reason,
+			"google.request_id":         test.requestId,
+			"google.retries":            test.retries,
+		}
+		for key, value := range attributes {
+			if span.Attribute(key) != value {
+				t.Errorf("span %s has %s %q, expected %q", test.id, key, span.Attribute(key), value)
+			}
+		}
+
+		if (span.StatusCode == codes.Error) != test.failed {
+			t.Errorf("span %s has status %v: %s", test.id, span.StatusCode, span.StatusMessage)
+		}
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"reflect"
 	"strings"
 	"time"
@@ -12,6 +12,9 @@ import (
 	errortools "github.com/leapforce-libraries/go_errortools"
 	credentials "github.com/leapforce-libraries/go_google/credentials"
 	types "github.com/leapforce-libraries/go_types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/civil"
@@ -55,13 +58,20 @@ func (sqlConfig *SqlConfig) FullTableName() string {
 
 // Service stores context of Service object
 type Service struct {
-	bigQueryClient *bigquery.Client
-	context        context.Context
+	bigQueryClient    *bigquery.Client
+	context           context.Context
+	credentialsSource string
+	tracer            trace.Tracer
+	logger            *slog.Logger
 }
 
 type ServiceConfig struct {
-	CredentialsJson *credentials.CredentialsJson
-	ProjectId       string
+	CredentialsJson          *credentials.CredentialsJson // service_account or external_account credentials
+	ProjectId                string
+	UseDefaultCredentials    bool // use Application Default Credentials if CredentialsJson is not provided
+	DefaultCredentialsConfig *credentials.DefaultCredentialsConfig
+	TracerProvider           trace.TracerProvider // defaults to the global otel TracerProvider
+	Logger                   *slog.Logger         // progress and errors of jobs, credentials are redacted, nil discards logging
 }
 
 func NewService(serviceConfig *ServiceConfig) (*Service, *errortools.Error) {
@@ -69,41 +79,85 @@ func NewService(serviceConfig *ServiceConfig) (*Service, *errortools.Error) {
 		return nil, errortools.ErrorMessage("ServiceConfig is nil pointer")
 	}
 
-	if serviceConfig.CredentialsJson == nil {
-		return nil, errortools.ErrorMessage("CredentialsJson not provided")
+	credentialsJson := serviceConfig.CredentialsJson
+	credentialsSource := ""
+
+	if credentialsJson == nil {
+		if !serviceConfig.UseDefaultCredentials {
+			return nil, errortools.ErrorMessage("CredentialsJson not provided")
+		}
+
+		defaultCredentials, e := credentials.FindDefaultCredentials(serviceConfig.DefaultCredentialsConfig)
+		if e != nil {
+			return nil, e
+		}
+
+		// CredentialsJson remains nil for the metadata server, the BigQuery client finds it itself
+		credentialsJson = defaultCredentials.CredentialsJson
+		credentialsSource = defaultCredentials.String()
+	}
+
+	projectId := serviceConfig.ProjectId
+	if projectId == "" && credentialsJson != nil {
+		projectId = credentialsJson.ProjectId
+		if projectId == "" {
+			projectId = credentialsJson.QuotaProjectId
+		}
 	}
 
-	if serviceConfig.ProjectId == "" {
+	if projectId == "" {
 		return nil, errortools.ErrorMessage("ProjectId not provided")
 	}
 
 	ctx := context.Background()
 
-	credentialsByte, err := json.Marshal(serviceConfig.CredentialsJson)
-	if err != nil {
-		return nil, errortools.ErrorMessage(err)
+	var options []option.ClientOption
+	if credentialsJson != nil {
+		credentialsByte, err := json.Marshal(credentialsJson)
+		if err != nil {
+			return nil, errortools.ErrorMessage(err)
+		}
+		options = append(options, option.WithCredentialsJSON(credentialsByte))
 	}
 
-	client, err := bigquery.NewClient(ctx, serviceConfig.ProjectId, option.WithCredentialsJSON(credentialsByte))
+	client, err := bigquery.NewClient(ctx, projectId, options...)
 	if err != nil {
 		return nil, errortools.ErrorMessage(err)
 	}
 
+	tracerProvider := serviceConfig.TracerProvider
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+
 	return &Service{
-		bigQueryClient: client,
-		context:        ctx,
+		bigQueryClient:    client,
+		context:           ctx,
+		credentialsSource: credentialsSource,
+		tracer:            tracerProvider.Tracer(tracerName),
+		logger:            credentials.NewRedactLogger(serviceConfig.Logger),
 	}, nil
 }
 
+// CredentialsSource returns where the Application Default Credentials were found, empty if CredentialsJson was provided
+func (service *Service) CredentialsSource() string {
+	return service.credentialsSource
+}
+
 func (service *Service) GetTables(sqlConfig *SqlConfig) (*[]bigquery.Table, *errortools.Error) {
-	dataset, e := service.getDataset(sqlConfig)
+	return service.GetTablesContext(service.context, sqlConfig)
+}
+
+// GetTablesContext is GetTables honoring the cancellation and deadline of ctx
+func (service *Service) GetTablesContext(ctx context.Context, sqlConfig *SqlConfig) (*[]bigquery.Table, *errortools.Error) {
+	dataset, e := service.getDataset(ctx, sqlConfig)
 	if e != nil {
 		return nil, e
 	}
 
 	tables := []bigquery.Table{}
 
-	it := dataset.Tables(service.context)
+	it := dataset.Tables(ctx)
 
 	for {
 		table, err := it.Next()
@@ -111,7 +165,7 @@ func (service *Service) GetTables(sqlConfig *SqlConfig) (*[]bigquery.Table, *err
 			break
 		}
 		if err != nil {
-			log.Fatal(err)
+			return nil, errortools.ErrorMessage(err)
 		}
 
 		if table != nil {
@@ -123,16 +177,21 @@ func (service *Service) GetTables(sqlConfig *SqlConfig) (*[]bigquery.Table, *err
 }
 
 func (service *Service) TableExists(sqlConfig *SqlConfig) (bool, *errortools.Error) {
-	dataset, tableHandle, e := service.getTableHandle(sqlConfig)
+	return service.TableExistsContext(service.context, sqlConfig)
+}
+
+// TableExistsContext is TableExists honoring the cancellation and deadline of ctx
+func (service *Service) TableExistsContext(ctx context.Context, sqlConfig *SqlConfig) (bool, *errortools.Error) {
+	dataset, tableHandle, e := service.getTableHandle(ctx, sqlConfig)
 	if e != nil {
 		return false, e
 	}
 
-	return service.tableExists(dataset, tableHandle)
+	return service.tableExists(ctx, dataset, tableHandle)
 }
 
-func (service *Service) tableExists(dataset *bigquery.Dataset, tableHandle *bigquery.Table) (bool, *errortools.Error) {
-	it := dataset.Tables(service.context)
+func (service *Service) tableExists(ctx context.Context, dataset *bigquery.Dataset, tableHandle *bigquery.Table) (bool, *errortools.Error) {
+	it := dataset.Tables(ctx)
 
 	for {
 		table, err := it.Next()
@@ -140,7 +199,7 @@ func (service *Service) tableExists(dataset *bigquery.Dataset, tableHandle *bigq
 			break
 		}
 		if err != nil {
-			log.Fatal(err)
+			return false, errortools.ErrorMessage(err)
 		}
 		if table.TableID == tableHandle.TableID {
 			return true, nil
@@ -150,24 +209,24 @@ func (service *Service) tableExists(dataset *bigquery.Dataset, tableHandle *bigq
 	return false, nil
 }
 
-func (service *Service) getDataset(sqlConfig *SqlConfig) (*bigquery.Dataset, *errortools.Error) {
+func (service *Service) getDataset(ctx context.Context, sqlConfig *SqlConfig) (*bigquery.Dataset, *errortools.Error) {
 	dataset := service.bigQueryClient.Dataset(sqlConfig.DatasetName)
 
-	_, err := dataset.Metadata(service.context)
+	_, err := dataset.Metadata(ctx)
 	if err != nil {
-		fmt.Println(err)
+		service.logger.DebugContext(ctx, "reading dataset metadata failed", "dataset", sqlConfig.DatasetName, "error", err)
 		return nil, errortools.ErrorMessage(fmt.Sprintf("Dataset %s does not exist.", sqlConfig.DatasetName))
 	}
 
 	return dataset, nil
 }
 
-func (service *Service) getTableHandle(sqlConfig *SqlConfig) (*bigquery.Dataset, *bigquery.Table, *errortools.Error) {
+func (service *Service) getTableHandle(ctx context.Context, sqlConfig *SqlConfig) (*bigquery.Dataset, *bigquery.Table, *errortools.Error) {
 	if sqlConfig.TableOrViewName == nil {
 		return nil, nil, errortools.ErrorMessage("TableOrViewName is nil pointer")
 	}
 
-	dataset, e := service.getDataset(sqlConfig)
+	dataset, e := service.getDataset(ctx, sqlConfig)
 	if e != nil {
 		return nil, nil, errortools.ErrorMessage(e)
 	}
@@ -177,20 +236,25 @@ func (service *Service) getTableHandle(sqlConfig *SqlConfig) (*bigquery.Dataset,
 
 // CreateTable : creates table based on passed struct scheme
 func (service *Service) CreateTable(sqlConfig *SqlConfig, data *[]interface{}, recreate bool) (*bigquery.Table, *errortools.Error) {
-	dataset, tableHandle, e := service.getTableHandle(sqlConfig)
+	return service.CreateTableContext(service.context, sqlConfig, data, recreate)
+}
+
+// CreateTableContext is CreateTable honoring the cancellation and deadline of ctx
+func (service *Service) CreateTableContext(ctx context.Context, sqlConfig *SqlConfig, data *[]interface{}, recreate bool) (*bigquery.Table, *errortools.Error) {
+	dataset, tableHandle, e := service.getTableHandle(ctx, sqlConfig)
 	if e != nil {
 		return nil, e
 	}
 
 	// check whether table exists
-	exists, e := service.tableExists(dataset, tableHandle)
+	exists, e := service.tableExists(ctx, dataset, tableHandle)
 	if e != nil {
 		return nil, e
 	}
 
 	if exists && recreate {
 		// delete previous table
-		err := tableHandle.Delete(service.context)
+		err := tableHandle.Delete(ctx)
 		if err != nil {
 			return tableHandle, errortools.ErrorMessage(err)
 		}
@@ -203,12 +267,12 @@ func (service *Service) CreateTable(sqlConfig *SqlConfig, data *[]interface{}, r
 			return tableHandle, errortools.ErrorMessage(err)
 		}
 
-		if err := tableHandle.Create(service.context, &bigquery.TableMetadata{Schema: schema}); err != nil {
+		if err := tableHandle.Create(ctx, &bigquery.TableMetadata{Schema: schema}); err != nil {
 			return tableHandle, errortools.ErrorMessage(err)
 		}
 
 		count := 0
-		exists, e := service.tableExists(dataset, tableHandle)
+		exists, e := service.tableExists(ctx, dataset, tableHandle)
 		if e != nil {
 			return nil, e
 		}
@@ -217,7 +281,7 @@ func (service *Service) CreateTable(sqlConfig *SqlConfig, data *[]interface{}, r
 				break
 			}
 
-			exists, e = service.tableExists(dataset, tableHandle)
+			exists, e = service.tableExists(ctx, dataset, tableHandle)
 			if e != nil {
 				return tableHandle, e
 			}
@@ -228,7 +292,7 @@ func (service *Service) CreateTable(sqlConfig *SqlConfig, data *[]interface{}, r
 
 	if data != nil {
 		// insert data
-		e = service.Insert(tableHandle, *data)
+		e = service.InsertContext(ctx, tableHandle, *data)
 		if e != nil {
 			return nil, e
 		}
@@ -238,13 +302,18 @@ func (service *Service) CreateTable(sqlConfig *SqlConfig, data *[]interface{}, r
 }
 
 func (service *Service) DeleteTable(sqlConfig *SqlConfig) *errortools.Error {
-	dataset, tableHandle, e := service.getTableHandle(sqlConfig)
+	return service.DeleteTableContext(service.context, sqlConfig)
+}
+
+// DeleteTableContext is DeleteTable honoring the cancellation and deadline of ctx
+func (service *Service) DeleteTableContext(ctx context.Context, sqlConfig *SqlConfig) *errortools.Error {
+	dataset, tableHandle, e := service.getTableHandle(ctx, sqlConfig)
 	if e != nil {
 		return e
 	}
 
 	// check whether table exists
-	exists, e := service.tableExists(dataset, tableHandle)
+	exists, e := service.tableExists(ctx, dataset, tableHandle)
 	if e != nil {
 		return e
 	}
@@ -253,7 +322,7 @@ func (service *Service) DeleteTable(sqlConfig *SqlConfig) *errortools.Error {
 		return errortools.ErrorMessage(fmt.Sprintf("Table %s does not exist in dataset %s.", *sqlConfig.TableOrViewName, sqlConfig.DatasetName))
 	}
 
-	err := tableHandle.Delete(context.Background())
+	err := tableHandle.Delete(ctx)
 	if err != nil {
 		return errortools.ErrorMessage(err)
 	}
@@ -263,18 +332,30 @@ func (service *Service) DeleteTable(sqlConfig *SqlConfig) *errortools.Error {
 
 // Run is a generic function that runs the passed sql query in Service
 func (service *Service) Run(sql string, pendingMessage string) *errortools.Error {
+	return service.RunContext(service.context, sql, pendingMessage)
+}
+
+// RunContext is Run honoring the cancellation and deadline of ctx
+func (service *Service) RunContext(ctx context.Context, sql string, pendingMessage string) (e *errortools.Error) {
+	var job *bigquery.Job
+
+	ctx, span := service.startSpan(ctx, "bigquery.Run")
+	defer func() { endSpan(span, job, e) }()
+
 	q := service.bigQueryClient.Query(sql)
 
-	job, err := q.Run(service.context)
+	job, err := q.Run(ctx)
 	if err != nil {
 		return errortools.ErrorMessage(err)
 	}
 
-	fmt.Printf("\n%s...", pendingMessage)
-	defer fmt.Printf("\n")
+	if pendingMessage == "" {
+		pendingMessage = "running bigquery job"
+	}
+	service.logger.InfoContext(ctx, pendingMessage, "job_id", job.ID())
 
 	for {
-		status, err := job.Status(service.context)
+		status, err := job.Status(ctx)
 		if err != nil {
 			return errortools.ErrorMessage(err)
 		}
@@ -285,8 +366,15 @@ func (service *Service) Run(sql string, pendingMessage string) *errortools.Error
 			}
 			break
 		}
-		fmt.Printf(" ...")
-		time.Sleep(1 * time.Second)
+		service.logger.DebugContext(ctx, "bigquery job pending", "job_id", job.ID())
+
+		select {
+		case <-ctx.Done():
+			// ctx is done, so the job is cancelled with a fresh context
+			_ = job.Cancel(context.Background())
+			return errortools.ErrorMessage(ctx.Err())
+		case <-time.After(1 * time.Second):
+		}
 	}
 
 	return nil
@@ -294,6 +382,17 @@ func (service *Service) Run(sql string, pendingMessage string) *errortools.Error
 
 // Insert : generic function to batchwise stream data to a Service table
 func (service *Service) Insert(table *bigquery.Table, array []interface{}) *errortools.Error {
+	return service.InsertContext(service.context, table, array)
+}
+
+// InsertContext is Insert honoring the cancellation and deadline of ctx
+func (service *Service) InsertContext(ctx context.Context, table *bigquery.Table, array []interface{}) (e *errortools.Error) {
+	ctx, span := service.startSpan(ctx, "bigquery.Insert",
+		attribute.String("bigquery.table", table.FullyQualifiedName()),
+		attribute.Int("bigquery.rows", len(array)),
+	)
+	defer func() { endSpan(span, nil, e) }()
+
 	ins := table.Inserter()
 
 	batchSize := 1000
@@ -312,7 +411,7 @@ func (service *Service) Insert(table *bigquery.Table, array []interface{}) *erro
 			batchSize = len
 		}
 
-		err := ins.Put(service.context, slice[:batchSize])
+		err := ins.Put(ctx, slice[:batchSize])
 		if err != nil {
 			return errortools.ErrorMessage(err)
 		}
@@ -325,6 +424,11 @@ func (service *Service) Insert(table *bigquery.Table, array []interface{}) *erro
 
 // Select returns RowIterator from arbitrary select_ query (was: Get)
 func (service *Service) SelectRows(sqlConfig *SqlConfig) (*bigquery.RowIterator, *errortools.Error) {
+	return service.SelectRowsContext(service.context, sqlConfig)
+}
+
+// SelectRowsContext is SelectRows honoring the cancellation and deadline of ctx
+func (service *Service) SelectRowsContext(ctx context.Context, sqlConfig *SqlConfig) (*bigquery.RowIterator, *errortools.Error) {
 	var sql = ""
 
 	if sqlConfig.SqlRaw != nil {
@@ -377,10 +481,15 @@ func (service *Service) SelectRows(sqlConfig *SqlConfig) (*bigquery.RowIterator,
 	}
 	//fmt.Println(sql)
 
-	return service.select_(sql)
+	return service.select_(ctx, sql)
 }
 
 func (service *Service) Select(sqlConfig *SqlConfig, model interface{}) *errortools.Error {
+	return service.SelectContext(service.context, sqlConfig, model)
+}
+
+// SelectContext is Select honoring the cancellation and deadline of ctx
+func (service *Service) SelectContext(ctx context.Context, sqlConfig *SqlConfig, model interface{}) *errortools.Error {
 	if reflect.TypeOf(model).Kind() != reflect.Ptr {
 		return errortools.ErrorMessage("model must be a pointer to a slice")
 	}
@@ -389,7 +498,7 @@ func (service *Service) Select(sqlConfig *SqlConfig, model interface{}) *errorto
 	}
 
 	// run query
-	it, e := service.SelectRows(sqlConfig)
+	it, e := service.SelectRowsContext(ctx, sqlConfig)
 	if e != nil {
 		return e
 	}
@@ -405,7 +514,7 @@ func (service *Service) Select(sqlConfig *SqlConfig, model interface{}) *errorto
 			break
 		}
 		if err != nil {
-			log.Fatal(err)
+			return errortools.ErrorMessage(err)
 		}
 
 		b = reflect.Append(b, reflect.ValueOf(item).Elem())
@@ -419,14 +528,32 @@ func (service *Service) Select(sqlConfig *SqlConfig, model interface{}) *errorto
 
 // SelectRaw returns RowIterator from arbitrary select_ query (was: Get)
 func (service *Service) SelectRaw(sql string) (*bigquery.RowIterator, *errortools.Error) {
-	return service.select_(sql)
+	return service.SelectRawContext(service.context, sql)
+}
+
+// SelectRawContext is SelectRaw honoring the cancellation and deadline of ctx
+func (service *Service) SelectRawContext(ctx context.Context, sql string) (*bigquery.RowIterator, *errortools.Error) {
+	return service.select_(ctx, sql)
 }
 
 // select_ returns RowIterator from arbitrary select_ query
-func (service *Service) select_(sql string) (*bigquery.RowIterator, *errortools.Error) {
+func (service *Service) select_(ctx context.Context, sql string) (it *bigquery.RowIterator, e *errortools.Error) {
+	ctx, span := service.startSpan(ctx, "bigquery.Select")
+	defer func() {
+		var job *bigquery.Job
+		if it != nil {
+			job = it.SourceJob()
+		}
+		// the job of a read query has no status yet, fetch it for the statistics of the span
+		if job != nil && job.LastStatus() == nil {
+			_, _ = job.Status(ctx)
+		}
+		endSpan(span, job, e)
+	}()
+
 	q := service.bigQueryClient.Query(sql)
 
-	it, err := q.Read(service.context)
+	it, err := q.Read(ctx)
 	if err != nil {
 		return nil, errortools.ErrorMessage(err)
 	}
@@ -436,7 +563,12 @@ func (service *Service) select_(sql string) (*bigquery.RowIterator, *errortools.
 
 // Exists returns whether any arbitrary query returns any rows
 func (service *Service) Exists(sqlConfig *SqlConfig) (bool, *errortools.Error) {
-	it, e := service.SelectRows(sqlConfig)
+	return service.ExistsContext(service.context, sqlConfig)
+}
+
+// ExistsContext is Exists honoring the cancellation and deadline of ctx
+func (service *Service) ExistsContext(ctx context.Context, sqlConfig *SqlConfig) (bool, *errortools.Error) {
+	it, e := service.SelectRowsContext(ctx, sqlConfig)
 	if e != nil {
 		return false, e
 	}
@@ -452,6 +584,11 @@ func (service *Service) Exists(sqlConfig *SqlConfig) (bool, *errortools.Error) {
 
 // Delete deletes rows from table
 func (service *Service) Delete(sqlConfig *SqlConfig) *errortools.Error {
+	return service.DeleteContext(service.context, sqlConfig)
+}
+
+// DeleteContext is Delete honoring the cancellation and deadline of ctx
+func (service *Service) DeleteContext(ctx context.Context, sqlConfig *SqlConfig) *errortools.Error {
 	if sqlConfig == nil {
 		return errortools.ErrorMessage("sqlConfig is nil pointer")
 	}
@@ -472,12 +609,20 @@ func (service *Service) Delete(sqlConfig *SqlConfig) *errortools.Error {
 
 	//fmt.Println(sql)
 
-	return service.Run(sql, "deleting")
+	return service.RunContext(ctx, sql, "deleting")
 }
 
 // Merge runs merge query in Service, schema contains the table schema which needs to match the Service table.
 // All properties of model with suffix 'Json' will be ignored. All rows with Ignore = TRUE will be ignored as well.
 func (service *Service) Merge(sqlConfigSource *SqlConfig, sqlConfigTarget *SqlConfig, joinFields []string, doNotUpdateFields *[]string, hasIgnoreField bool) *errortools.Error {
+	return service.MergeContext(service.context, sqlConfigSource, sqlConfigTarget, joinFields, doNotUpdateFields, hasIgnoreField)
+}
+
+// MergeContext is Merge honoring the cancellation and deadline of ctx
+func (service *Service) MergeContext(ctx context.Context, sqlConfigSource *SqlConfig, sqlConfigTarget *SqlConfig, joinFields []string, doNotUpdateFields *[]string, hasIgnoreField bool) (e *errortools.Error) {
+	ctx, span := service.startSpan(ctx, "bigquery.Merge")
+	defer func() { endSpan(span, nil, e) }()
+
 	if sqlConfigSource == nil {
 		return errortools.ErrorMessage("sqlConfigSource is nil pointer")
 	}
@@ -535,12 +680,17 @@ func (service *Service) Merge(sqlConfigSource *SqlConfig, sqlConfigTarget *SqlCo
 	}
 	sql += " THEN INSERT(" + strings.Join(sqlInsert, ",") + ") VALUES(" + strings.Join(sqlValues, ",") + ")"
 
-	return service.Run(sql, "merging")
+	return service.RunContext(ctx, sql, "merging")
 }
 
 // GetValue returns one single value from query
 func (service *Service) GetValue(sqlConfig *SqlConfig) (*bigquery.Value, *errortools.Error) {
-	values, e := service.GetValues(sqlConfig)
+	return service.GetValueContext(service.context, sqlConfig)
+}
+
+// GetValueContext is GetValue honoring the cancellation and deadline of ctx
+func (service *Service) GetValueContext(ctx context.Context, sqlConfig *SqlConfig) (*bigquery.Value, *errortools.Error) {
+	values, e := service.GetValuesContext(ctx, sqlConfig)
 	if e != nil {
 		return nil, e
 	}
@@ -554,7 +704,12 @@ func (service *Service) GetValue(sqlConfig *SqlConfig) (*bigquery.Value, *errort
 
 // GetValues returns multiple values from query
 func (service *Service) GetValues(sqlConfig *SqlConfig) (*[]bigquery.Value, *errortools.Error) {
-	it, e := service.SelectRows(sqlConfig)
+	return service.GetValuesContext(service.context, sqlConfig)
+}
+
+// GetValuesContext is GetValues honoring the cancellation and deadline of ctx
+func (service *Service) GetValuesContext(ctx context.Context, sqlConfig *SqlConfig) (*[]bigquery.Value, *errortools.Error) {
+	it, e := service.SelectRowsContext(ctx, sqlConfig)
 	if e != nil {
 		return nil, e
 	}
@@ -575,11 +730,16 @@ func (service *Service) GetValues(sqlConfig *SqlConfig) (*[]bigquery.Value, *err
 
 // GetStruct returns struct from query
 func (service *Service) GetStruct(sqlConfig *SqlConfig, model interface{}) (uint64, *errortools.Error) {
+	return service.GetStructContext(service.context, sqlConfig, model)
+}
+
+// GetStructContext is GetStruct honoring the cancellation and deadline of ctx
+func (service *Service) GetStructContext(ctx context.Context, sqlConfig *SqlConfig, model interface{}) (uint64, *errortools.Error) {
 	if sqlConfig == nil {
 		return 0, errortools.ErrorMessage("SqlConfig must be a non-nil pointer")
 	}
 
-	it, e := service.SelectRows(sqlConfig)
+	it, e := service.SelectRowsContext(ctx, sqlConfig)
 	if e != nil {
 		return 0, e
 	}
@@ -608,6 +768,16 @@ type CopyObjectToTableConfig struct {
 
 // CopyObjectToTable copies content of GCS object to table
 func (service *Service) CopyObjectToTable(config *CopyObjectToTableConfig) *errortools.Error {
+	return service.CopyObjectToTableContext(service.context, config)
+}
+
+// CopyObjectToTableContext is CopyObjectToTable honoring the cancellation and deadline of ctx
+func (service *Service) CopyObjectToTableContext(ctx context.Context, config *CopyObjectToTableConfig) (e *errortools.Error) {
+	var job *bigquery.Job
+
+	ctx, span := service.startSpan(ctx, "bigquery.CopyObjectToTable")
+	defer func() { endSpan(span, job, e) }()
+
 	if config == nil {
 		return errortools.ErrorMessage("CopyObjectToTableConfig is nil pointer")
 	}
@@ -641,7 +811,7 @@ func (service *Service) CopyObjectToTable(config *CopyObjectToTableConfig) *erro
 	gcsRef.FileConfig = flConfig
 
 	// load data from GCN object to Service
-	_, tableHandle, e := service.getTableHandle(config.SqlConfig)
+	_, tableHandle, e := service.getTableHandle(ctx, config.SqlConfig)
 	if e != nil {
 		return e
 	}
@@ -654,7 +824,7 @@ func (service *Service) CopyObjectToTable(config *CopyObjectToTableConfig) *erro
 	}
 	loader.WriteDisposition = tableWriteDisposition
 
-	job, err := loader.Run(service.context)
+	job, err := loader.Run(ctx)
 	if err != nil {
 		return errortools.ErrorMessage(err)
 	}
@@ -663,7 +833,7 @@ func (service *Service) CopyObjectToTable(config *CopyObjectToTableConfig) *erro
 	pollInterval := 5 * time.Second
 
 	for {
-		status, err := job.Status(service.context)
+		status, err := job.Status(ctx)
 		if err != nil {
 			return errortools.ErrorMessage(err)
 		}
@@ -671,20 +841,26 @@ func (service *Service) CopyObjectToTable(config *CopyObjectToTableConfig) *erro
 		if status.Done() {
 			if status.Err() != nil {
 				for _, e := range status.Errors {
-					fmt.Println(e.Message)
+					service.logger.ErrorContext(ctx, "bigquery load job error", "job_id", job.ID(), "reason", e.Reason, "location", e.Location, "error", e.Message)
 				}
 
 				return errortools.ErrorMessage(fmt.Sprintf("Job failed with error %v", status.Err()))
 			}
 			break
 		}
-		time.Sleep(pollInterval)
+
+		select {
+		case <-ctx.Done():
+			_ = job.Cancel(context.Background())
+			return errortools.ErrorMessage(ctx.Err())
+		case <-time.After(pollInterval):
+		}
 	}
 	//}
 
 	if config.DeleteObject {
 		// delete GCS object
-		err = config.ObjectHandle.Delete(service.context)
+		err = config.ObjectHandle.Delete(ctx)
 		if err != nil {
 			return errortools.ErrorMessage(err)
 		}
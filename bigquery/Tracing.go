@@ -0,0 +1,48 @@
+package google
+
+import (
+	"context"
+
+	"cloud.google.com/go/bigquery"
+	errortools "github.com/leapforce-libraries/go_errortools"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName string = "github.com/leapforce-libraries/go_google/bigquery"
+
+// startSpan starts the span of a Service operation, e.g. bigquery.Run
+func (service *Service) startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return service.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...),
+	)
+}
+
+// endSpan records the job, if any, and the outcome of the operation on span and ends it
+func endSpan(span trace.Span, job *bigquery.Job, e *errortools.Error) {
+	if job != nil {
+		span.SetAttributes(attribute.String("bigquery.job_id", job.ID()))
+
+		if status := job.LastStatus(); status != nil && status.Statistics != nil {
+			span.SetAttributes(attribute.Int64("bigquery.total_bytes_processed", status.Statistics.TotalBytesProcessed))
+
+			if statistics, ok := status.Statistics.Details.(*bigquery.QueryStatistics); ok {
+				span.SetAttributes(
+					attribute.Int64("bigquery.total_bytes_billed", statistics.TotalBytesBilled),
+					attribute.Int64("bigquery.slot_millis", statistics.SlotMillis),
+				)
+			}
+			if statistics, ok := status.Statistics.Details.(*bigquery.LoadStatistics); ok {
+				span.SetAttributes(attribute.Int64("bigquery.output_rows", statistics.OutputRows))
+			}
+		}
+	}
+
+	if e != nil {
+		span.SetStatus(codes.Error, e.Message())
+	}
+
+	span.End()
+}
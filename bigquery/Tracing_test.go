@@ -0,0 +1,127 @@
+package google
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	errortools "github.com/leapforce-libraries/go_errortools"
+	testserver "github.com/leapforce-libraries/go_google/internal/testserver"
+	testtrace "github.com/leapforce-libraries/go_google/internal/testtrace"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/api/option"
+)
+
+const (
+	testQueryJob string = `{"jobReference":{"projectId":"project","jobId":"query-job","location":"EU"},` +
+		`"status":{"state":"DONE"},` +
+		`"statistics":{"totalBytesProcessed":"1024","query":{"totalBytesProcessed":"1024","totalBytesBilled":"10485760","totalSlotMs":"42"}}}`
+	testLoadJob string = `{"jobReference":{"projectId":"project","jobId":"load-job","location":"EU"},` +
+		`"status":{"state":"DONE"},` +
+		`"statistics":{"load":{"outputRows":"7"}}}`
+)
+
+// newTestService returns a Service whose BigQuery client uses a stand-in of the BigQuery api
+func newTestService(t *testing.T) (*Service, *testserver.Server, *testtrace.TracerProvider) {
+	server := testserver.New(t, map[string]http.HandlerFunc{
+		"POST /bigquery/v2/projects/project/queries": func(w http.ResponseWriter, r *http.Request) {
+			testserver.WriteJson(w, http.StatusOK, `{"kind":"bigquery#queryResponse",`+
+				`"jobReference":{"projectId":"project","jobId":"query-job","location":"EU"},"jobComplete":true,`+
+				`"schema":{"fields":[{"name":"n","type":"INTEGER"}]},"rows":[{"f":[{"v":"1"}]}],"totalRows":"1"}`)
+		},
+		"GET /bigquery/v2/projects/project/jobs/query-job": func(w http.ResponseWriter, r *http.Request) {
+			testserver.WriteJson(w, http.StatusOK, testQueryJob)
+		},
+		"GET /bigquery/v2/projects/project/jobs/load-job": func(w http.ResponseWriter, r *http.Request) {
+			testserver.WriteJson(w, http.StatusOK, testLoadJob)
+		},
+	})
+
+	client, err := bigquery.NewClient(context.Background(), "project",
+		option.WithEndpoint(server.URL+"/bigquery/v2/"),
+		option.WithoutAuthentication(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	tracerProvider := testtrace.NewTracerProvider()
+
+	return &Service{
+		bigQueryClient: client,
+		context:        context.Background(),
+		tracer:         tracerProvider.Tracer(tracerName),
+		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}, server, tracerProvider
+}
+
+func TestSelectSpan(t *testing.T) {
+	service, server, tracerProvider := newTestService(t)
+
+	it, e := service.SelectRaw("SELECT 1 AS n")
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	row := []bigquery.Value{}
+	err := it.Next(&row)
+	if err != nil || len(row) != 1 || row[0] != int64(1) {
+		t.Errorf("row is %v, %v", row, err)
+	}
+
+	// the status of the job is fetched for its statistics
+	if len(server.Requests("/bigquery/v2/projects/project/jobs/query-job")) != 1 {
+		t.Errorf("job status requested %v times", len(server.Requests("/bigquery/v2/projects/project/jobs/query-job")))
+	}
+
+	spans := tracerProvider.Spans()
+	if len(spans) != 1 || spans[0].Name != "bigquery.Select" || !spans[0].Ended {
+		t.Fatalf("spans %+v", spans)
+	}
+
+	attributes := map[string]string{
+		"bigquery.job_id":                "query-job",
+		"bigquery.total_bytes_processed": "1024",
+		"bigquery.total_bytes_billed":    "10485760",
+		"bigquery.slot_millis":           "42",
+	}
+	for key, value := range attributes {
+		if spans[0].Attribute(key) != value {
+			t.Errorf("span has %s %q, expected %q", key, spans[0].Attribute(key), value)
+		}
+	}
+	if spans[0].StatusCode == codes.Error {
+		t.Errorf("span has status %v: %s", spans[0].StatusCode, spans[0].StatusMessage)
+	}
+}
+
+func TestEndSpan(t *testing.T) {
+	service, _, tracerProvider := newTestService(t)
+
+	loadJob, err := service.bigQueryClient.JobFromID(context.Background(), "load-job")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, span := service.startSpan(context.Background(), "bigquery.CopyObjectToTable")
+	endSpan(span, loadJob, nil)
+
+	_, span = service.startSpan(context.Background(), "bigquery.Insert")
+	endSpan(span, nil, errortools.ErrorMessage("insert failed"))
+
+	spans := tracerProvider.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("%v spans", len(spans))
+	}
+
+	if spans[0].Attribute("bigquery.job_id") != "load-job" || spans[0].Attribute("bigquery.output_rows") != "7" || spans[0].StatusCode == codes.Error {
+		t.Errorf("span of the load job has attributes %v and status %v", spans[0].Attributes, spans[0].StatusCode)
+	}
+
+	if spans[1].Attribute("bigquery.job_id") != "" || spans[1].StatusCode != codes.Error || spans[1].StatusMessage != "insert failed" || !spans[1].Ended {
+		t.Errorf("span of the failed insert has attributes %v and status %v: %s", spans[1].Attributes, spans[1].StatusCode, spans[1].StatusMessage)
+	}
+}
@@ -1,5 +1,11 @@
 package google
 
+const (
+	TypeServiceAccount  string = "service_account"
+	TypeExternalAccount string = "external_account"
+	TypeAuthorizedUser  string = "authorized_user"
+)
+
 type CredentialsJson struct {
 	Type                    string `json:"type"`
 	ProjectId               string `json:"project_id"`
@@ -11,4 +17,41 @@ type CredentialsJson struct {
 	TokenUri                string `json:"token_uri"`
 	AuthProviderX509CertUrl string `json:"auth_provider_x509_cert_url"`
 	ClientX509CertUrl       string `json:"client_x509_cert_url"`
+
+	// authorized_user (gcloud application default credentials)
+	ClientSecret string `json:"client_secret,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+
+	// external_account (workload identity federation)
+	Audience                       string            `json:"audience,omitempty"`
+	SubjectTokenType               string            `json:"subject_token_type,omitempty"`
+	TokenUrl                       string            `json:"token_url,omitempty"`
+	ServiceAccountImpersonationUrl string            `json:"service_account_impersonation_url,omitempty"`
+	CredentialSource               *CredentialSource `json:"credential_source,omitempty"`
+	QuotaProjectId                 string            `json:"quota_project_id,omitempty"`
+}
+
+// CredentialSource describes where an external_account credential reads its subject token from
+type CredentialSource struct {
+	File                        string                      `json:"file,omitempty"`
+	Url                         string                      `json:"url,omitempty"`
+	Headers                     map[string]string           `json:"headers,omitempty"`
+	Executable                  *CredentialSourceExecutable `json:"executable,omitempty"`
+	Format                      *CredentialSourceFormat     `json:"format,omitempty"`
+	EnvironmentId               string                      `json:"environment_id,omitempty"`
+	RegionUrl                   string                      `json:"region_url,omitempty"`
+	RegionalCredVerificationUrl string                      `json:"regional_cred_verification_url,omitempty"`
+	Imdsv2SessionTokenUrl       string                      `json:"imdsv2_session_token_url,omitempty"`
+}
+
+type CredentialSourceExecutable struct {
+	Command       string `json:"command"`
+	TimeoutMillis int    `json:"timeout_millis,omitempty"`
+	OutputFile    string `json:"output_file,omitempty"`
+}
+
+// CredentialSourceFormat describes the format of a file or url subject token, Type is either "text" (default) or "json"
+type CredentialSourceFormat struct {
+	Type                  string `json:"type"`
+	SubjectTokenFieldName string `json:"subject_token_field_name,omitempty"`
 }
@@ -0,0 +1,183 @@
+package google
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	errortools "github.com/leapforce-libraries/go_errortools"
+)
+
+const (
+	applicationCredentialsEnvVar string        = "GOOGLE_APPLICATION_CREDENTIALS"
+	gcloudConfigEnvVar           string        = "CLOUDSDK_CONFIG"
+	metadataHostEnvVar           string        = "GCE_METADATA_HOST"
+	defaultMetadataHost          string        = "metadata.google.internal"
+	gcloudCredentialsFileName    string        = "application_default_credentials.json"
+	metadataProbeTimeout         time.Duration = 2 * time.Second
+)
+
+type DefaultCredentialsSource string
+
+const (
+	DefaultCredentialsSourceEnvironment DefaultCredentialsSource = "environment"
+	DefaultCredentialsSourceGcloud      DefaultCredentialsSource = "gcloud"
+	DefaultCredentialsSourceMetadata    DefaultCredentialsSource = "metadata"
+)
+
+// DefaultCredentialsConfig overrules the locations that are searched for Application Default Credentials
+type DefaultCredentialsConfig struct {
+	CredentialsFile *string // instead of GOOGLE_APPLICATION_CREDENTIALS
+	GcloudConfigDir *string // instead of CLOUDSDK_CONFIG or the default gcloud config directory
+	MetadataHost    *string // instead of GCE_METADATA_HOST or metadata.google.internal
+	HttpClient      *http.Client
+}
+
+// DefaultCredentials holds the Application Default Credentials that were found and where they came from
+type DefaultCredentials struct {
+	Source          DefaultCredentialsSource
+	Path            string           // file the credentials were read from, empty for the metadata server
+	CredentialsJson *CredentialsJson // nil for the metadata server
+	MetadataHost    string           // only set for the metadata server
+}
+
+// String describes the source of the credentials, including the file they were read from
+func (defaultCredentials *DefaultCredentials) String() string {
+	if defaultCredentials.Path == "" {
+		return string(defaultCredentials.Source)
+	}
+
+	return fmt.Sprintf("%s (%s)", defaultCredentials.Source, defaultCredentials.Path)
+}
+
+// FindDefaultCredentials searches for credentials in the GOOGLE_APPLICATION_CREDENTIALS file,
+// the gcloud application_default_credentials.json file and the metadata server, in that order
+func FindDefaultCredentials(cfg *DefaultCredentialsConfig) (*DefaultCredentials, *errortools.Error) {
+	if cfg == nil {
+		cfg = &DefaultCredentialsConfig{}
+	}
+
+	// 1. GOOGLE_APPLICATION_CREDENTIALS
+	credentialsFile := os.Getenv(applicationCredentialsEnvVar)
+	if cfg.CredentialsFile != nil {
+		credentialsFile = *cfg.CredentialsFile
+	}
+	if credentialsFile != "" {
+		credentialsJson, e := ReadCredentialsFile(credentialsFile)
+		if e != nil {
+			return nil, e
+		}
+
+		return &DefaultCredentials{
+			Source:          DefaultCredentialsSourceEnvironment,
+			Path:            credentialsFile,
+			CredentialsJson: credentialsJson,
+		}, nil
+	}
+
+	// 2. gcloud application default credentials
+	gcloudFile := filepath.Join(gcloudConfigDir(cfg), gcloudCredentialsFileName)
+	if _, err := os.Stat(gcloudFile); err == nil {
+		credentialsJson, e := ReadCredentialsFile(gcloudFile)
+		if e != nil {
+			return nil, e
+		}
+
+		return &DefaultCredentials{
+			Source:          DefaultCredentialsSourceGcloud,
+			Path:            gcloudFile,
+			CredentialsJson: credentialsJson,
+		}, nil
+	}
+
+	// 3. metadata server
+	metadataHost := MetadataHost(cfg)
+	if metadataServerAvailable(cfg.HttpClient, metadataHost) {
+		return &DefaultCredentials{
+			Source:       DefaultCredentialsSourceMetadata,
+			MetadataHost: metadataHost,
+		}, nil
+	}
+
+	return nil, errortools.ErrorMessagef("No default credentials found, set %s, run 'gcloud auth application-default login' or run on Google Cloud", applicationCredentialsEnvVar)
+}
+
+// ReadCredentialsFile reads a service_account, external_account or authorized_user credentials file
+func ReadCredentialsFile(path string) (*CredentialsJson, *errortools.Error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errortools.ErrorMessage(err)
+	}
+
+	credentialsJson := CredentialsJson{}
+	err = json.Unmarshal(b, &credentialsJson)
+	if err != nil {
+		return nil, errortools.ErrorMessagef("Invalid credentials file %s: %s", path, err.Error())
+	}
+
+	switch credentialsJson.Type {
+	case TypeServiceAccount, TypeExternalAccount, TypeAuthorizedUser:
+		return &credentialsJson, nil
+	}
+
+	return nil, errortools.ErrorMessagef("Credentials file %s has unsupported type '%s'", path, credentialsJson.Type)
+}
+
+// MetadataHost returns the metadata server host to use
+func MetadataHost(cfg *DefaultCredentialsConfig) string {
+	if cfg != nil && cfg.MetadataHost != nil {
+		return *cfg.MetadataHost
+	}
+
+	if host := os.Getenv(metadataHostEnvVar); host != "" {
+		return host
+	}
+
+	return defaultMetadataHost
+}
+
+func gcloudConfigDir(cfg *DefaultCredentialsConfig) string {
+	if cfg.GcloudConfigDir != nil {
+		return *cfg.GcloudConfigDir
+	}
+
+	if dir := os.Getenv(gcloudConfigEnvVar); dir != "" {
+		return dir
+	}
+
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "gcloud")
+	}
+
+	home, _ := os.UserHomeDir()
+
+	return filepath.Join(home, ".config", "gcloud")
+}
+
+func metadataServerAvailable(httpClient *http.Client, metadataHost string) bool {
+	client := http.Client{Timeout: metadataProbeTimeout}
+	if httpClient != nil {
+		client = *httpClient
+		if client.Timeout == 0 {
+			client.Timeout = metadataProbeTimeout
+		}
+	}
+
+	request, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/computeMetadata/v1/", metadataHost), nil)
+	if err != nil {
+		return false
+	}
+	request.Header.Set("Metadata-Flavor", "Google")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return false
+	}
+	defer response.Body.Close()
+
+	return response.Header.Get("Metadata-Flavor") == "Google"
+}
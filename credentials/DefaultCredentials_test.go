@@ -0,0 +1,181 @@
+package google
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	testserver "github.com/leapforce-libraries/go_google/internal/testserver"
+)
+
+const (
+	testServiceAccountJson string = `{"type":"service_account","client_email":"sa@project.iam.gserviceaccount.com"}`
+	testAuthorizedUserJson string = `{"type":"authorized_user","client_id":"client-id","refresh_token":"refresh-token"}`
+)
+
+// newMetadataServer returns a metadata server stand-in and its host, a server that is not a metadata server
+// omits the Metadata-Flavor response header
+func newMetadataServer(t *testing.T, isMetadataServer bool) (*testserver.Server, string) {
+	server := testserver.New(t, map[string]http.HandlerFunc{
+		"/computeMetadata/v1/": func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				t.Errorf("metadata probe with Metadata-Flavor %q", r.Header.Get("Metadata-Flavor"))
+			}
+			if isMetadataServer {
+				w.Header().Set("Metadata-Flavor", "Google")
+			}
+		},
+	})
+
+	return server, server.Host()
+}
+
+func writeTestFile(t *testing.T, path string, content string) {
+	err := os.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindDefaultCredentials(t *testing.T) {
+	_, metadataHost := newMetadataServer(t, true)
+	_, otherHost := newMetadataServer(t, false)
+	closedServer, closedHost := newMetadataServer(t, true)
+	closedServer.Close()
+
+	dir := t.TempDir()
+	credentialsFile := filepath.Join(dir, "credentials.json")
+	writeTestFile(t, credentialsFile, testServiceAccountJson)
+
+	gcloudDir := filepath.Join(dir, "gcloud")
+	err := os.Mkdir(gcloudDir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcloudFile := filepath.Join(gcloudDir, gcloudCredentialsFileName)
+	writeTestFile(t, gcloudFile, testAuthorizedUserJson)
+
+	emptyDir := filepath.Join(dir, "empty")
+	err = os.Mkdir(emptyDir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		credentialsFile string
+		gcloudDir       string
+		metadataHost    string
+		source          DefaultCredentialsSource
+		path            string
+		credentialsType string
+	}{
+		{"environment file first", credentialsFile, gcloudDir, metadataHost, DefaultCredentialsSourceEnvironment, credentialsFile, TypeServiceAccount},
+		{"gcloud file second", "", gcloudDir, metadataHost, DefaultCredentialsSourceGcloud, gcloudFile, TypeAuthorizedUser},
+		{"metadata server last", "", emptyDir, metadataHost, DefaultCredentialsSourceMetadata, "", ""},
+		{"nothing found", "", emptyDir, otherHost, "", "", ""},
+		{"metadata server unreachable", "", emptyDir, closedHost, "", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defaultCredentials, e := FindDefaultCredentials(&DefaultCredentialsConfig{
+				CredentialsFile: &test.credentialsFile,
+				GcloudConfigDir: &test.gcloudDir,
+				MetadataHost:    &test.metadataHost,
+			})
+
+			if test.source == "" {
+				if e == nil {
+					t.Fatalf("expected no credentials, found %s", defaultCredentials)
+				}
+				if !strings.Contains(e.Message(), applicationCredentialsEnvVar) {
+					t.Errorf("error %q does not explain where credentials are searched", e.Message())
+				}
+				return
+			}
+
+			if e != nil {
+				t.Fatal(e.Message())
+			}
+			if defaultCredentials.Source != test.source || defaultCredentials.Path != test.path {
+				t.Errorf("found %s, expected %s at %q", defaultCredentials, test.source, test.path)
+			}
+
+			if test.credentialsType == "" {
+				if defaultCredentials.CredentialsJson != nil || defaultCredentials.MetadataHost != test.metadataHost {
+					t.Errorf("metadata credentials %+v", defaultCredentials)
+				}
+				return
+			}
+			if defaultCredentials.CredentialsJson == nil || defaultCredentials.CredentialsJson.Type != test.credentialsType {
+				t.Errorf("credentials json %+v, expected type %s", defaultCredentials.CredentialsJson, test.credentialsType)
+			}
+		})
+	}
+}
+
+func TestFindDefaultCredentialsEnvironment(t *testing.T) {
+	_, metadataHost := newMetadataServer(t, true)
+
+	dir := t.TempDir()
+	credentialsFile := filepath.Join(dir, "credentials.json")
+	writeTestFile(t, credentialsFile, testServiceAccountJson)
+	writeTestFile(t, filepath.Join(dir, gcloudCredentialsFileName), testAuthorizedUserJson)
+
+	// without overrides the environment variables are used
+	t.Setenv(applicationCredentialsEnvVar, credentialsFile)
+	t.Setenv(gcloudConfigEnvVar, dir)
+	t.Setenv(metadataHostEnvVar, metadataHost)
+
+	defaultCredentials, e := FindDefaultCredentials(nil)
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	if defaultCredentials.Source != DefaultCredentialsSourceEnvironment || defaultCredentials.Path != credentialsFile {
+		t.Errorf("found %s", defaultCredentials)
+	}
+
+	t.Setenv(applicationCredentialsEnvVar, "")
+
+	defaultCredentials, e = FindDefaultCredentials(nil)
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	if defaultCredentials.Source != DefaultCredentialsSourceGcloud {
+		t.Errorf("found %s", defaultCredentials)
+	}
+
+	t.Setenv(gcloudConfigEnvVar, t.TempDir())
+
+	defaultCredentials, e = FindDefaultCredentials(nil)
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	if defaultCredentials.Source != DefaultCredentialsSourceMetadata || defaultCredentials.MetadataHost != metadataHost {
+		t.Errorf("found %s at %s", defaultCredentials, defaultCredentials.MetadataHost)
+	}
+}
+
+func TestFindDefaultCredentialsInvalidFile(t *testing.T) {
+	_, metadataHost := newMetadataServer(t, true)
+
+	dir := t.TempDir()
+	missingFile := filepath.Join(dir, "missing.json")
+	invalidFile := filepath.Join(dir, "invalid.json")
+	writeTestFile(t, invalidFile, `{"type":"unknown"}`)
+
+	// a configured file that cannot be used is an error, the search does not fall through to the next source
+	for _, credentialsFile := range []string{missingFile, invalidFile} {
+		_, e := FindDefaultCredentials(&DefaultCredentialsConfig{
+			CredentialsFile: &credentialsFile,
+			GcloudConfigDir: &dir,
+			MetadataHost:    &metadataHost,
+		})
+		if e == nil {
+			t.Errorf("expected an error for %s", credentialsFile)
+		}
+	}
+}
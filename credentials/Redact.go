@@ -0,0 +1,121 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+)
+
+const redacted string = "REDACTED"
+
+var redactions = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// PEM encoded private keys, also with escaped newlines inside json
+	{regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`), redacted},
+	// Authorization headers
+	{regexp.MustCompile(`(?i)\b(Bearer|Basic)(\s+)[^\s"',;]+`), "${1}${2}" + redacted},
+	// json properties, e.g. token responses and credentials files
+	{regexp.MustCompile(`(?i)("(?:access_token|refresh_token|id_token|client_secret|private_key|code_verifier|device_code)"\s*:\s*)"(?:[^"\\]|\\.)*"`), `${1}"` + redacted + `"`},
+	// query and form parameters
+	{regexp.MustCompile(`(?i)\b(key|access_token|refresh_token|id_token|client_secret|code|code_verifier|device_code|assertion|client_assertion|subject_token)=[^&\s"']+`), "${1}=" + redacted},
+	// bare Google access tokens, refresh tokens and api keys
+	{regexp.MustCompile(`\bya29\.[0-9A-Za-z._\-]+`), redacted},
+	{regexp.MustCompile(`\b1//[0-9A-Za-z_\-]{20,}`), redacted},
+	{regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}`), redacted},
+}
+
+// Redact replaces bearer tokens, api keys, refresh tokens, client secrets and private keys in s by REDACTED
+func Redact(s string) string {
+	for _, redaction := range redactions {
+		s = redaction.pattern.ReplaceAllString(s, redaction.replacement)
+	}
+
+	return s
+}
+
+// RedactHandler is a slog.Handler that redacts the message and the string, error and Stringer attributes
+// of its records, see Redact
+type RedactHandler struct {
+	handler slog.Handler
+}
+
+func NewRedactHandler(handler slog.Handler) *RedactHandler {
+	if _handler, ok := handler.(*RedactHandler); ok {
+		return _handler
+	}
+
+	return &RedactHandler{handler: handler}
+}
+
+func (h *RedactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *RedactHandler) Handle(ctx context.Context, record slog.Record) error {
+	_record := slog.NewRecord(record.Time, record.Level, Redact(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		_record.AddAttrs(redactAttr(attr))
+		return true
+	})
+
+	return h.handler.Handle(ctx, _record)
+}
+
+func (h *RedactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	_attrs := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		_attrs[i] = redactAttr(attr)
+	}
+
+	return &RedactHandler{handler: h.handler.WithAttrs(_attrs)}
+}
+
+func (h *RedactHandler) WithGroup(name string) slog.Handler {
+	return &RedactHandler{handler: h.handler.WithGroup(name)}
+}
+
+func redactAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, Redact(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		attrs := make([]any, len(group))
+		for i, _attr := range group {
+			attrs[i] = redactAttr(_attr)
+		}
+		return slog.Group(attr.Key, attrs...)
+	case slog.KindAny:
+		switch v := value.Any().(type) {
+		case error:
+			return slog.String(attr.Key, Redact(v.Error()))
+		case fmt.Stringer:
+			return slog.String(attr.Key, Redact(v.String()))
+		}
+	}
+
+	return slog.Attr{Key: attr.Key, Value: value}
+}
+
+// NewRedactLogger returns a logger that writes to the handler of logger through a RedactHandler,
+// or a logger that discards everything if logger is nil
+func NewRedactLogger(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.New(discardHandler{})
+	}
+
+	return slog.New(NewRedactHandler(logger.Handler()))
+}
+
+// discardHandler discards all records, slog.DiscardHandler requires go 1.24
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
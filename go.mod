@@ -10,6 +10,8 @@ require (
 	github.com/leapforce-libraries/go_http v0.0.0-20230420114702-86cc77fcf983
 	github.com/leapforce-libraries/go_oauth2 v0.0.0-20240328122659-9bea56888cd4
 	github.com/leapforce-libraries/go_types v0.0.0-20240717215204-bd3c2778b7f5
+	go.opentelemetry.io/otel v1.29.0
+	go.opentelemetry.io/otel/trace v1.29.0
 	google.golang.org/api v0.196.0
 )
 
@@ -41,9 +43,7 @@ require (
 	go.opencensus.io v0.24.0 // indirect
 	go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc v0.54.0 // indirect
 	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.54.0 // indirect
-	go.opentelemetry.io/otel v1.29.0 // indirect
 	go.opentelemetry.io/otel/metric v1.29.0 // indirect
-	go.opentelemetry.io/otel/trace v1.29.0 // indirect
 	golang.org/x/crypto v0.26.0 // indirect
 	golang.org/x/exp v0.0.0-20231006140011-7918f672742d // indirect
 	golang.org/x/mod v0.20.0 // indirect
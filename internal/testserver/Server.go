@@ -0,0 +1,89 @@
+// Package testserver serves stand-ins of Google endpoints in tests
+package testserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Request is a request received by a Server
+type Request struct {
+	Time   time.Time
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+}
+
+// Server routes requests to handlers by http.ServeMux pattern and records them,
+// a request without matching pattern is answered with 404
+type Server struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []Request
+}
+
+// New starts a Server, it is closed when the test ends
+func New(t testing.TB, handlers map[string]http.HandlerFunc) *Server {
+	mux := http.NewServeMux()
+	for pattern, handler := range handlers {
+		mux.HandleFunc(pattern, handler)
+	}
+
+	server := Server{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mutex.Lock()
+		server.requests = append(server.requests, Request{
+			Time:   time.Now(),
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Query:  r.URL.Query(),
+			Header: r.Header.Clone(),
+		})
+		server.mutex.Unlock()
+
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return &server
+}
+
+// Requests returns the requests received for path, or all requests if path is empty
+func (server *Server) Requests(path string) []Request {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	var requests []Request
+	for _, request := range server.requests {
+		if path == "" || request.Path == path {
+			requests = append(requests, request)
+		}
+	}
+
+	return requests
+}
+
+// Host returns the host and port of the Server
+func (server *Server) Host() string {
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// WriteJson writes v as json response with statusCode
+func WriteJson(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	switch v := v.(type) {
+	case string:
+		w.Write([]byte(v))
+	default:
+		json.NewEncoder(w).Encode(v)
+	}
+}
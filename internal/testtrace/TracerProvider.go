@@ -0,0 +1,109 @@
+// Package testtrace records the spans of a trace.TracerProvider in tests
+package testtrace
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Span is a span as recorded by a TracerProvider
+type Span struct {
+	noop.Span
+	Name          string
+	Kind          trace.SpanKind
+	Attributes    map[attribute.Key]attribute.Value
+	StatusCode    codes.Code
+	StatusMessage string
+	Ended         bool
+	provider      *TracerProvider
+}
+
+func (span *Span) SetAttributes(attributes ...attribute.KeyValue) {
+	span.provider.mutex.Lock()
+	defer span.provider.mutex.Unlock()
+
+	for _, keyValue := range attributes {
+		span.Attributes[keyValue.Key] = keyValue.Value
+	}
+}
+
+func (span *Span) SetStatus(code codes.Code, message string) {
+	span.provider.mutex.Lock()
+	defer span.provider.mutex.Unlock()
+
+	span.StatusCode = code
+	span.StatusMessage = message
+}
+
+func (span *Span) End(...trace.SpanEndOption) {
+	span.provider.mutex.Lock()
+	defer span.provider.mutex.Unlock()
+
+	span.Ended = true
+}
+
+// Attribute returns the value of attribute key as string, empty if the span does not have it
+func (span *Span) Attribute(key string) string {
+	span.provider.mutex.Lock()
+	defer span.provider.mutex.Unlock()
+
+	value, ok := span.Attributes[attribute.Key(key)]
+	if !ok {
+		return ""
+	}
+
+	return value.Emit()
+}
+
+// TracerProvider records the spans of its tracers
+type TracerProvider struct {
+	noop.TracerProvider
+	mutex sync.Mutex
+	spans []*Span
+}
+
+func NewTracerProvider() *TracerProvider {
+	return &TracerProvider{}
+}
+
+func (provider *TracerProvider) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return tracer{provider: provider}
+}
+
+// Spans returns the spans started so far
+func (provider *TracerProvider) Spans() []*Span {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	return append([]*Span{}, provider.spans...)
+}
+
+type tracer struct {
+	noop.Tracer
+	provider *TracerProvider
+}
+
+func (tracer tracer) Start(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	config := trace.NewSpanStartConfig(options...)
+
+	span := &Span{
+		Name:       name,
+		Kind:       config.SpanKind(),
+		Attributes: map[attribute.Key]attribute.Value{},
+		provider:   tracer.provider,
+	}
+	for _, keyValue := range config.Attributes() {
+		span.Attributes[keyValue.Key] = keyValue.Value
+	}
+
+	tracer.provider.mutex.Lock()
+	tracer.provider.spans = append(tracer.provider.spans, span)
+	tracer.provider.mutex.Unlock()
+
+	return trace.ContextWithSpan(ctx, span), span
+}